@@ -0,0 +1,131 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"github.com/name5566/leaf/conf"
+	"github.com/name5566/leaf/console"
+	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/metrics"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+	"time"
+)
+
+var server *http.Server
+
+// serves on conf.AdminAddr:
+//
+//	/metrics       - the Prometheus text format
+//	/debug/pprof/  - see net/http/pprof
+//	/console       - runs a console command, POST {"command": "help", "args": []}
+//
+// the requests must carry conf.AdminToken as "Authorization: Bearer <token>",
+// never in the URL which ends up in access logs, /console is disabled
+// without a token, and the server refuses to start without a token unless
+// conf.AdminAddr is a loopback address
+func Init() {
+	if conf.AdminAddr == "" {
+		return
+	}
+	if conf.AdminToken == "" && !isLoopback(conf.AdminAddr) {
+		log.Fatalf("AdminToken must be set to serve on %v", conf.AdminAddr)
+	}
+
+	ln, err := net.Listen("tcp", conf.AdminAddr)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	server = &http.Server{
+		Handler:      newMux(conf.AdminToken),
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 60 * time.Second,
+	}
+	go server.Serve(ln)
+}
+
+// localhost or a loopback IP, not the unspecified address
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func Destroy() {
+	if server != nil {
+		server.Close()
+	}
+}
+
+func newMux(token string) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", authorize(token, metrics.Handler()))
+	mux.Handle("/debug/pprof/", authorize(token, http.HandlerFunc(pprof.Index)))
+	mux.Handle("/debug/pprof/cmdline", authorize(token, http.HandlerFunc(pprof.Cmdline)))
+	mux.Handle("/debug/pprof/profile", authorize(token, http.HandlerFunc(pprof.Profile)))
+	mux.Handle("/debug/pprof/symbol", authorize(token, http.HandlerFunc(pprof.Symbol)))
+	mux.Handle("/debug/pprof/trace", authorize(token, http.HandlerFunc(pprof.Trace)))
+	if token != "" {
+		mux.Handle("/console", authorize(token, http.HandlerFunc(serveConsole)))
+	}
+	return mux
+}
+
+func authorize(token string, handler http.Handler) http.Handler {
+	if token == "" {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") ||
+			subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) != 1 {
+			http.Error(w, "Unauthorized", 401)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+type consoleRequest struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args"`
+}
+
+type consoleResponse struct {
+	Output string `json:"output,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+func serveConsole(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", 405)
+		return
+	}
+
+	var req consoleRequest
+	var resp consoleResponse
+	status := 200
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+		status = 400
+		resp.Error = err.Error()
+	} else if output, err := console.Exec(req.Command, req.Args); err != nil {
+		status = 404
+		resp.Error = err.Error()
+	} else {
+		resp.Output = output
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(&resp)
+}
@@ -0,0 +1,70 @@
+package admin
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func request(t *testing.T, mux *http.ServeMux, method string, url string, token string, body string) (int, string) {
+	r := httptest.NewRequest(method, url, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	b, _ := ioutil.ReadAll(w.Result().Body)
+	return w.Code, string(b)
+}
+
+func TestAdmin(t *testing.T) {
+	mux := newMux("secret")
+
+	if code, _ := request(t, mux, "GET", "/metrics", "", ""); code != 401 {
+		t.Fatalf("metrics without token: %v", code)
+	}
+	if code, _ := request(t, mux, "GET", "/metrics?token=secret", "", ""); code != 401 {
+		t.Fatalf("metrics with token parameter: %v", code)
+	}
+	code, body := request(t, mux, "GET", "/metrics", "secret", "")
+	if code != 200 || !strings.Contains(body, "# TYPE leaf_goroutines gauge\nleaf_goroutines ") {
+		t.Fatalf("metrics: %v %q", code, body)
+	}
+
+	code, body = request(t, mux, "POST", "/console", "secret", `{"command": "help"}`)
+	var resp consoleResponse
+	json.Unmarshal([]byte(body), &resp)
+	if code != 200 || !strings.Contains(resp.Output, "cpuprof") {
+		t.Fatalf("console: %v %q", code, body)
+	}
+	if code, _ := request(t, mux, "POST", "/console", "secret", `{"command": "nope"}`); code != 404 {
+		t.Fatalf("unknown command: %v", code)
+	}
+	if code, _ := request(t, mux, "POST", "/console", "wrong", `{"command": "help"}`); code != 401 {
+		t.Fatalf("console with wrong token: %v", code)
+	}
+
+	// the console is disabled without a token
+	if code, _ := request(t, newMux(""), "POST", "/console", "", `{"command": "help"}`); code != 404 {
+		t.Fatalf("console without token: %v", code)
+	}
+}
+
+func TestIsLoopback(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"127.0.0.1:6060": true,
+		"[::1]:6060":     true,
+		"localhost:6060": true,
+		":6060":          false,
+		"0.0.0.0:6060":   false,
+		"10.0.0.1:6060":  false,
+		"example.com:80": false,
+	} {
+		if isLoopback(addr) != want {
+			t.Errorf("%v: loopback %v, want %v", addr, !want, want)
+		}
+	}
+}
@@ -5,7 +5,12 @@ import (
 	"fmt"
 	"github.com/name5566/leaf/conf"
 	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/metrics"
+	"github.com/name5566/leaf/trace"
 	"runtime"
+	"sort"
+	"sync"
+	"time"
 )
 
 // one server per goroutine (goroutine not safe)
@@ -19,13 +24,48 @@ type Server struct {
 	// func(args []interface{}) []interface{}
 	functions map[interface{}]interface{}
 	ChanCall  chan *CallInfo
+	name      string
+	latency   *metrics.HistogramValue
+	// the span of the call executed
+	span trace.SpanContext
+	// nil for the package functions of log
+	logger log.Logger
 }
 
 type CallInfo struct {
+	id      interface{}
 	f       interface{}
 	args    []interface{}
 	chanRet chan *RetInfo
 	cb      interface{}
+	start   time.Time
+	// the span of the caller
+	trace trace.SpanContext
+}
+
+var (
+	mutexNamed sync.Mutex
+	named      = make(map[*Server]struct{})
+
+	latency = metrics.NewHistogram("leaf_chanrpc_latency_seconds", "Time from the call to the end of its execution.", nil, "server")
+)
+
+func init() {
+	metrics.NewGaugeFunc("leaf_chanrpc_queue_length", "Calls waiting in the queue.", func(emit func(float64, ...string)) {
+		mutexNamed.Lock()
+		servers := make([]*Server, 0, len(named))
+		for s := range named {
+			servers = append(servers, s)
+		}
+		mutexNamed.Unlock()
+
+		sort.Slice(servers, func(i, j int) bool {
+			return servers[i].name < servers[j].name
+		})
+		for _, s := range servers {
+			emit(float64(len(s.ChanCall)), s.name)
+		}
+	}, "server")
 }
 
 type RetInfo struct {
@@ -38,7 +78,8 @@ type RetInfo struct {
 	// func(err error)
 	// func(ret interface{}, err error)
 	// func(ret []interface{}, err error)
-	cb interface{}
+	cb    interface{}
+	trace trace.SpanContext
 }
 
 type Client struct {
@@ -46,6 +87,12 @@ type Client struct {
 	chanSyncRet     chan *RetInfo
 	ChanAsynRet     chan *RetInfo
 	pendingAsynCall int
+	// the span of the calls made, see SetSpan
+	callSpan trace.SpanContext
+	// the span of the callback executed
+	span trace.SpanContext
+	// nil for the package functions of log
+	logger log.Logger
 }
 
 func NewServer(l int) *Server {
@@ -80,6 +127,32 @@ func (s *Server) Register(id interface{}, f interface{}) {
 	s.functions[id] = f
 }
 
+// a named server reports its queue length and latency to metrics
+// you must call the function before calling Open and Go
+func (s *Server) SetName(name string) {
+	s.name = name
+	s.latency = latency.With(name)
+
+	mutexNamed.Lock()
+	named[s] = struct{}{}
+	mutexNamed.Unlock()
+}
+
+// the errors of the calls are logged by logger, by the package functions of
+// log if nil, see module.Skeleton.Logger
+// you must call the function before calling Exec
+func (s *Server) SetLogger(logger log.Logger) {
+	s.logger = logger
+}
+
+func (s *Server) errorf(format string, v ...interface{}) {
+	if s.logger == nil {
+		log.Errorf(format, v...)
+		return
+	}
+	s.logger.Errorf(format, v...)
+}
+
 func (s *Server) ret(ci *CallInfo, ri *RetInfo) (err error) {
 	if ci.chanRet == nil {
 		return
@@ -92,6 +165,7 @@ func (s *Server) ret(ci *CallInfo, ri *RetInfo) (err error) {
 	}()
 
 	ri.cb = ci.cb
+	ri.trace = ci.trace
 	ci.chanRet <- ri
 	return
 }
@@ -127,15 +201,53 @@ func (s *Server) exec(ci *CallInfo) (err error) {
 	panic("bug")
 }
 
+// the call is traced in a child span of the caller's span
+func (s *Server) startSpan(ci *CallInfo) *trace.Span {
+	if !ci.trace.IsValid() {
+		return nil
+	}
+
+	name := fmt.Sprintf("chanrpc %v", ci.id)
+	if s.name != "" {
+		name = fmt.Sprintf("chanrpc %v %v", s.name, ci.id)
+	}
+	return trace.StartFrom(ci.trace, name)
+}
+
 func (s *Server) Exec(ci *CallInfo) {
+	span := s.startSpan(ci)
+	prev := s.span
+	s.span = span.Context()
+
 	err := s.exec(ci)
 	if err != nil {
-		log.Errorf("%v", err)
+		s.errorf("%v", err)
+	}
+
+	s.span = prev
+	if span != nil {
+		span.SetError(err)
+		span.End()
+	}
+	if s.latency != nil && !ci.start.IsZero() {
+		s.latency.Observe(time.Since(ci.start).Seconds())
 	}
 }
 
+// the span of the call executed, zero if none or if the call is not traced
+// goroutine not safe, the goroutine of Exec
+func (s *Server) Span() trace.SpanContext {
+	return s.span
+}
+
 // goroutine safe
 func (s *Server) Go(id interface{}, args ...interface{}) {
+	s.GoFrom(trace.SpanContext{}, id, args...)
+}
+
+// the call is a child of parent
+// goroutine safe
+func (s *Server) GoFrom(parent trace.SpanContext, id interface{}, args ...interface{}) {
 	f := s.functions[id]
 	if f == nil {
 		return
@@ -145,10 +257,18 @@ func (s *Server) Go(id interface{}, args ...interface{}) {
 		recover()
 	}()
 
-	s.ChanCall <- &CallInfo{
+	ci := &CallInfo{
+		id:   id,
 		f:    f,
 		args: args,
 	}
+	if s.latency != nil {
+		ci.start = time.Now()
+	}
+	if trace.Enabled() {
+		ci.trace = parent
+	}
+	s.ChanCall <- ci
 }
 
 // goroutine safe
@@ -167,6 +287,10 @@ func (s *Server) CallN(id interface{}, args ...interface{}) ([]interface{}, erro
 }
 
 func (s *Server) Close() {
+	mutexNamed.Lock()
+	delete(named, s)
+	mutexNamed.Unlock()
+
 	close(s.ChanCall)
 
 	for ci := range s.ChanCall {
@@ -194,6 +318,31 @@ func (c *Client) Attach(s *Server) {
 	c.s = s
 }
 
+// the calls made next are children of sc, none if sc is not valid
+func (c *Client) SetSpan(sc trace.SpanContext) {
+	c.callSpan = sc
+}
+
+// the panics of the callbacks are logged by logger, by the package functions
+// of log if nil
+func (c *Client) SetLogger(logger log.Logger) {
+	c.logger = logger
+}
+
+func (c *Client) errorf(format string, v ...interface{}) {
+	if c.logger == nil {
+		log.Errorf(format, v...)
+		return
+	}
+	c.logger.Errorf(format, v...)
+}
+
+// the span of the call whose callback is executed, zero if none
+// goroutine not safe, the goroutine of Cb
+func (c *Client) Span() trace.SpanContext {
+	return c.span
+}
+
 func (c *Client) call(ci *CallInfo, block bool) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -201,6 +350,13 @@ func (c *Client) call(ci *CallInfo, block bool) (err error) {
 		}
 	}()
 
+	if c.s.latency != nil {
+		ci.start = time.Now()
+	}
+	if trace.Enabled() {
+		ci.trace = c.callSpan
+	}
+
 	if block {
 		c.s.ChanCall <- ci
 	} else {
@@ -250,6 +406,7 @@ func (c *Client) Call0(id interface{}, args ...interface{}) error {
 	}
 
 	err = c.call(&CallInfo{
+		id:      id,
 		f:       f,
 		args:    args,
 		chanRet: c.chanSyncRet,
@@ -269,6 +426,7 @@ func (c *Client) Call1(id interface{}, args ...interface{}) (interface{}, error)
 	}
 
 	err = c.call(&CallInfo{
+		id:      id,
 		f:       f,
 		args:    args,
 		chanRet: c.chanSyncRet,
@@ -288,6 +446,7 @@ func (c *Client) CallN(id interface{}, args ...interface{}) ([]interface{}, erro
 	}
 
 	err = c.call(&CallInfo{
+		id:      id,
 		f:       f,
 		args:    args,
 		chanRet: c.chanSyncRet,
@@ -308,6 +467,7 @@ func (c *Client) asynCall(id interface{}, args []interface{}, cb interface{}, n
 	}
 
 	err = c.call(&CallInfo{
+		id:      id,
 		f:       f,
 		args:    args,
 		chanRet: c.ChanAsynRet,
@@ -341,7 +501,7 @@ func (c *Client) AsynCall(id interface{}, _args ...interface{}) {
 
 	// too many calls
 	if c.pendingAsynCall >= cap(c.ChanAsynRet) {
-		execCb(&RetInfo{err: errors.New("too many calls"), cb: cb})
+		c.execCb(&RetInfo{err: errors.New("too many calls"), cb: cb})
 		return
 	}
 
@@ -349,15 +509,15 @@ func (c *Client) AsynCall(id interface{}, _args ...interface{}) {
 	c.pendingAsynCall++
 }
 
-func execCb(ri *RetInfo) {
+func (c *Client) execCb(ri *RetInfo) {
 	defer func() {
 		if r := recover(); r != nil {
 			if conf.LenStackBuf > 0 {
 				buf := make([]byte, conf.LenStackBuf)
 				l := runtime.Stack(buf, false)
-				log.Errorf("%v: %s", r, buf[:l])
+				c.errorf("%v: %s", r, buf[:l])
 			} else {
-				log.Errorf("%v", r)
+				c.errorf("%v", r)
 			}
 		}
 	}()
@@ -376,9 +536,13 @@ func execCb(ri *RetInfo) {
 	return
 }
 
+// the callback runs in the span of the call, see Span
 func (c *Client) Cb(ri *RetInfo) {
 	c.pendingAsynCall--
-	execCb(ri)
+	prev := c.span
+	c.span = ri.trace
+	c.execCb(ri)
+	c.span = prev
 }
 
 func (c *Client) Close() {
@@ -7,6 +7,17 @@ var (
 	LogLevel string
 	LogPath  string
 	LogFlag  int
+	// see log.Config
+	LogFileName      string
+	LogErrorFileName string
+	LogNoErrorFile   bool
+	LogStdout        bool
+	LogMaxSize       int
+	LogMaxAge        int
+	LogMaxBackups    int
+	LogCompress      bool
+	LogEncoding      string
+	LogTimeFormat    string
 
 	// console
 	ConsolePort   int
@@ -14,6 +25,16 @@ var (
 	ConsolePrompt string = "Leaf# "
 	ProfilePath   string
 
+	// trace, the spans are appended to TraceFile in the OTLP/JSON format
+	TraceFile string
+
+	// jobs, the last runs of the jobs of module.Skeleton.CronJob
+	JobFile string = "jobs.json"
+
+	// admin
+	AdminAddr  string
+	AdminToken string
+
 	// cluster
 	ListenAddr      string
 	ConnAddrs       []string
@@ -8,6 +8,8 @@ import (
 	"os"
 	"path"
 	"runtime/pprof"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -15,8 +17,15 @@ var commands = []Command{
 	new(CommandHelp),
 	new(CommandCPUProf),
 	new(CommandProf),
+	new(CommandLogLevel),
+	new(CommandJobs),
 }
 
+var (
+	jobServers      []*chanrpc.Server
+	mutexJobServers sync.Mutex
+)
+
 type Command interface {
 	// must goroutine safe
 	name() string
@@ -58,6 +67,17 @@ func (c *ExternalCommand) run(_args []string) string {
 	return output
 }
 
+// runs a registered command, as typed in the console
+// goroutine safe
+func Exec(name string, args []string) (string, error) {
+	for _, c := range commands {
+		if c.name() == name {
+			return c.run(args), nil
+		}
+	}
+	return "", fmt.Errorf("command %v not found", name)
+}
+
 // you must call the function before calling console.Init
 // goroutine not safe
 func Register(name string, help string, f interface{}, server *chanrpc.Server) {
@@ -213,3 +233,119 @@ func (c *CommandProf) run(args []string) string {
 
 	return fn
 }
+
+// loglevel
+type CommandLogLevel struct{}
+
+func (c *CommandLogLevel) name() string {
+	return "loglevel"
+}
+
+func (c *CommandLogLevel) help() string {
+	return "changes the log level"
+}
+
+func (c *CommandLogLevel) usage() string {
+	return "loglevel changes the log level at runtime\r\n\r\n" +
+		"Usage: loglevel [name] debug|info|warn|error|panic|fatal|default\r\n" +
+		"  name    - the loggers named name and their children, as a module,\r\n" +
+		"            see module.Skeleton.Logger and log.Named\r\n" +
+		"  default - removes the level of the name"
+}
+
+func (c *CommandLogLevel) run(args []string) string {
+	var err error
+	switch {
+	case len(args) == 1 && args[0] != "default":
+		err = log.SetLevel(args[0])
+	case len(args) == 2 && args[1] == "default":
+		err = log.SetNamedLevel(args[0], "")
+	case len(args) == 2:
+		err = log.SetNamedLevel(args[0], args[1])
+	default:
+		return c.usage()
+	}
+
+	if err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+// the server answers "jobs.list" with the jobs as a string and "jobs.run"
+// with false if the job is not found, see module.Skeleton.CronJob
+// goroutine safe
+func RegisterJobs(server *chanrpc.Server) {
+	mutexJobServers.Lock()
+	defer mutexJobServers.Unlock()
+	jobServers = append(jobServers, server)
+}
+
+// you must call the function before closing the server
+// goroutine safe
+func UnregisterJobs(server *chanrpc.Server) {
+	mutexJobServers.Lock()
+	defer mutexJobServers.Unlock()
+	for i, s := range jobServers {
+		if s == server {
+			// copied, the command may range over the old slice
+			servers := make([]*chanrpc.Server, 0, len(jobServers)-1)
+			servers = append(servers, jobServers[:i]...)
+			jobServers = append(servers, jobServers[i+1:]...)
+			return
+		}
+	}
+}
+
+// jobs
+type CommandJobs struct{}
+
+func (c *CommandJobs) name() string {
+	return "jobs"
+}
+
+func (c *CommandJobs) help() string {
+	return "lists and runs the scheduled jobs"
+}
+
+func (c *CommandJobs) usage() string {
+	return "jobs lists and runs the jobs of the modules\r\n\r\n" +
+		"Usage: jobs list|run <name>\r\n" +
+		"  list - the jobs with their last and next runs\r\n" +
+		"  run  - runs the job named name now"
+}
+
+func (c *CommandJobs) run(args []string) string {
+	mutexJobServers.Lock()
+	servers := jobServers
+	mutexJobServers.Unlock()
+
+	switch {
+	case len(args) == 1 && args[0] == "list":
+		var output []string
+		for _, server := range servers {
+			ret, err := server.Call1("jobs.list")
+			if err != nil {
+				continue
+			}
+			if jobs, ok := ret.(string); ok && jobs != "" {
+				output = append(output, jobs)
+			}
+		}
+		return strings.Join(output, "\r\n")
+	case len(args) == 2 && args[0] == "run":
+		// a server closing is skipped
+		for _, server := range servers {
+			ret, err := server.Call1("jobs.run", args[1])
+			if err != nil {
+				continue
+			}
+			if ran, ok := ret.(bool); ok && ran {
+				return ""
+			}
+		}
+		return fmt.Sprintf("job %v not found", args[1])
+	default:
+		return c.usage()
+	}
+}
@@ -0,0 +1,293 @@
+package future
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/name5566/leaf/conf"
+	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/timer"
+	"runtime"
+	"time"
+)
+
+var (
+	ErrTimeout   = errors.New("future: timeout")
+	ErrNoFutures = errors.New("future: no futures")
+)
+
+// the timers of WithTimeout, as a module.Skeleton
+type Timers interface {
+	AfterFunc(d time.Duration, cb func()) *timer.Timer
+}
+
+// the result of an asynchronous operation, the callbacks run on the goroutine
+// resolving it, the goroutine of the module for the futures of a Skeleton
+// one future per goroutine (goroutine not safe)
+type Future struct {
+	timers  Timers
+	done    bool
+	value   interface{}
+	err     error
+	cbs     []func(value interface{}, err error)
+	cancels []func()
+	// the futures of Then, Catch, WithTimeout, All and Any waiting for f
+	dependents int
+}
+
+// timers may be nil if WithTimeout is not used
+func New(timers Timers) *Future {
+	f := new(Future)
+	f.timers = timers
+	return f
+}
+
+func Resolved(timers Timers, value interface{}, err error) *Future {
+	f := New(timers)
+	f.Resolve(value, err)
+	return f
+}
+
+// the first resolution wins, the next ones are ignored
+func (f *Future) Resolve(value interface{}, err error) {
+	if f.done {
+		return
+	}
+
+	f.done = true
+	f.value = value
+	f.err = err
+	f.cancels = nil
+	cbs := f.cbs
+	f.cbs = nil
+	for _, cb := range cbs {
+		cb(value, err)
+	}
+}
+
+func (f *Future) Done() bool {
+	return f.done
+}
+
+// nil and nil until resolved
+func (f *Future) Result() (interface{}, error) {
+	return f.value, f.err
+}
+
+// cb is called when f is resolved, at once if it is
+func (f *Future) OnDone(cb func(value interface{}, err error)) {
+	if f.done {
+		cb(f.value, f.err)
+		return
+	}
+	f.cbs = append(f.cbs, cb)
+}
+
+// cancel is called by Cancel if f is not resolved yet, to stop the operation
+// of f
+func (f *Future) OnCancel(cancel func()) {
+	if f.done {
+		return
+	}
+	f.cancels = append(f.cancels, cancel)
+}
+
+// f is resolved with context.Canceled, its operation is stopped, the
+// futures f depends on, as the future of Then, are cancelled unless other
+// futures still depend on them
+func (f *Future) Cancel() {
+	if f.done {
+		return
+	}
+
+	cancels := f.cancels
+	f.Resolve(nil, context.Canceled)
+	for _, cancel := range cancels {
+		cancel()
+	}
+}
+
+// a future depends on f until release
+func (f *Future) depend() {
+	f.dependents++
+}
+
+// f is cancelled once no future depends on it
+func (f *Future) release() {
+	f.dependents--
+	if f.dependents == 0 {
+		f.Cancel()
+	}
+}
+
+// then is called with the value of f, unless f fails, the future returned
+// is resolved with the results of then, or follows the future then returns
+func (f *Future) Then(then func(value interface{}) (interface{}, error)) *Future {
+	next := New(f.timers)
+	f.depend()
+	next.OnCancel(f.release)
+	f.OnDone(func(value interface{}, err error) {
+		if err != nil {
+			next.Resolve(nil, err)
+			return
+		}
+		next.follow(safeCall(func() (interface{}, error) {
+			return then(value)
+		}))
+	})
+	return next
+}
+
+// catch is called with the error of f, if f fails, as Then
+func (f *Future) Catch(catch func(err error) (interface{}, error)) *Future {
+	next := New(f.timers)
+	f.depend()
+	next.OnCancel(f.release)
+	f.OnDone(func(value interface{}, err error) {
+		if err == nil {
+			next.Resolve(value, nil)
+			return
+		}
+		next.follow(safeCall(func() (interface{}, error) {
+			return catch(err)
+		}))
+	})
+	return next
+}
+
+// f is resolved with value and err, or as value if it is a future
+func (f *Future) follow(value interface{}, err error) {
+	if other, ok := value.(*Future); ok && err == nil {
+		other.depend()
+		f.OnCancel(other.release)
+		other.OnDone(f.Resolve)
+		return
+	}
+	f.Resolve(value, err)
+}
+
+// the panic of fn is logged and returned as an error
+func safeCall(fn func() (interface{}, error)) (value interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if conf.LenStackBuf > 0 {
+				buf := make([]byte, conf.LenStackBuf)
+				l := runtime.Stack(buf, false)
+				log.Errorf("%v: %s", r, buf[:l])
+			} else {
+				log.Errorf("%v", r)
+			}
+			value = nil
+			err = fmt.Errorf("future: %v", r)
+		}
+	}()
+
+	return fn()
+}
+
+// fails with ErrTimeout, and cancels f unless other futures depend on it,
+// if f is not resolved within d
+func (f *Future) WithTimeout(d time.Duration) *Future {
+	if f.timers == nil {
+		panic("future without timers")
+	}
+
+	next := New(f.timers)
+	f.depend()
+	next.OnCancel(f.release)
+	t := f.timers.AfterFunc(d, func() {
+		next.Resolve(nil, ErrTimeout)
+		f.release()
+	})
+	f.OnDone(func(value interface{}, err error) {
+		t.Stop()
+		next.Resolve(value, err)
+	})
+	return next
+}
+
+func timersOf(fs []*Future) Timers {
+	for _, f := range fs {
+		if f.timers != nil {
+			return f.timers
+		}
+	}
+	return nil
+}
+
+// the values of fs, in order, as a []interface{}, the first error of fs
+// cancels the others, unless other futures depend on them
+func All(fs ...*Future) *Future {
+	all := New(timersOf(fs))
+	values := make([]interface{}, len(fs))
+	left := len(fs)
+	if left == 0 {
+		all.Resolve(values, nil)
+		return all
+	}
+
+	for _, f := range fs {
+		f.depend()
+	}
+	cancel := func() {
+		for _, f := range fs {
+			f.release()
+		}
+	}
+	all.OnCancel(cancel)
+	for i, f := range fs {
+		i := i
+		f.OnDone(func(value interface{}, err error) {
+			if all.done {
+				return
+			}
+			if err != nil {
+				all.Resolve(nil, err)
+				cancel()
+				return
+			}
+			values[i] = value
+			left--
+			if left == 0 {
+				all.Resolve(values, nil)
+			}
+		})
+	}
+	return all
+}
+
+// the value of the first of fs to succeed, which cancels the others, unless
+// other futures depend on them, the error of the last one if all fail
+func Any(fs ...*Future) *Future {
+	first := New(timersOf(fs))
+	left := len(fs)
+	if left == 0 {
+		first.Resolve(nil, ErrNoFutures)
+		return first
+	}
+
+	for _, f := range fs {
+		f.depend()
+	}
+	cancel := func() {
+		for _, f := range fs {
+			f.release()
+		}
+	}
+	first.OnCancel(cancel)
+	for _, f := range fs {
+		f.OnDone(func(value interface{}, err error) {
+			if first.done {
+				return
+			}
+			left--
+			if err == nil {
+				first.Resolve(value, nil)
+				cancel()
+			} else if left == 0 {
+				first.Resolve(nil, err)
+			}
+		})
+	}
+	return first
+}
@@ -0,0 +1,166 @@
+package future_test
+
+import (
+	"context"
+	"errors"
+	"github.com/name5566/leaf/future"
+	"github.com/name5566/leaf/timer"
+	"testing"
+	"time"
+)
+
+// the timers of the futures, fired by advance on the goroutine of the test
+type fakeTimers struct {
+	*timer.Dispatcher
+	clock *timer.FakeClock
+}
+
+func newFakeTimers() *fakeTimers {
+	clock := timer.NewFakeClock(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	return &fakeTimers{timer.NewDispatcherWithClock(100, clock), clock}
+}
+
+func (ts *fakeTimers) advance(d time.Duration) {
+	ts.clock.Advance(d)
+	for {
+		select {
+		case t := <-ts.ChanTimer:
+			t.Cb()
+		default:
+			return
+		}
+	}
+}
+
+// resolved with value after d
+func (ts *fakeTimers) after(d time.Duration, value interface{}) *future.Future {
+	f := future.New(ts)
+	t := ts.AfterFunc(d, func() { f.Resolve(value, nil) })
+	f.OnCancel(t.Stop)
+	return f
+}
+
+func TestThen(t *testing.T) {
+	ts := newFakeTimers()
+
+	f := ts.after(time.Second, 1).Then(func(value interface{}) (interface{}, error) {
+		// a future is followed
+		return ts.after(time.Second, value.(int)+1), nil
+	}).Then(func(value interface{}) (interface{}, error) {
+		return value.(int) * 10, nil
+	})
+	ts.advance(time.Second)
+	if f.Done() {
+		t.Fatal("resolved before the future returned")
+	}
+	ts.advance(time.Second)
+	if v, err := f.Result(); v != 20 || err != nil {
+		t.Fatalf("value %v, err %v", v, err)
+	}
+
+	// errors skip Then, up to Catch
+	errOdd := errors.New("odd")
+	called := false
+	f = future.Resolved(ts, nil, errOdd).Then(func(interface{}) (interface{}, error) {
+		called = true
+		return nil, nil
+	}).Catch(func(err error) (interface{}, error) {
+		return err.Error(), nil
+	})
+	if v, err := f.Result(); called || v != "odd" || err != nil {
+		t.Fatalf("value %v, err %v", v, err)
+	}
+
+	// panics are errors
+	f = future.Resolved(ts, 1, nil).Then(func(interface{}) (interface{}, error) {
+		panic("then")
+	})
+	if _, err := f.Result(); err == nil {
+		t.Fatal("panic not returned")
+	}
+}
+
+func TestAllAny(t *testing.T) {
+	ts := newFakeTimers()
+
+	all := future.All(ts.after(2*time.Second, "a"), ts.after(time.Second, "b"))
+	ts.advance(2 * time.Second)
+	if v, err := all.Result(); err != nil || len(v.([]interface{})) != 2 || v.([]interface{})[0] != "a" {
+		t.Fatalf("value %v, err %v", v, err)
+	}
+
+	// the first error cancels the others
+	slow := ts.after(time.Hour, "slow")
+	all = future.All(slow, future.Resolved(ts, nil, errors.New("failed")))
+	if _, err := all.Result(); err == nil || !slow.Done() || ts.Pending() != 0 {
+		t.Fatalf("err %v, slow cancelled %v, %v timers", err, slow.Done(), ts.Pending())
+	}
+
+	// the first to succeed cancels the others
+	first := future.Any(ts.after(time.Hour, "slow"), future.Resolved(ts, nil, errors.New("failed")), ts.after(time.Second, "fast"))
+	ts.advance(time.Second)
+	if v, err := first.Result(); v != "fast" || err != nil || ts.Pending() != 0 {
+		t.Fatalf("value %v, err %v, %v timers", v, err, ts.Pending())
+	}
+
+	if _, err := future.Any().Result(); err != future.ErrNoFutures {
+		t.Fatalf("err %v", err)
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	ts := newFakeTimers()
+
+	f := ts.after(time.Minute, "late")
+	timeout := f.WithTimeout(time.Second)
+	ts.advance(time.Second)
+	if _, err := timeout.Result(); err != future.ErrTimeout {
+		t.Fatalf("err %v", err)
+	}
+	if _, err := f.Result(); err != context.Canceled || ts.Pending() != 0 {
+		t.Fatalf("err %v, %v timers", err, ts.Pending())
+	}
+
+	timeout = ts.after(time.Second, "early").WithTimeout(time.Minute)
+	ts.advance(time.Second)
+	if v, err := timeout.Result(); v != "early" || err != nil || ts.Pending() != 0 {
+		t.Fatalf("value %v, err %v, %v timers", v, err, ts.Pending())
+	}
+}
+
+func TestSharedParent(t *testing.T) {
+	ts := newFakeTimers()
+
+	double := func(value interface{}) (interface{}, error) {
+		return value.(int) * 2, nil
+	}
+	parent := ts.after(time.Hour, 1)
+	a := parent.Then(double)
+	b := parent.Then(double)
+	timeout := parent.WithTimeout(time.Second)
+
+	// the other dependents still wait for the parent
+	a.Cancel()
+	ts.advance(time.Second)
+	if _, err := timeout.Result(); err != future.ErrTimeout || parent.Done() {
+		t.Fatalf("err %v, parent cancelled %v", err, parent.Done())
+	}
+
+	// the last dependent cancels it
+	b.Cancel()
+	if _, err := parent.Result(); err != context.Canceled || ts.Pending() != 0 {
+		t.Fatalf("err %v, %v timers", err, ts.Pending())
+	}
+
+	// a shared future failing All is not cancelled
+	shared := ts.after(time.Second, 2)
+	c := shared.Then(double)
+	all := future.All(shared, future.Resolved(ts, nil, errors.New("failed")))
+	ts.advance(time.Second)
+	if _, err := all.Result(); err == nil {
+		t.Fatal("All succeeded")
+	}
+	if v, err := c.Result(); v != 4 || err != nil {
+		t.Fatalf("value %v, err %v", v, err)
+	}
+}
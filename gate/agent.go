@@ -2,6 +2,7 @@ package gate
 
 import (
 	"net"
+	"net/http"
 )
 
 type Agent interface {
@@ -14,3 +15,11 @@ type Agent interface {
 	UserData() interface{}
 	SetUserData(data interface{})
 }
+
+// implemented by the agents of Gate, Request returns the WebSocket upgrade
+// request, with RemoteAddr resolved from the trusted proxies, and nil for
+// the other connections
+type WSAgent interface {
+	Agent
+	Request() *http.Request
+}
@@ -5,13 +5,25 @@ import (
 	"github.com/name5566/leaf/log"
 	"github.com/name5566/leaf/network"
 	"net"
+	"net/http"
 	"reflect"
+	"sort"
 	"time"
 )
 
+// e.g. *http.ServeMux
+type Mux interface {
+	Handle(pattern string, handler http.Handler)
+}
+
 type Gate struct {
+	// identifies the gate in the stats, "gate" by default
+	Name string
+
 	MaxConnNum      int
 	PendingWriteNum int
+	WriteOverflow   network.OverflowPolicy
+	WriteTimeout    time.Duration
 	MaxMsgLen       uint32
 	Processor       network.Processor
 	AgentChanRPC    *chanrpc.Server
@@ -21,29 +33,99 @@ type Gate struct {
 	HTTPTimeout time.Duration
 	CertFile    string
 	KeyFile     string
+	WSProcessor network.Processor
+
+	// the WebSocket handler is mounted on WSMux at WSPath ("/" by default)
+	// instead of listening on WSAddr, the caller serves WSMux
+	WSMux  Mux
+	WSPath string
+
+	// processors by subprotocol, negotiated with Sec-WebSocket-Protocol,
+	// WSProcessor is used if the client asks for none of them
+	WSProcessors map[string]network.Processor
+
+	// see network.WSServer
+	CheckOrigin    func(r *http.Request) bool
+	OnUpgrade      func(r *http.Request) error
+	TrustedProxies []string
+	ProxyProtocol  bool
+
+	WSFrameType            network.WSFrameType
+	WSCompression          bool
+	WSCompressionThreshold int
 
 	// tcp
-	TCPAddr      string
-	LenMsgLen    int
-	LittleEndian bool
+	TCPAddr       string
+	TCPProcessor  network.Processor
+	LenMsgLen     int
+	LittleEndian  bool
+	MaxWriteBatch int
+	ZeroCopyRead  bool
+	MsgFramer     network.MsgFramer
+
+	// kcp
+	KCPAddr        string
+	KCPInterval    time.Duration
+	KCPIdleTimeout time.Duration
+	KCPProcessor   network.Processor
+
+	OnAgentInit    func(Agent)
+	OnAgentDestroy func(Agent)
 
-	OnAgentInit 	   func(Agent)
-	OnAgentDestroy 	   func(Agent)
+	stats *gateStats
+}
+
+// the listener processors default to Processor
+func (gate *Gate) processor(p network.Processor) network.Processor {
+	if p != nil {
+		return p
+	}
+	return gate.Processor
 }
 
 func (gate *Gate) Run(closeSig chan bool) {
+	if gate.Name == "" {
+		gate.Name = "gate"
+	}
+	gate.stats = newGateStats(gate.Name)
+
 	var wsServer *network.WSServer
-	if gate.WSAddr != "" {
+	if gate.WSAddr != "" || gate.WSMux != nil {
 		wsServer = new(network.WSServer)
 		wsServer.Addr = gate.WSAddr
 		wsServer.MaxConnNum = gate.MaxConnNum
 		wsServer.PendingWriteNum = gate.PendingWriteNum
+		wsServer.WriteOverflow = gate.WriteOverflow
+		wsServer.WriteTimeout = gate.WriteTimeout
 		wsServer.MaxMsgLen = gate.MaxMsgLen
 		wsServer.HTTPTimeout = gate.HTTPTimeout
 		wsServer.CertFile = gate.CertFile
 		wsServer.KeyFile = gate.KeyFile
+		wsServer.CheckOrigin = gate.CheckOrigin
+		wsServer.OnUpgrade = gate.OnUpgrade
+		wsServer.TrustedProxies = gate.TrustedProxies
+		wsServer.ProxyProtocol = gate.ProxyProtocol
+		// WSFrameAuto is resolved for every connection below
+		if gate.WSFrameType == network.WSFrameText {
+			wsServer.FrameType = network.WSFrameText
+		}
+		wsServer.EnableCompression = gate.WSCompression
+		wsServer.CompressionThreshold = gate.WSCompressionThreshold
+		for subprotocol := range gate.WSProcessors {
+			wsServer.Subprotocols = append(wsServer.Subprotocols, subprotocol)
+		}
+		sort.Strings(wsServer.Subprotocols)
+		wsProcessor := gate.processor(gate.WSProcessor)
 		wsServer.NewAgent = func(conn *network.WSConn) network.Agent {
-			a := &agent{conn: conn, gate: gate}
+			processor := gate.WSProcessors[conn.Subprotocol()]
+			if processor == nil {
+				processor = wsProcessor
+			}
+			if gate.WSFrameType == network.WSFrameAuto && network.TextFrames(processor) {
+				conn.SetFrameType(network.WSFrameText)
+			}
+			a := &agent{conn: conn, gate: gate, processor: processor}
+			gate.stats.addConn(1)
 			if gate.AgentChanRPC != nil {
 				gate.AgentChanRPC.Go("NewAgent", a)
 			}
@@ -61,11 +143,29 @@ func (gate *Gate) Run(closeSig chan bool) {
 		tcpServer.Addr = gate.TCPAddr
 		tcpServer.MaxConnNum = gate.MaxConnNum
 		tcpServer.PendingWriteNum = gate.PendingWriteNum
+		tcpServer.WriteOverflow = gate.WriteOverflow
+		tcpServer.WriteTimeout = gate.WriteTimeout
 		tcpServer.LenMsgLen = gate.LenMsgLen
 		tcpServer.MaxMsgLen = gate.MaxMsgLen
 		tcpServer.LittleEndian = gate.LittleEndian
+		tcpServer.MaxWriteBatch = gate.MaxWriteBatch
+		tcpServer.MsgFramer = gate.MsgFramer
+		tcpProcessor := gate.processor(gate.TCPProcessor)
+		tcpServer.ZeroCopyRead = gate.ZeroCopyRead
+		if !network.ReleasesData(tcpProcessor) {
+			if gate.ZeroCopyRead || network.ZeroCopy(gate.MsgFramer) {
+				log.Infof("processor references message data, zero-copy read disabled")
+			}
+			tcpServer.ZeroCopyRead = false
+			if z, ok := gate.MsgFramer.(network.ZeroCopyFramer); ok {
+				z.SetZeroCopy(false)
+			}
+		}
 		tcpServer.NewAgent = func(conn *network.TCPConn) network.Agent {
-			a := &agent{conn: conn, gate: gate}
+			// the zero-copy mode of the framer is known once started
+			releaseData := !tcpServer.ZeroCopyRead && network.ReleasesData(tcpProcessor)
+			a := &agent{conn: conn, gate: gate, processor: tcpProcessor, releaseData: releaseData}
+			gate.stats.addConn(1)
 			if gate.AgentChanRPC != nil {
 				gate.AgentChanRPC.Go("NewAgent", a)
 			}
@@ -77,27 +177,72 @@ func (gate *Gate) Run(closeSig chan bool) {
 		}
 	}
 
+	var kcpServer *network.KCPServer
+	if gate.KCPAddr != "" {
+		kcpServer = new(network.KCPServer)
+		kcpServer.Addr = gate.KCPAddr
+		kcpServer.MaxConnNum = gate.MaxConnNum
+		kcpServer.PendingWriteNum = gate.PendingWriteNum
+		kcpServer.WriteOverflow = gate.WriteOverflow
+		kcpServer.WriteTimeout = gate.WriteTimeout
+		kcpServer.MaxMsgLen = gate.MaxMsgLen
+		kcpServer.Interval = gate.KCPInterval
+		kcpServer.IdleTimeout = gate.KCPIdleTimeout
+		kcpProcessor := gate.processor(gate.KCPProcessor)
+		kcpServer.NewAgent = func(conn *network.KCPConn) network.Agent {
+			a := &agent{conn: conn, gate: gate, processor: kcpProcessor}
+			gate.stats.addConn(1)
+			if gate.AgentChanRPC != nil {
+				gate.AgentChanRPC.Go("NewAgent", a)
+			}
+
+			if gate.OnAgentInit != nil {
+				gate.OnAgentInit(a)
+			}
+			return a
+		}
+	}
+
+	var wsHandler *network.WSHandler
 	if wsServer != nil {
-		wsServer.Start()
+		if gate.WSMux != nil {
+			if gate.WSPath == "" {
+				gate.WSPath = "/"
+			}
+			wsHandler = network.NewWSHandler(wsServer)
+			gate.WSMux.Handle(gate.WSPath, wsHandler)
+		} else {
+			wsServer.Start()
+		}
 	}
 	if tcpServer != nil {
 		tcpServer.Start()
 	}
+	if kcpServer != nil {
+		kcpServer.Start()
+	}
 	<-closeSig
-	if wsServer != nil {
+	if wsHandler != nil {
+		wsHandler.Close()
+	} else if wsServer != nil {
 		wsServer.Close()
 	}
 	if tcpServer != nil {
 		tcpServer.Close()
 	}
+	if kcpServer != nil {
+		kcpServer.Close()
+	}
 }
 
 func (gate *Gate) OnDestroy() {}
 
 type agent struct {
-	conn     network.Conn
-	gate     *Gate
-	userData interface{}
+	conn        network.Conn
+	gate        *Gate
+	processor   network.Processor
+	userData    interface{}
+	releaseData bool
 }
 
 func (a *agent) Run() {
@@ -108,13 +253,17 @@ func (a *agent) Run() {
 			break
 		}
 
-		if a.gate.Processor != nil {
-			msg, err := a.gate.Processor.Unmarshal(data)
+		if a.processor != nil {
+			msg, err := a.processor.Unmarshal(data)
+			if a.releaseData {
+				network.PutBuffer(data)
+			}
 			if err != nil {
 				log.Debugf("unmarshal message error: %v", err)
 				break
 			}
-			err = a.gate.Processor.Route(msg, a)
+			a.gate.stats.countIn(msg)
+			err = route(a.gate.stats.name, a.processor, msg, a)
 			if err != nil {
 				log.Debugf("route message error: %v", err)
 				break
@@ -125,6 +274,7 @@ func (a *agent) Run() {
 }
 
 func (a *agent) OnClose() {
+	a.gate.stats.addConn(-1)
 	if a.gate.AgentChanRPC != nil {
 		err := a.gate.AgentChanRPC.Call0("CloseAgent", a)
 		if err != nil {
@@ -137,12 +287,13 @@ func (a *agent) OnClose() {
 }
 
 func (a *agent) WriteMsg(msg interface{}) {
-	if a.gate.Processor != nil {
-		data, err := a.gate.Processor.Marshal(msg)
+	if a.processor != nil {
+		data, err := a.processor.Marshal(msg)
 		if err != nil {
 			log.Errorf("marshal message %v error: %v", reflect.TypeOf(msg), err)
 			return
 		}
+		a.gate.stats.countOut(msg)
 		err = a.conn.WriteMsg(data...)
 		if err != nil {
 			log.Errorf("write message %v error: %v", reflect.TypeOf(msg), err)
@@ -153,7 +304,7 @@ func (a *agent) WriteMsg(msg interface{}) {
 func (a *agent) WriteData(data []byte) {
 	err := a.conn.WriteMsg(data)
 	if err != nil {
-		log.Errorf("write data error: %v" , err)
+		log.Errorf("write data error: %v", err)
 	}
 }
 
@@ -165,6 +316,13 @@ func (a *agent) RemoteAddr() net.Addr {
 	return a.conn.RemoteAddr()
 }
 
+func (a *agent) Request() *http.Request {
+	if wsConn, ok := a.conn.(*network.WSConn); ok {
+		return wsConn.Request()
+	}
+	return nil
+}
+
 func (a *agent) Close() {
 	a.conn.Close()
 }
@@ -0,0 +1,303 @@
+package gate
+
+import (
+	"errors"
+	"github.com/gorilla/websocket"
+	"github.com/name5566/leaf/network"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// echoes the messages prefixed with its name
+type nameProcessor string
+
+func (p nameProcessor) Route(msg interface{}, userData interface{}) error {
+	userData.(Agent).WriteMsg(msg)
+	return nil
+}
+
+func (p nameProcessor) Unmarshal(data []byte) (interface{}, error) {
+	return string(data), nil
+}
+
+func (p nameProcessor) Marshal(msg interface{}) ([][]byte, error) {
+	return [][]byte{[]byte(string(p) + ":" + msg.(string))}, nil
+}
+
+func freeAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+func TestGateSubprotocols(t *testing.T) {
+	gate := &Gate{
+		WSAddr:      freeAddr(t),
+		WSProcessor: nameProcessor("default"),
+		WSProcessors: map[string]network.Processor{
+			"json":     nameProcessor("json"),
+			"protobuf": nameProcessor("protobuf"),
+		},
+	}
+	closeSig := make(chan bool)
+	done := make(chan struct{})
+	go func() {
+		gate.Run(closeSig)
+		close(done)
+	}()
+	defer func() {
+		closeSig <- true
+		<-done
+	}()
+
+	cases := []struct {
+		subprotocols []string
+		want         string
+	}{
+		{[]string{"protobuf"}, "protobuf:hi"},
+		{[]string{"xml", "json"}, "json:hi"},
+		{nil, "default:hi"},
+	}
+	for _, c := range cases {
+		dialer := websocket.Dialer{Subprotocols: c.subprotocols}
+		var conn *websocket.Conn
+		var err error
+		for i := 0; i < 50; i++ {
+			conn, _, err = dialer.Dial("ws://"+gate.WSAddr, nil)
+			if err == nil {
+				break
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		conn.WriteMessage(websocket.BinaryMessage, []byte("hi"))
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, data, err := conn.ReadMessage()
+		conn.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != c.want {
+			t.Fatalf("%v: got %q, want %q", c.subprotocols, data, c.want)
+		}
+	}
+}
+
+// echoes the token of the upgrade request
+type tokenProcessor struct{}
+
+func (p tokenProcessor) Route(msg interface{}, userData interface{}) error {
+	a := userData.(WSAgent)
+	a.WriteMsg(a.Request().URL.Query().Get("token") + "@" + a.RemoteAddr().String())
+	return nil
+}
+
+func (p tokenProcessor) Unmarshal(data []byte) (interface{}, error) {
+	return string(data), nil
+}
+
+func (p tokenProcessor) Marshal(msg interface{}) ([][]byte, error) {
+	return [][]byte{[]byte(msg.(string))}, nil
+}
+
+func TestGateOnUpgrade(t *testing.T) {
+	gate := &Gate{
+		WSAddr:         freeAddr(t),
+		Processor:      tokenProcessor{},
+		TrustedProxies: []string{"127.0.0.1"},
+		OnUpgrade: func(r *http.Request) error {
+			if r.URL.Query().Get("token") == "" {
+				return errors.New("no token")
+			}
+			return nil
+		},
+	}
+	closeSig := make(chan bool)
+	done := make(chan struct{})
+	go func() {
+		gate.Run(closeSig)
+		close(done)
+	}()
+	defer func() {
+		closeSig <- true
+		<-done
+	}()
+
+	var resp *http.Response
+	var err error
+	for i := 0; i < 50; i++ {
+		_, resp, err = websocket.DefaultDialer.Dial("ws://"+gate.WSAddr, nil)
+		if resp != nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err == nil || resp == nil || resp.StatusCode != 403 {
+		t.Fatalf("upgrade without token not rejected: %v", err)
+	}
+
+	header := http.Header{"X-Forwarded-For": {"1.2.3.4"}}
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+gate.WSAddr+"/?token=abc", header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.WriteMessage(websocket.BinaryMessage, []byte("hi"))
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "abc@1.2.3.4:0" {
+		t.Fatalf("got %q", data)
+	}
+}
+
+func TestGateMux(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	httpServer := httptest.NewServer(mux)
+	defer httpServer.Close()
+
+	gate := &Gate{
+		WSMux:     mux,
+		WSPath:    "/ws",
+		Processor: nameProcessor("mux"),
+	}
+	closeSig := make(chan bool)
+	done := make(chan struct{})
+	go func() {
+		gate.Run(closeSig)
+		close(done)
+	}()
+
+	url := "ws" + strings.TrimPrefix(httpServer.URL, "http") + "/ws"
+	var conn *websocket.Conn
+	var err error
+	for i := 0; i < 50; i++ {
+		conn, _, err = websocket.DefaultDialer.Dial(url, nil)
+		if err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.WriteMessage(websocket.BinaryMessage, []byte("hi"))
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil || string(data) != "mux:hi" {
+		t.Fatalf("got %q, %v", data, err)
+	}
+
+	resp, err := http.Get(httpServer.URL + "/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	closeSig <- true
+	<-done
+
+	// closed by the gate
+	if _, _, err := conn.ReadMessage(); err == nil {
+		t.Fatal("connection not closed")
+	}
+	_, resp, err = websocket.DefaultDialer.Dial(url, nil)
+	if err == nil || resp == nil || resp.StatusCode != 503 {
+		t.Fatalf("upgrade after close not rejected: %v", err)
+	}
+}
+
+// nameProcessor whose messages hold no reference to the data read
+type releasingProcessor struct {
+	nameProcessor
+}
+
+func (p releasingProcessor) ReleasesData() bool {
+	return true
+}
+
+func TestGateMsgFramerZeroCopy(t *testing.T) {
+	cases := []struct {
+		processor      network.Processor
+		gateZeroCopy   bool
+		framerZeroCopy bool
+		want           bool
+	}{
+		{releasingProcessor{"p"}, false, true, true},
+		{releasingProcessor{"p"}, true, false, true},
+		{releasingProcessor{"p"}, false, false, false},
+		{nameProcessor("p"), true, true, false},
+	}
+	for _, c := range cases {
+		msgFramer := network.NewDelimiterParser('\n')
+		msgFramer.SetZeroCopy(c.framerZeroCopy)
+		agents := make(chan *agent, 1)
+		gate := &Gate{
+			MaxConnNum:   10,
+			TCPAddr:      freeAddr(t),
+			Processor:    c.processor,
+			ZeroCopyRead: c.gateZeroCopy,
+			MsgFramer:    msgFramer,
+			OnAgentInit: func(a Agent) {
+				agents <- a.(*agent)
+			},
+		}
+		closeSig := make(chan bool)
+		done := make(chan struct{})
+		go func() {
+			gate.Run(closeSig)
+			close(done)
+		}()
+
+		var conn net.Conn
+		var err error
+		for i := 0; i < 50; i++ {
+			conn, err = net.Dial("tcp", gate.TCPAddr)
+			if err == nil {
+				break
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the data read is released by the agent only if not borrowed from
+		// the framer
+		a := <-agents
+		if msgFramer.ZeroCopy() != c.want || a.releaseData != (!c.want && network.ReleasesData(c.processor)) {
+			t.Fatalf("%+v: zero-copy %v, agent releases data %v", c, msgFramer.ZeroCopy(), a.releaseData)
+		}
+
+		conn.SetDeadline(time.Now().Add(time.Second))
+		conn.Write([]byte("hi\nho\n"))
+		data := make([]byte, len("p:hi\np:ho\n"))
+		_, err = io.ReadFull(conn, data)
+		conn.Close()
+		if err != nil || string(data) != "p:hi\np:ho\n" {
+			t.Fatalf("%+v: got %q, %v", c, data, err)
+		}
+
+		closeSig <- true
+		<-done
+	}
+}
@@ -0,0 +1,47 @@
+package gate
+
+import (
+	"github.com/name5566/leaf/metrics"
+	"reflect"
+)
+
+var (
+	gateConns = metrics.NewGauge("leaf_gate_connections", "Open connections.", "gate")
+	gateMsgs  = metrics.NewCounter("leaf_gate_messages_total", "Messages by direction and message ID.", "gate", "direction", "id")
+)
+
+type gateStats struct {
+	name  string
+	conns *metrics.GaugeValue
+}
+
+func newGateStats(name string) *gateStats {
+	s := new(gateStats)
+	s.name = name
+	s.conns = gateConns.With(name)
+	return s
+}
+
+func (s *gateStats) addConn(delta float64) {
+	s.conns.Add(delta)
+}
+
+// messages are identified by their type name
+func msgID(msg interface{}) string {
+	t := reflect.TypeOf(msg)
+	if t == nil {
+		return "nil"
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Name()
+}
+
+func (s *gateStats) countIn(msg interface{}) {
+	gateMsgs.With(s.name, "in", msgID(msg)).Inc()
+}
+
+func (s *gateStats) countOut(msg interface{}) {
+	gateMsgs.With(s.name, "out", msgID(msg)).Inc()
+}
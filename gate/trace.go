@@ -0,0 +1,29 @@
+package gate
+
+import (
+	"github.com/name5566/leaf/network"
+	"github.com/name5566/leaf/trace"
+)
+
+// every inbound message starts a trace, the calls of the routers of a
+// network.SpanRouter are its children
+func route(gateName string, processor network.Processor, msg interface{}, a Agent) error {
+	span := trace.Start("gate "+msgID(msg), trace.String("gate", gateName))
+	if span == nil {
+		return processor.Route(msg, a)
+	}
+	span.SetKind(trace.SpanKindServer)
+	if addr := a.RemoteAddr(); addr != nil {
+		span.SetAttributes(trace.String("net.peer.addr", addr.String()))
+	}
+
+	var err error
+	if r, ok := processor.(network.SpanRouter); ok {
+		err = r.RouteSpan(span.Context(), msg, a)
+	} else {
+		err = processor.Route(msg, a)
+	}
+	span.SetError(err)
+	span.End()
+	return err
+}
@@ -0,0 +1,80 @@
+package gate
+
+import (
+	"github.com/name5566/leaf/trace"
+	"net"
+	"sync"
+	"testing"
+)
+
+type spanAgent struct {
+	Agent
+}
+
+func (a spanAgent) RemoteAddr() net.Addr {
+	return nil
+}
+
+// records the span of every message
+type spanProcessor struct {
+	nameProcessor
+	mutex sync.Mutex
+	spans map[interface{}]trace.SpanContext
+}
+
+func (p *spanProcessor) RouteSpan(sc trace.SpanContext, msg interface{}, userData interface{}) error {
+	p.mutex.Lock()
+	p.spans[msg] = sc
+	p.mutex.Unlock()
+	return nil
+}
+
+type spanExporter struct {
+	mutex sync.Mutex
+	spans []*trace.SpanData
+}
+
+func (e *spanExporter) ExportSpans(spans []*trace.SpanData) error {
+	e.mutex.Lock()
+	e.spans = append(e.spans, spans...)
+	e.mutex.Unlock()
+	return nil
+}
+
+func (e *spanExporter) Shutdown() error {
+	return nil
+}
+
+// the messages of an agent routed at once get their own span
+func TestRouteSpan(t *testing.T) {
+	exporter := new(spanExporter)
+	trace.SetExporter(exporter)
+
+	p := &spanProcessor{spans: make(map[interface{}]trace.SpanContext)}
+	a := spanAgent{}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			route("test", p, i, a)
+		}(i)
+	}
+	wg.Wait()
+	trace.Shutdown()
+
+	exported := make(map[trace.SpanID]bool)
+	for _, data := range exporter.spans {
+		exported[data.SpanID] = true
+	}
+	routed := make(map[trace.SpanID]bool)
+	for msg, sc := range p.spans {
+		if !exported[sc.SpanID] || routed[sc.SpanID] {
+			t.Fatalf("message %v routed in span %v", msg, sc)
+		}
+		routed[sc.SpanID] = true
+	}
+	if len(routed) != 10 || len(exported) != 10 {
+		t.Fatalf("%v spans routed, %v exported", len(routed), len(exported))
+	}
+}
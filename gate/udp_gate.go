@@ -1,32 +1,79 @@
 package gate
 
 import (
+	"github.com/name5566/leaf/chanrpc"
 	"github.com/name5566/leaf/log"
 	"github.com/name5566/leaf/network"
 	"net"
 	"reflect"
+	"sync"
+	"time"
 )
 
+// demultiplexes the datagrams into sessions implementing Agent
 type UDPGate struct {
-	Processor     network.Processor
-	UDPAddr      string
+	// identifies the gate in the stats, "udp" by default
+	Name string
+
+	MaxConnNum      int
 	PendingWriteNum int
-}
+	WriteOverflow   network.OverflowPolicy
+	WriteTimeout    time.Duration
+	MaxMsgLen       uint32
+	Processor       network.Processor
+	AgentChanRPC    *chanrpc.Server
+	UDPAddr         string
+
+	// a session is closed if nothing is received for IdleTimeout
+	IdleTimeout time.Duration
 
-type UDPRouteData struct{
-	A    *UDPAgent
-	Addr *net.UDPAddr
+	// sessions are keyed by the remote address if ConnIDLen is 0, otherwise
+	// by the first ConnIDLen bytes of every datagram, which are stripped
+	// from the message and let a client keep its session when its address
+	// changes (NAT rebinding), the messages sent are not prefixed
+	//
+	// The connection ID is not authenticated: any peer sending the ID of a
+	// session takes the session over and receives its messages. The IDs
+	// must be unguessable, e.g. random 16 bytes handed out over a secure
+	// channel, and the messages authenticated by the processor if the
+	// peers are not trusted.
+	ConnIDLen int
+
+	OnAgentInit    func(Agent)
+	OnAgentDestroy func(Agent)
+
+	stats *gateStats
 }
 
 func (gate *UDPGate) Run(closeSig chan bool) {
+	if gate.Name == "" {
+		gate.Name = "udp"
+	}
+	gate.stats = newGateStats(gate.Name)
+
+	if gate.MaxConnNum <= 0 {
+		gate.MaxConnNum = 100
+		log.Infof("invalid MaxConnNum, reset to %v", gate.MaxConnNum)
+	}
+	if gate.IdleTimeout <= 0 {
+		gate.IdleTimeout = 30 * time.Second
+		log.Infof("invalid IdleTimeout, reset to %v", gate.IdleTimeout)
+	}
+	if gate.ConnIDLen < 0 {
+		gate.ConnIDLen = 0
+		log.Infof("invalid ConnIDLen, reset to %v", gate.ConnIDLen)
+	}
+
 	var udpServer *network.UDPServer
 	if gate.UDPAddr != "" {
 		udpServer = new(network.UDPServer)
 		udpServer.Addr = gate.UDPAddr
 		udpServer.PendingWriteNum = gate.PendingWriteNum
+		udpServer.WriteOverflow = gate.WriteOverflow
+		udpServer.WriteTimeout = gate.WriteTimeout
+		udpServer.MaxMsgLen = gate.MaxMsgLen
 		udpServer.NewAgent = func(conn *network.UDPConn) network.Agent {
-			a := &UDPAgent{conn: conn, gate: gate}
-			return a
+			return newUDPAgent(conn, gate)
 		}
 	}
 
@@ -42,27 +89,70 @@ func (gate *UDPGate) Run(closeSig chan bool) {
 
 func (gate *UDPGate) OnDestroy() {}
 
+// the agent of the socket, reads it and routes the messages of every session
+// with the session as user data, see Agent
 type UDPAgent struct {
 	conn     *network.UDPConn
 	gate     *UDPGate
+	mutex    sync.Mutex
+	sessions map[string]*udpSession
+	closed   []*udpSession
+	reapSig  chan struct{}
+	stopSig  chan struct{}
+	wg       sync.WaitGroup
 	userData interface{}
 }
 
-func (a *UDPAgent) Run() {
+func newUDPAgent(conn *network.UDPConn, gate *UDPGate) *UDPAgent {
+	d := new(UDPAgent)
+	d.conn = conn
+	d.gate = gate
+	d.sessions = make(map[string]*udpSession)
+	d.reapSig = make(chan struct{}, 1)
+	d.stopSig = make(chan struct{})
+
+	d.wg.Add(1)
+	go d.reap()
+
+	return d
+}
+
+func (d *UDPAgent) Run() {
 	for {
-		data, addr, err := a.conn.ReadMsg()
+		data, addr, err := d.conn.ReadMsg()
 		if err != nil {
 			log.Debugf("read message: %v", err)
+			// the socket is only closed by the server
+			if d.conn.Closed() {
+				break
+			}
+			continue
+		}
+
+		var key string
+		if d.gate.ConnIDLen > 0 {
+			if len(data) < d.gate.ConnIDLen {
+				continue
+			}
+			key = string(data[:d.gate.ConnIDLen])
+			data = data[d.gate.ConnIDLen:]
+		} else {
+			key = addr.String()
+		}
+
+		s := d.session(key, addr)
+		if s == nil {
 			continue
 		}
-		if a.gate.Processor != nil {
-			msg, err := a.gate.Processor.Unmarshal(data)
+
+		if d.gate.Processor != nil {
+			msg, err := d.gate.Processor.Unmarshal(data)
 			if err != nil {
 				log.Debugf("unmarshal message error: %v", err)
 				continue
 			}
-			
-			err = a.gate.Processor.Route(msg, &UDPRouteData{a, addr})
+			d.gate.stats.countIn(msg)
+			err = route(d.gate.stats.name, d.gate.Processor, msg, s)
 			if err != nil {
 				log.Debugf("route message error: %v", err)
 				continue
@@ -70,27 +160,206 @@ func (a *UDPAgent) Run() {
 		}
 	}
 }
-func (a *UDPAgent) OnClose() {
-	
+
+// returns nil if the datagram must be dropped
+func (d *UDPAgent) session(key string, addr *net.UDPAddr) *udpSession {
+	d.mutex.Lock()
+	s := d.sessions[key]
+	if s != nil {
+		if s.addr.String() != addr.String() {
+			log.Debugf("session rebound from %v to %v", s.addr, addr)
+		}
+		s.addr = addr
+		s.lastRecv = time.Now()
+		d.mutex.Unlock()
+		return s
+	}
+	if len(d.sessions) >= d.gate.MaxConnNum {
+		d.mutex.Unlock()
+		log.Debug("too many connections")
+		return nil
+	}
+	s = &udpSession{d: d, key: key, addr: addr, lastRecv: time.Now()}
+	d.sessions[key] = s
+	d.mutex.Unlock()
+
+	d.gate.stats.addConn(1)
+	if d.gate.AgentChanRPC != nil {
+		d.gate.AgentChanRPC.Go("NewAgent", s)
+	}
+	if d.gate.OnAgentInit != nil {
+		d.gate.OnAgentInit(s)
+	}
+	return s
+}
+
+func (d *UDPAgent) OnClose() {
+	close(d.stopSig)
+	d.wg.Wait()
 }
-func (a *UDPAgent) WriteMsg(msg interface{}, addr * net.UDPAddr) {
-	if a.gate.Processor != nil {
-		data, err := a.gate.Processor.Marshal(msg)
+
+// the session is destroyed by the reaper, so that Close never blocks on
+// AgentChanRPC
+func (d *UDPAgent) closeSession(s *udpSession) {
+	d.mutex.Lock()
+	if s.closeFlag {
+		d.mutex.Unlock()
+		return
+	}
+	s.closeFlag = true
+	if d.sessions[s.key] == s {
+		delete(d.sessions, s.key)
+	}
+	d.closed = append(d.closed, s)
+	d.mutex.Unlock()
+
+	select {
+	case d.reapSig <- struct{}{}:
+	default:
+	}
+}
+
+// expires the idle sessions and destroys the closed ones
+func (d *UDPAgent) reap() {
+	defer d.wg.Done()
+
+	ticker := time.NewTicker(d.gate.IdleTimeout / 4)
+	defer ticker.Stop()
+
+	for {
+		stop := false
+		select {
+		case <-ticker.C:
+		case <-d.reapSig:
+		case <-d.stopSig:
+			stop = true
+		}
+
+		d.mutex.Lock()
+		for key, s := range d.sessions {
+			if stop || time.Since(s.lastRecv) > d.gate.IdleTimeout {
+				s.closeFlag = true
+				delete(d.sessions, key)
+				d.closed = append(d.closed, s)
+			}
+		}
+		closed := d.closed
+		d.closed = nil
+		d.mutex.Unlock()
+
+		for _, s := range closed {
+			s.onClose()
+		}
+		if stop {
+			return
+		}
+	}
+}
+
+// the messages sent to addr are not bound to a session
+func (d *UDPAgent) WriteMsg(msg interface{}, addr *net.UDPAddr) {
+	if d.gate.Processor != nil {
+		data, err := d.gate.Processor.Marshal(msg)
+		if err != nil {
+			log.Errorf("marshal message %v error: %v", reflect.TypeOf(msg), err)
+			return
+		}
+		d.gate.stats.countOut(msg)
+		err = d.conn.WriteMsg(addr, data...)
+		if err != nil {
+			log.Errorf("write message %v error: %v", reflect.TypeOf(msg), err)
+		}
+	}
+}
+
+func (d *UDPAgent) UserData() interface{} {
+	return d.userData
+}
+
+func (d *UDPAgent) SetUserData(data interface{}) {
+	d.userData = data
+}
+
+type udpSession struct {
+	d         *UDPAgent
+	key       string
+	addr      *net.UDPAddr
+	lastRecv  time.Time
+	closeFlag bool
+	userData  interface{}
+}
+
+func (s *udpSession) onClose() {
+	s.d.gate.stats.addConn(-1)
+	if s.d.gate.AgentChanRPC != nil {
+		err := s.d.gate.AgentChanRPC.Call0("CloseAgent", s)
+		if err != nil {
+			log.Errorf("chanrpc error: %v", err)
+		}
+	}
+	if s.d.gate.OnAgentDestroy != nil {
+		s.d.gate.OnAgentDestroy(s)
+	}
+}
+
+func (s *udpSession) remoteAddr() (*net.UDPAddr, bool) {
+	s.d.mutex.Lock()
+	defer s.d.mutex.Unlock()
+	return s.addr, s.closeFlag
+}
+
+func (s *udpSession) WriteMsg(msg interface{}) {
+	if s.d.gate.Processor != nil {
+		data, err := s.d.gate.Processor.Marshal(msg)
 		if err != nil {
 			log.Errorf("marshal message %v error: %v", reflect.TypeOf(msg), err)
 			return
 		}
-		err = a.conn.WriteMsg(addr,data...)
+		s.d.gate.stats.countOut(msg)
+		addr, closed := s.remoteAddr()
+		if closed {
+			return
+		}
+		err = s.d.conn.WriteMsg(addr, data...)
 		if err != nil {
 			log.Errorf("write message %v error: %v", reflect.TypeOf(msg), err)
 		}
 	}
 }
 
-func (a *UDPAgent) UserData() interface{} {
-	return a.userData
+func (s *udpSession) WriteData(data []byte) {
+	addr, closed := s.remoteAddr()
+	if closed {
+		return
+	}
+	err := s.d.conn.WriteMsg(addr, data)
+	if err != nil {
+		log.Errorf("write data error: %v", err)
+	}
 }
 
-func (a *UDPAgent) SetUserData(data interface{}) {
-	a.userData = data
-}
\ No newline at end of file
+func (s *udpSession) LocalAddr() net.Addr {
+	return s.d.conn.LocalAddr()
+}
+
+func (s *udpSession) RemoteAddr() net.Addr {
+	addr, _ := s.remoteAddr()
+	return addr
+}
+
+// the messages are written directly to the socket, nothing to flush
+func (s *udpSession) Close() {
+	s.d.closeSession(s)
+}
+
+func (s *udpSession) Destroy() {
+	s.d.closeSession(s)
+}
+
+func (s *udpSession) UserData() interface{} {
+	return s.userData
+}
+
+func (s *udpSession) SetUserData(data interface{}) {
+	s.userData = data
+}
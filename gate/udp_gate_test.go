@@ -0,0 +1,133 @@
+package gate
+
+import (
+	"github.com/name5566/leaf/network"
+	"net"
+	"testing"
+	"time"
+)
+
+func startUDPGate(t *testing.T, gate *UDPGate) (*net.UDPAddr, func()) {
+	gate.stats = newGateStats("udp")
+	addr := make(chan net.Addr, 1)
+	server := new(network.UDPServer)
+	server.Addr = "127.0.0.1:0"
+	server.NewAgent = func(conn *network.UDPConn) network.Agent {
+		addr <- conn.LocalAddr()
+		return newUDPAgent(conn, gate)
+	}
+	server.Start()
+
+	return (<-addr).(*net.UDPAddr), server.Close
+}
+
+func dialUDP(t *testing.T, addr *net.UDPAddr) *net.UDPConn {
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func TestUDPGateSessions(t *testing.T) {
+	inits := make(chan Agent, 10)
+	destroys := make(chan Agent, 10)
+	gate := &UDPGate{
+		MaxConnNum:     1,
+		IdleTimeout:    200 * time.Millisecond,
+		OnAgentInit:    func(a Agent) { inits <- a },
+		OnAgentDestroy: func(a Agent) { destroys <- a },
+	}
+	addr, closeGate := startUDPGate(t, gate)
+	defer closeGate()
+
+	c1 := dialUDP(t, addr)
+	defer c1.Close()
+	c2 := dialUDP(t, addr)
+	defer c2.Close()
+
+	c1.Write([]byte("a"))
+	var a Agent
+	select {
+	case a = <-inits:
+	case <-time.After(time.Second):
+		t.Fatal("no session")
+	}
+	if a.RemoteAddr().String() != c1.LocalAddr().String() {
+		t.Fatalf("remote addr %v, want %v", a.RemoteAddr(), c1.LocalAddr())
+	}
+
+	// over MaxConnNum
+	c2.Write([]byte("b"))
+	select {
+	case <-inits:
+		t.Fatal("too many sessions")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	a.WriteData([]byte("pong"))
+	buf := make([]byte, 16)
+	c1.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := c1.Read(buf)
+	if err != nil || string(buf[:n]) != "pong" {
+		t.Fatalf("read %q, %v", buf[:n], err)
+	}
+
+	select {
+	case d := <-destroys:
+		if d != a {
+			t.Fatal("wrong session destroyed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("session not expired")
+	}
+}
+
+func TestUDPGateConnID(t *testing.T) {
+	inits := make(chan Agent, 10)
+	destroys := make(chan Agent, 10)
+	gate := &UDPGate{
+		MaxConnNum:     10,
+		IdleTimeout:    time.Minute,
+		ConnIDLen:      4,
+		OnAgentInit:    func(a Agent) { inits <- a },
+		OnAgentDestroy: func(a Agent) { destroys <- a },
+	}
+	addr, closeGate := startUDPGate(t, gate)
+
+	c1 := dialUDP(t, addr)
+	defer c1.Close()
+	c1.Write([]byte("id01a"))
+	a := <-inits
+
+	// same id from another address
+	c2 := dialUDP(t, addr)
+	defer c2.Close()
+	c2.Write([]byte("id01b"))
+	deadline := time.Now().Add(time.Second)
+	for a.RemoteAddr().String() != c2.LocalAddr().String() {
+		if time.Now().After(deadline) {
+			t.Fatal("session not rebound")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case <-inits:
+		t.Fatal("new session on rebinding")
+	default:
+	}
+
+	a.Close()
+	if d := <-destroys; d != a {
+		t.Fatal("wrong session destroyed")
+	}
+
+	c1.Write([]byte("id02a"))
+	<-inits
+	closeGate()
+	select {
+	case <-destroys:
+	default:
+		t.Fatal("session not destroyed on close")
+	}
+}
@@ -2,16 +2,43 @@ package g
 
 import (
 	"container/list"
+	"context"
+	"errors"
 	"github.com/name5566/leaf/conf"
 	"github.com/name5566/leaf/log"
 	"runtime"
 	"sync"
+	"sync/atomic"
 )
 
 // one Go per goroutine (goroutine not safe)
 type Go struct {
 	ChanCb    chan func()
-	pendingGo int
+	pendingGo int32
+	// nil if a goroutine per call
+	pool *pool
+	// the parent of the contexts of GoContext, cancelled by Close
+	ctx    context.Context
+	cancel context.CancelFunc
+	// loggerHolder, read by the goroutines of f
+	logger atomic.Value
+}
+
+type loggerHolder struct {
+	log.Logger
+}
+
+var (
+	// the error of a GoContext whose f panics
+	ErrPanic = errors.New("go: f panicked")
+	// the error of a GoContext whose f is discarded by a full pool, see
+	// RejectDiscard
+	ErrRejected = errors.New("go: pool full, f discarded")
+)
+
+// a call of GoContext
+type Task struct {
+	cancel context.CancelFunc
 }
 
 type LinearGo struct {
@@ -26,14 +53,47 @@ type LinearContext struct {
 	mutexExecution sync.Mutex
 }
 
+// a goroutine per call of f
 func New(l int) *Go {
 	g := new(Go)
 	g.ChanCb = make(chan func(), l)
+	g.ctx, g.cancel = context.WithCancel(context.Background())
 	return g
 }
 
+// the panics of f and cb are logged by logger, by the package functions of
+// log if nil
+// goroutine safe
+func (g *Go) SetLogger(logger log.Logger) {
+	g.logger.Store(loggerHolder{logger})
+}
+
+func (g *Go) errorf(format string, v ...interface{}) {
+	if h, ok := g.logger.Load().(loggerHolder); ok && h.Logger != nil {
+		h.Logger.Errorf(format, v...)
+		return
+	}
+	log.Errorf(format, v...)
+}
+
 func (g *Go) Go(f func(), cb func()) {
-	g.pendingGo++
+	g.run(f, cb)
+}
+
+// false if rejected by the pool
+func (g *Go) run(f func(), cb func()) bool {
+	atomic.AddInt32(&g.pendingGo, 1)
+
+	if g.pool != nil {
+		if !g.pool.submit(func() {
+			g.safeCall(f)
+			g.deliver(cb)
+		}) {
+			g.reject()
+			return false
+		}
+		return true
+	}
 
 	go func() {
 		defer func() {
@@ -42,27 +102,66 @@ func (g *Go) Go(f func(), cb func()) {
 				if conf.LenStackBuf > 0 {
 					buf := make([]byte, conf.LenStackBuf)
 					l := runtime.Stack(buf, false)
-					log.Errorf("%v: %s", r, buf[:l])
+					g.errorf("%v: %s", r, buf[:l])
 				} else {
-					log.Errorf("%v", r)
+					g.errorf("%v", r)
 				}
 			}
 		}()
 
 		f()
 	}()
+	return true
+}
+
+// f is called with a context cancelled by Task.Cancel or Close, cb is
+// called with the results of f, ErrPanic if f panics, ErrRejected, with the
+// context cancelled, if the pool discards f
+func (g *Go) GoContext(f func(ctx context.Context) (interface{}, error), cb func(ret interface{}, err error)) *Task {
+	ctx, cancel := context.WithCancel(g.ctx)
+	t := &Task{cancel: cancel}
+
+	var (
+		ret interface{}
+		err error
+	)
+	if !g.run(func() {
+		defer cancel()
+		err = ErrPanic
+		ret, err = f(ctx)
+	}, func() {
+		if cb != nil {
+			cb(ret, err)
+		}
+	}) {
+		cancel()
+		atomic.AddInt32(&g.pendingGo, 1)
+		g.deliver(func() {
+			if cb != nil {
+				cb(nil, ErrRejected)
+			}
+		})
+	}
+	return t
+}
+
+// the context of the task is cancelled, f is expected to return soon, cb is
+// called anyway
+// goroutine safe
+func (t *Task) Cancel() {
+	t.cancel()
 }
 
 func (g *Go) Cb(cb func()) {
 	defer func() {
-		g.pendingGo--
+		atomic.AddInt32(&g.pendingGo, -1)
 		if r := recover(); r != nil {
 			if conf.LenStackBuf > 0 {
 				buf := make([]byte, conf.LenStackBuf)
 				l := runtime.Stack(buf, false)
-				log.Errorf("%v: %s", r, buf[:l])
+				g.errorf("%v: %s", r, buf[:l])
 			} else {
-				log.Errorf("%v", r)
+				g.errorf("%v", r)
 			}
 		}
 	}()
@@ -72,14 +171,21 @@ func (g *Go) Cb(cb func()) {
 	}
 }
 
+// the contexts of GoContext are cancelled
 func (g *Go) Close() {
-	for g.pendingGo > 0 {
+	g.cancel()
+	for atomic.LoadInt32(&g.pendingGo) > 0 {
 		g.Cb(<-g.ChanCb)
 	}
 }
 
 func (g *Go) Idle() bool {
-	return g.pendingGo == 0
+	return atomic.LoadInt32(&g.pendingGo) == 0
+}
+
+// goroutine safe
+func (g *Go) Pending() int {
+	return int(atomic.LoadInt32(&g.pendingGo))
 }
 
 func (g *Go) NewLinearContext() *LinearContext {
@@ -90,7 +196,7 @@ func (g *Go) NewLinearContext() *LinearContext {
 }
 
 func (c *LinearContext) Go(f func(), cb func()) {
-	c.g.pendingGo++
+	atomic.AddInt32(&c.g.pendingGo, 1)
 
 	c.mutexLinearGo.Lock()
 	c.linearGo.PushBack(&LinearGo{f: f, cb: cb})
@@ -110,9 +216,9 @@ func (c *LinearContext) Go(f func(), cb func()) {
 				if conf.LenStackBuf > 0 {
 					buf := make([]byte, conf.LenStackBuf)
 					l := runtime.Stack(buf, false)
-					log.Errorf("%v: %s", r, buf[:l])
+					c.g.errorf("%v: %s", r, buf[:l])
 				} else {
-					log.Errorf("%v", r)
+					c.g.errorf("%v", r)
 				}
 			}
 		}()
@@ -0,0 +1,65 @@
+package g_test
+
+import (
+	"context"
+	"errors"
+	"github.com/name5566/leaf/go"
+	"testing"
+)
+
+func TestGoContext(t *testing.T) {
+	d := g.New(10)
+
+	// results
+	var ret interface{}
+	var err error
+	d.GoContext(func(ctx context.Context) (interface{}, error) {
+		return 2, errors.New("odd")
+	}, func(r interface{}, e error) {
+		ret, err = r, e
+	})
+	d.Cb(<-d.ChanCb)
+	if ret != 2 || err == nil || err.Error() != "odd" {
+		t.Fatalf("ret %v, err %v", ret, err)
+	}
+
+	// panic
+	d.GoContext(func(ctx context.Context) (interface{}, error) {
+		panic("f")
+	}, func(r interface{}, e error) {
+		err = e
+	})
+	d.Cb(<-d.ChanCb)
+	if err != g.ErrPanic {
+		t.Fatalf("err %v", err)
+	}
+
+	// cancelled by the task
+	started := make(chan struct{})
+	task := d.GoContext(func(ctx context.Context) (interface{}, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}, func(r interface{}, e error) {
+		err = e
+	})
+	<-started
+	task.Cancel()
+	d.Cb(<-d.ChanCb)
+	if err != context.Canceled {
+		t.Fatalf("err %v", err)
+	}
+
+	// cancelled by Close
+	err = nil
+	d.GoContext(func(ctx context.Context) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}, func(r interface{}, e error) {
+		err = e
+	})
+	d.Close()
+	if err != context.Canceled || !d.Idle() {
+		t.Fatalf("err %v", err)
+	}
+}
@@ -0,0 +1,230 @@
+package g
+
+import (
+	"container/list"
+	"github.com/name5566/leaf/conf"
+	"github.com/name5566/leaf/metrics"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+var goRejected = metrics.NewCounter("leaf_go_rejected_total", "Go calls dropped by a full worker pool.").With()
+
+// what Go does when the workers of the pool are busy and its queue is full
+type RejectPolicy int
+
+const (
+	// Go blocks until the queue has room
+	RejectBlock RejectPolicy = iota
+	// f is called by Go, cb is called as usual
+	RejectCallerRuns
+	// f and cb are never called, but the callback of GoContext is called
+	// with ErrRejected
+	RejectDiscard
+)
+
+// at most maxWorkers calls of f run at once, the calls beyond wait in a queue
+// of queueLen, see RejectPolicy for a full queue, the calls of a
+// LinearContext are not bounded
+func NewPool(l int, maxWorkers int, queueLen int, policy RejectPolicy) *Go {
+	if maxWorkers <= 0 {
+		panic("invalid maxWorkers")
+	}
+
+	g := New(l)
+	g.pool = new(pool)
+	g.pool.maxWorkers = maxWorkers
+	g.pool.queueLen = queueLen
+	g.pool.policy = policy
+	g.pool.queue = list.New()
+	g.pool.cond = sync.NewCond(&g.pool.mutex)
+	g.pool.cbs = list.New()
+	return g
+}
+
+type pool struct {
+	mutex      sync.Mutex
+	cond       *sync.Cond
+	maxWorkers int
+	workers    int
+	queueLen   int
+	queue      *list.List
+	policy     RejectPolicy
+
+	// the callbacks are sent to ChanCb by a goroutine of their own, the
+	// workers never wait for the owner of Go, which may wait for them
+	mutexCb sync.Mutex
+	cbs     *list.List
+	sending bool
+}
+
+// false if task is rejected, task is called by submit for RejectCallerRuns
+func (p *pool) submit(task func()) bool {
+	p.mutex.Lock()
+	for p.workers == p.maxWorkers && p.queue.Len() >= p.queueLen {
+		switch p.policy {
+		case RejectCallerRuns:
+			p.mutex.Unlock()
+			task()
+			return true
+		case RejectDiscard:
+			p.mutex.Unlock()
+			return false
+		}
+		p.cond.Wait()
+	}
+
+	if p.workers < p.maxWorkers {
+		p.workers++
+		p.mutex.Unlock()
+		go p.work(task)
+		return true
+	}
+	p.queue.PushBack(task)
+	p.mutex.Unlock()
+	return true
+}
+
+// a worker exits when the queue is empty
+func (p *pool) work(task func()) {
+	for task != nil {
+		task()
+
+		p.mutex.Lock()
+		if p.queue.Len() > 0 {
+			task = p.queue.Remove(p.queue.Front()).(func())
+		} else {
+			task = nil
+			p.workers--
+		}
+		p.cond.Signal()
+		p.mutex.Unlock()
+	}
+}
+
+// cb is sent to ChanCb in order
+func (p *pool) deliver(cb func(), chanCb chan func()) {
+	p.mutexCb.Lock()
+	defer p.mutexCb.Unlock()
+
+	p.cbs.PushBack(cb)
+	if !p.sending {
+		p.sending = true
+		go p.send(chanCb)
+	}
+}
+
+func (p *pool) send(chanCb chan func()) {
+	for {
+		p.mutexCb.Lock()
+		if p.cbs.Len() == 0 {
+			p.sending = false
+			p.mutexCb.Unlock()
+			return
+		}
+		cb := p.cbs.Remove(p.cbs.Front()).(func())
+		p.mutexCb.Unlock()
+
+		chanCb <- cb
+	}
+}
+
+// task runs on a worker of the pool, or a goroutine of its own, false if
+// rejected
+func (g *Go) start(task func()) bool {
+	if g.pool == nil {
+		go task()
+		return true
+	}
+	return g.pool.submit(task)
+}
+
+// cb is sent to ChanCb
+func (g *Go) deliver(cb func()) {
+	if g.pool == nil {
+		g.ChanCb <- cb
+		return
+	}
+	g.pool.deliver(cb, g.ChanCb)
+}
+
+func (g *Go) reject() {
+	atomic.AddInt32(&g.pendingGo, -1)
+	goRejected.Inc()
+	g.errorf("go: pool full, call discarded")
+}
+
+// f is called, its panic is logged
+func (g *Go) safeCall(f func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			if conf.LenStackBuf > 0 {
+				buf := make([]byte, conf.LenStackBuf)
+				l := runtime.Stack(buf, false)
+				g.errorf("%v: %s", r, buf[:l])
+			} else {
+				g.errorf("%v", r)
+			}
+		}
+	}()
+
+	f()
+}
+
+// the calls of a key run one after the other, in order, as in a
+// LinearContext per key, the calls of different keys run concurrently
+type KeyedContext struct {
+	g     *Go
+	mutex sync.Mutex
+	// the calls waiting, by key running
+	keys map[interface{}]*list.List
+}
+
+func (g *Go) NewKeyedContext() *KeyedContext {
+	c := new(KeyedContext)
+	c.g = g
+	c.keys = make(map[interface{}]*list.List)
+	return c
+}
+
+// the calls of a key running do not count in the queue of the pool
+func (c *KeyedContext) Go(key interface{}, f func(), cb func()) {
+	atomic.AddInt32(&c.g.pendingGo, 1)
+
+	c.mutex.Lock()
+	if waiting, ok := c.keys[key]; ok {
+		waiting.PushBack(&LinearGo{f: f, cb: cb})
+		c.mutex.Unlock()
+		return
+	}
+	c.keys[key] = list.New()
+	c.mutex.Unlock()
+
+	if !c.g.start(func() { c.run(key, f, cb) }) {
+		c.mutex.Lock()
+		delete(c.keys, key)
+		c.mutex.Unlock()
+		c.g.reject()
+	}
+}
+
+// the calls of key until none is waiting
+func (c *KeyedContext) run(key interface{}, f func(), cb func()) {
+	for {
+		c.g.safeCall(f)
+		c.g.deliver(cb)
+
+		c.mutex.Lock()
+		waiting := c.keys[key]
+		if waiting.Len() == 0 {
+			delete(c.keys, key)
+			c.mutex.Unlock()
+			return
+		}
+		e := waiting.Remove(waiting.Front()).(*LinearGo)
+		c.mutex.Unlock()
+
+		f, cb = e.f, e.cb
+	}
+}
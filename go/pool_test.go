@@ -0,0 +1,115 @@
+package g_test
+
+import (
+	"context"
+	"github.com/name5566/leaf/go"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPool(t *testing.T) {
+	d := g.NewPool(1, 4, 8, g.RejectBlock)
+
+	var running, max int32
+	cbs := 0
+	for i := 0; i < 100; i++ {
+		d.Go(func() {
+			n := atomic.AddInt32(&running, 1)
+			for {
+				m := atomic.LoadInt32(&max)
+				if n <= m || atomic.CompareAndSwapInt32(&max, m, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&running, -1)
+		}, func() {
+			cbs++
+		})
+	}
+	d.Close()
+
+	if max > 4 || cbs != 100 || !d.Idle() {
+		t.Fatalf("%v running at most, %v callbacks", max, cbs)
+	}
+}
+
+func TestPoolReject(t *testing.T) {
+	for _, policy := range []g.RejectPolicy{g.RejectCallerRuns, g.RejectDiscard} {
+		d := g.NewPool(10, 1, 1, policy)
+
+		// a worker busy, a call queued
+		release := make(chan struct{})
+		d.Go(func() { <-release }, nil)
+		d.Go(func() {}, nil)
+
+		calls, cbs := 0, 0
+		d.Go(func() { calls++ }, func() { cbs++ })
+		close(release)
+		d.Close()
+
+		want := 0
+		if policy == g.RejectCallerRuns {
+			want = 1
+		}
+		if calls != want || cbs != want || !d.Idle() {
+			t.Fatalf("policy %v: %v calls, %v callbacks", policy, calls, cbs)
+		}
+	}
+}
+
+func TestGoContextReject(t *testing.T) {
+	d := g.NewPool(10, 1, 1, g.RejectDiscard)
+
+	release := make(chan struct{})
+	d.Go(func() { <-release }, nil)
+	d.Go(func() {}, nil)
+
+	var err error
+	d.GoContext(func(ctx context.Context) (interface{}, error) {
+		t.Error("rejected f called")
+		return nil, nil
+	}, func(ret interface{}, e error) {
+		err = e
+	})
+	close(release)
+	d.Close()
+
+	if err != g.ErrRejected || !d.Idle() {
+		t.Fatalf("err %v", err)
+	}
+}
+
+func TestKeyedContext(t *testing.T) {
+	for name, d := range map[string]*g.Go{
+		"goroutines": g.New(10),
+		"pool":       g.NewPool(10, 2, 100, g.RejectBlock),
+	} {
+		c := d.NewKeyedContext()
+
+		var mutex sync.Mutex
+		order := make(map[int][]int)
+		for i := 0; i < 50; i++ {
+			i := i
+			key := i % 3
+			c.Go(key, func() {
+				// the first calls of a key are the slowest
+				time.Sleep(time.Duration(50-i) * 10 * time.Microsecond)
+				mutex.Lock()
+				order[key] = append(order[key], i)
+				mutex.Unlock()
+			}, nil)
+		}
+		d.Close()
+
+		for key, calls := range order {
+			for j, i := range calls {
+				if i != key+j*3 {
+					t.Fatalf("%v: key %v called in order %v", name, key, calls)
+				}
+			}
+		}
+	}
+}
@@ -1,11 +1,13 @@
 package leaf
 
 import (
+	"github.com/name5566/leaf/admin"
 	"github.com/name5566/leaf/cluster"
 	"github.com/name5566/leaf/conf"
 	"github.com/name5566/leaf/console"
 	"github.com/name5566/leaf/log"
 	"github.com/name5566/leaf/module"
+	"github.com/name5566/leaf/trace"
 	"os"
 	"os/signal"
 )
@@ -13,11 +15,37 @@ import (
 func Run(mods ...module.Module) {
 	// logger
 	if conf.LogLevel != "" {
-		logger := log.NewLoggerZap(conf.LogLevel, conf.LogPath)
+		logger, err := log.NewLoggerZapConfig(&log.Config{
+			Level:         conf.LogLevel,
+			Path:          conf.LogPath,
+			FileName:      conf.LogFileName,
+			ErrorFileName: conf.LogErrorFileName,
+			NoErrorFile:   conf.LogNoErrorFile,
+			Stdout:        conf.LogStdout,
+			MaxSize:       conf.LogMaxSize,
+			MaxAge:        conf.LogMaxAge,
+			MaxBackups:    conf.LogMaxBackups,
+			Compress:      conf.LogCompress,
+			Encoding:      conf.LogEncoding,
+			TimeFormat:    conf.LogTimeFormat,
+		})
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
 		log.Export(logger)
 		defer logger.Close()
 	}
 
+	// trace
+	if conf.TraceFile != "" {
+		exporter, err := trace.NewFileExporter(conf.TraceFile)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		trace.SetExporter(exporter)
+		defer trace.Shutdown()
+	}
+
 	log.Infof("Leaf %v starting up", version)
 
 	// module
@@ -32,11 +60,15 @@ func Run(mods ...module.Module) {
 	// console
 	console.Init()
 
+	// admin
+	admin.Init()
+
 	// close
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, os.Kill)
 	sig := <-c
 	log.Infof("Leaf closing down (signal: %v)", sig)
+	admin.Destroy()
 	console.Destroy()
 	cluster.Destroy()
 	module.Destroy()
@@ -0,0 +1,119 @@
+package log
+
+import (
+	"fmt"
+)
+
+// adds the key-value pairs returned by fields, called for every message
+type funcLogger struct {
+	// reports the caller of the methods
+	logger FieldLogger
+	fields func() []interface{}
+}
+
+// a child of logger adding the key-value pairs returned by fields to every
+// message, fields is called by the goroutine logging, e.g. the span being
+// handled by a module, see module.Skeleton.Logger
+func WithFunc(logger FieldLogger, fields func() []interface{}) FieldLogger {
+	l := new(funcLogger)
+	l.logger = logger
+	if s, ok := logger.(callerSkipper); ok {
+		if c, ok := s.withCallerSkip(1).(FieldLogger); ok {
+			l.logger = c
+		}
+	}
+	l.fields = fields
+	return l
+}
+
+func (l *funcLogger) With(keysAndValues ...interface{}) FieldLogger {
+	return &funcLogger{logger: l.logger.With(keysAndValues...), fields: l.fields}
+}
+
+func (l *funcLogger) Named(name string) FieldLogger {
+	return &funcLogger{logger: l.logger.Named(name), fields: l.fields}
+}
+
+// the key-value pairs of the message, nil if none
+func (l *funcLogger) with(keysAndValues []interface{}) []interface{} {
+	fields := l.fields()
+	if len(fields) == 0 {
+		return keysAndValues
+	}
+	return append(fields[:len(fields):len(fields)], keysAndValues...)
+}
+
+func (l *funcLogger) Debug(v ...interface{}) {
+	l.logger.Debugw(fmt.Sprint(v...), l.with(nil)...)
+}
+
+func (l *funcLogger) Debugf(format string, v ...interface{}) {
+	l.logger.Debugw(fmt.Sprintf(format, v...), l.with(nil)...)
+}
+
+func (l *funcLogger) Debugw(msg string, keysAndValues ...interface{}) {
+	l.logger.Debugw(msg, l.with(keysAndValues)...)
+}
+
+func (l *funcLogger) Info(v ...interface{}) {
+	l.logger.Infow(fmt.Sprint(v...), l.with(nil)...)
+}
+
+func (l *funcLogger) Infof(format string, v ...interface{}) {
+	l.logger.Infow(fmt.Sprintf(format, v...), l.with(nil)...)
+}
+
+func (l *funcLogger) Infow(msg string, keysAndValues ...interface{}) {
+	l.logger.Infow(msg, l.with(keysAndValues)...)
+}
+
+func (l *funcLogger) Warn(v ...interface{}) {
+	l.logger.Warnw(fmt.Sprint(v...), l.with(nil)...)
+}
+
+func (l *funcLogger) Warnf(format string, v ...interface{}) {
+	l.logger.Warnw(fmt.Sprintf(format, v...), l.with(nil)...)
+}
+
+func (l *funcLogger) Warnw(msg string, keysAndValues ...interface{}) {
+	l.logger.Warnw(msg, l.with(keysAndValues)...)
+}
+
+func (l *funcLogger) Error(v ...interface{}) {
+	l.logger.Errorw(fmt.Sprint(v...), l.with(nil)...)
+}
+
+func (l *funcLogger) Errorf(format string, v ...interface{}) {
+	l.logger.Errorw(fmt.Sprintf(format, v...), l.with(nil)...)
+}
+
+func (l *funcLogger) Errorw(msg string, keysAndValues ...interface{}) {
+	l.logger.Errorw(msg, l.with(keysAndValues)...)
+}
+
+func (l *funcLogger) Panic(v ...interface{}) {
+	l.logger.Panicw(fmt.Sprint(v...), l.with(nil)...)
+}
+
+func (l *funcLogger) Panicf(format string, v ...interface{}) {
+	l.logger.Panicw(fmt.Sprintf(format, v...), l.with(nil)...)
+}
+
+func (l *funcLogger) Panicw(msg string, keysAndValues ...interface{}) {
+	l.logger.Panicw(msg, l.with(keysAndValues)...)
+}
+
+func (l *funcLogger) Fatal(v ...interface{}) {
+	l.logger.Fatalw(fmt.Sprint(v...), l.with(nil)...)
+}
+
+func (l *funcLogger) Fatalf(format string, v ...interface{}) {
+	l.logger.Fatalw(fmt.Sprintf(format, v...), l.with(nil)...)
+}
+
+func (l *funcLogger) Fatalw(msg string, keysAndValues ...interface{}) {
+	l.logger.Fatalw(msg, l.with(keysAndValues)...)
+}
+
+// logger is closed by its owner
+func (l *funcLogger) Close() {}
@@ -13,6 +13,10 @@ func Example() {
 	log.Errorf("My name is %v", name)
 	// log.Fatalf("My name is %v", name)
 
+	// the fields are added to every message of the child logger
+	userLog := log.Named("game").With("user", 1001)
+	userLog.Infow("login", "ip", "127.0.0.1")
+
 	logger, err := log.NewLoggerLeaf("release", "", l.LstdFlags)
 	if err != nil {
 		return
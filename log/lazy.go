@@ -0,0 +1,139 @@
+package log
+
+import (
+	"sync/atomic"
+)
+
+// incremented by Export
+var exported uint32
+
+// a child of the exported logger, made again when another logger is
+// exported, the children made before Export, as by the initialization of a
+// package, log to the logger exported by leaf.Run
+// goroutine safe
+type lazyLogger struct {
+	child func(parent FieldLogger) FieldLogger
+	// *lazyChild
+	cached atomic.Value
+}
+
+type lazyChild struct {
+	exported uint32
+	logger   FieldLogger
+}
+
+func newLazyLogger(child func(parent FieldLogger) FieldLogger) *lazyLogger {
+	l := new(lazyLogger)
+	l.child = child
+	return l
+}
+
+func (l *lazyLogger) get() FieldLogger {
+	n := atomic.LoadUint32(&exported)
+	if c, ok := l.cached.Load().(*lazyChild); ok && c.exported == n {
+		return c.logger
+	}
+	c := &lazyChild{exported: n, logger: l.child(fieldLogger(gPkgLogger))}
+	l.cached.Store(c)
+	return c.logger
+}
+
+// the children report the callers skip more frames up the stack
+func (l *lazyLogger) withCallerSkip(skip int) Logger {
+	return newLazyLogger(func(parent FieldLogger) FieldLogger {
+		c := l.child(parent)
+		if s, ok := c.(callerSkipper); ok {
+			if c, ok := s.withCallerSkip(skip).(FieldLogger); ok {
+				return c
+			}
+		}
+		return c
+	})
+}
+
+func (l *lazyLogger) With(keysAndValues ...interface{}) FieldLogger {
+	return newLazyLogger(func(parent FieldLogger) FieldLogger {
+		return l.child(parent).With(keysAndValues...)
+	})
+}
+
+func (l *lazyLogger) Named(name string) FieldLogger {
+	return newLazyLogger(func(parent FieldLogger) FieldLogger {
+		return l.child(parent).Named(name)
+	})
+}
+
+func (l *lazyLogger) Debug(v ...interface{}) {
+	l.get().Debug(v...)
+}
+
+func (l *lazyLogger) Debugf(format string, v ...interface{}) {
+	l.get().Debugf(format, v...)
+}
+
+func (l *lazyLogger) Debugw(msg string, keysAndValues ...interface{}) {
+	l.get().Debugw(msg, keysAndValues...)
+}
+
+func (l *lazyLogger) Info(v ...interface{}) {
+	l.get().Info(v...)
+}
+
+func (l *lazyLogger) Infof(format string, v ...interface{}) {
+	l.get().Infof(format, v...)
+}
+
+func (l *lazyLogger) Infow(msg string, keysAndValues ...interface{}) {
+	l.get().Infow(msg, keysAndValues...)
+}
+
+func (l *lazyLogger) Warn(v ...interface{}) {
+	l.get().Warn(v...)
+}
+
+func (l *lazyLogger) Warnf(format string, v ...interface{}) {
+	l.get().Warnf(format, v...)
+}
+
+func (l *lazyLogger) Warnw(msg string, keysAndValues ...interface{}) {
+	l.get().Warnw(msg, keysAndValues...)
+}
+
+func (l *lazyLogger) Error(v ...interface{}) {
+	l.get().Error(v...)
+}
+
+func (l *lazyLogger) Errorf(format string, v ...interface{}) {
+	l.get().Errorf(format, v...)
+}
+
+func (l *lazyLogger) Errorw(msg string, keysAndValues ...interface{}) {
+	l.get().Errorw(msg, keysAndValues...)
+}
+
+func (l *lazyLogger) Panic(v ...interface{}) {
+	l.get().Panic(v...)
+}
+
+func (l *lazyLogger) Panicf(format string, v ...interface{}) {
+	l.get().Panicf(format, v...)
+}
+
+func (l *lazyLogger) Panicw(msg string, keysAndValues ...interface{}) {
+	l.get().Panicw(msg, keysAndValues...)
+}
+
+func (l *lazyLogger) Fatal(v ...interface{}) {
+	l.get().Fatal(v...)
+}
+
+func (l *lazyLogger) Fatalf(format string, v ...interface{}) {
+	l.get().Fatalf(format, v...)
+}
+
+func (l *lazyLogger) Fatalw(msg string, keysAndValues ...interface{}) {
+	l.get().Fatalw(msg, keysAndValues...)
+}
+
+// the exported logger is closed by Close
+func (l *lazyLogger) Close() {}
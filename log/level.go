@@ -0,0 +1,102 @@
+package log
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// levels
+const (
+	debugLevel = 0
+	infoLevel  = 1
+	warnLevel  = 2
+	errorLevel = 3
+	panicLevel = 4
+	fatalLevel = 5
+)
+
+var levelNames = []string{"debug", "info", "warn", "error", "panic", "fatal"}
+
+func parseLevel(strLevel string) (int, error) {
+	strLevel = strings.ToLower(strLevel)
+	for level, name := range levelNames {
+		if name == strLevel {
+			return level, nil
+		}
+	}
+	return 0, errors.New("unknown level: " + strLevel)
+}
+
+// the levels of a logger and its children, the level of a named logger
+// is overridden by the level set for its name or the closest ancestor name
+// goroutine safe
+type levels struct {
+	level int32
+	// name -> level, copied on write
+	named      atomic.Value
+	mutexNamed sync.Mutex
+}
+
+func newLevels(level int) *levels {
+	lv := new(levels)
+	lv.level = int32(level)
+	lv.named.Store(map[string]int{})
+	return lv
+}
+
+func (lv *levels) get(name string) int {
+	named := lv.named.Load().(map[string]int)
+	for len(named) > 0 && name != "" {
+		if level, ok := named[name]; ok {
+			return level
+		}
+		i := strings.LastIndexByte(name, '.')
+		if i < 0 {
+			break
+		}
+		name = name[:i]
+	}
+	return int(atomic.LoadInt32(&lv.level))
+}
+
+func (lv *levels) enabled(name string, level int) bool {
+	return level >= lv.get(name)
+}
+
+func (lv *levels) set(strLevel string) error {
+	level, err := parseLevel(strLevel)
+	if err != nil {
+		return err
+	}
+	atomic.StoreInt32(&lv.level, int32(level))
+	return nil
+}
+
+// an empty strLevel removes the override
+func (lv *levels) setNamed(name string, strLevel string) error {
+	level := -1
+	if strLevel != "" {
+		var err error
+		level, err = parseLevel(strLevel)
+		if err != nil {
+			return err
+		}
+	}
+
+	lv.mutexNamed.Lock()
+	defer lv.mutexNamed.Unlock()
+	old := lv.named.Load().(map[string]int)
+	named := make(map[string]int, len(old)+1)
+	for n, l := range old {
+		named[n] = l
+	}
+	if level < 0 {
+		delete(named, name)
+	} else {
+		named[name] = level
+	}
+	lv.named.Store(named)
+	return nil
+}
@@ -1,7 +1,20 @@
 package log
 
-var gLogger Logger = NewLoggerZap("debug", "")
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
 
+var (
+	gLogger Logger
+	// gLogger reporting the callers of the package functions
+	gPkgLogger Logger
+)
+
+func init() {
+	Export(NewLoggerZap("debug", ""))
+}
 
 type Logger interface {
 	Debug(v ...interface{})
@@ -25,47 +38,283 @@ type Logger interface {
 	Close()
 }
 
+// structured logging, implemented by the loggers of the package, the
+// package functions append the key-value pairs to the messages of the
+// loggers which do not implement it
+type FieldLogger interface {
+	Logger
+
+	Debugw(msg string, keysAndValues ...interface{})
+	Infow(msg string, keysAndValues ...interface{})
+	Warnw(msg string, keysAndValues ...interface{})
+	Errorw(msg string, keysAndValues ...interface{})
+	Panicw(msg string, keysAndValues ...interface{})
+	Fatalw(msg string, keysAndValues ...interface{})
+
+	// a child logger adding the key-value pairs to every message, e.g.
+	// logger.With("user", id).Infow("login", "ip", ip)
+	With(keysAndValues ...interface{}) FieldLogger
+	// a child logger named name, the names of the descendants are joined
+	// with dots, e.g. "game.room"
+	Named(name string) FieldLogger
+}
+
+// runtime levels, implemented by the loggers of the package
+type LevelLogger interface {
+	// the level of the logger and its children, goroutine safe
+	SetLevel(level string) error
+	// overrides the level of the descendants named name or whose name
+	// starts with name + ".", an empty level removes the override,
+	// goroutine safe
+	SetNamedLevel(name string, level string) error
+}
+
+// implemented by the loggers of the package
+type callerSkipper interface {
+	// a logger reporting the caller skip more frames up the stack
+	withCallerSkip(skip int) Logger
+}
+
+// the structured logging of a Logger without any, the name and the
+// key-value pairs are added to the messages
+type plainLogger struct {
+	Logger
+	name string
+	// " key=value" pairs added by With
+	fields string
+}
+
+func fieldLogger(logger Logger) FieldLogger {
+	if l, ok := logger.(FieldLogger); ok {
+		return l
+	}
+	return &plainLogger{Logger: logger}
+}
+
+func (l *plainLogger) message(msg string, keysAndValues []interface{}) string {
+	if l.name == "" && l.fields == "" && len(keysAndValues) == 0 {
+		return msg
+	}
+
+	var b []byte
+	if l.name != "" {
+		b = append(b, l.name...)
+		b = append(b, ": "...)
+	}
+	b = append(b, msg...)
+	b = append(b, l.fields...)
+	b = appendFields(b, keysAndValues)
+	return string(b)
+}
+
+func (l *plainLogger) Debug(v ...interface{}) {
+	l.Logger.Debug(l.message(fmt.Sprint(v...), nil))
+}
+
+func (l *plainLogger) Debugf(format string, v ...interface{}) {
+	l.Logger.Debug(l.message(fmt.Sprintf(format, v...), nil))
+}
+
+func (l *plainLogger) Debugw(msg string, keysAndValues ...interface{}) {
+	l.Logger.Debug(l.message(msg, keysAndValues))
+}
+
+func (l *plainLogger) Info(v ...interface{}) {
+	l.Logger.Info(l.message(fmt.Sprint(v...), nil))
+}
+
+func (l *plainLogger) Infof(format string, v ...interface{}) {
+	l.Logger.Info(l.message(fmt.Sprintf(format, v...), nil))
+}
+
+func (l *plainLogger) Infow(msg string, keysAndValues ...interface{}) {
+	l.Logger.Info(l.message(msg, keysAndValues))
+}
+
+func (l *plainLogger) Warn(v ...interface{}) {
+	l.Logger.Warn(l.message(fmt.Sprint(v...), nil))
+}
+
+func (l *plainLogger) Warnf(format string, v ...interface{}) {
+	l.Logger.Warn(l.message(fmt.Sprintf(format, v...), nil))
+}
+
+func (l *plainLogger) Warnw(msg string, keysAndValues ...interface{}) {
+	l.Logger.Warn(l.message(msg, keysAndValues))
+}
+
+func (l *plainLogger) Error(v ...interface{}) {
+	l.Logger.Error(l.message(fmt.Sprint(v...), nil))
+}
+
+func (l *plainLogger) Errorf(format string, v ...interface{}) {
+	l.Logger.Error(l.message(fmt.Sprintf(format, v...), nil))
+}
+
+func (l *plainLogger) Errorw(msg string, keysAndValues ...interface{}) {
+	l.Logger.Error(l.message(msg, keysAndValues))
+}
+
+func (l *plainLogger) Panic(v ...interface{}) {
+	l.Logger.Panic(l.message(fmt.Sprint(v...), nil))
+}
+
+func (l *plainLogger) Panicf(format string, v ...interface{}) {
+	l.Logger.Panic(l.message(fmt.Sprintf(format, v...), nil))
+}
+
+func (l *plainLogger) Panicw(msg string, keysAndValues ...interface{}) {
+	l.Logger.Panic(l.message(msg, keysAndValues))
+}
+
+func (l *plainLogger) Fatal(v ...interface{}) {
+	l.Logger.Fatal(l.message(fmt.Sprint(v...), nil))
+}
+
+func (l *plainLogger) Fatalf(format string, v ...interface{}) {
+	l.Logger.Fatal(l.message(fmt.Sprintf(format, v...), nil))
+}
+
+func (l *plainLogger) Fatalw(msg string, keysAndValues ...interface{}) {
+	l.Logger.Fatal(l.message(msg, keysAndValues))
+}
+
+func (l *plainLogger) With(keysAndValues ...interface{}) FieldLogger {
+	c := *l
+	c.fields = string(appendFields([]byte(l.fields), keysAndValues))
+	return &c
+}
+
+func (l *plainLogger) Named(name string) FieldLogger {
+	c := *l
+	if c.name == "" {
+		c.name = name
+	} else {
+		c.name += "." + name
+	}
+	return &c
+}
+
+// the root logger is closed by Close
+func (l *plainLogger) Close() {}
+
+// the children of the package functions, made by With and Named, log to
+// logger from now on
 func Export(logger Logger) {
 	gLogger = logger
+	gPkgLogger = logger
+	if s, ok := logger.(callerSkipper); ok {
+		gPkgLogger = s.withCallerSkip(1)
+	}
+	atomic.AddUint32(&exported, 1)
+}
+
+var errNoLevels = errors.New("log: the logger has no runtime levels")
+
+// see LevelLogger
+// goroutine safe
+func SetLevel(level string) error {
+	if l, ok := gLogger.(LevelLogger); ok {
+		return l.SetLevel(level)
+	}
+	return errNoLevels
+}
+
+// see LevelLogger
+// goroutine safe
+func SetNamedLevel(name string, level string) error {
+	if l, ok := gLogger.(LevelLogger); ok {
+		return l.SetNamedLevel(name, level)
+	}
+	return errNoLevels
+}
+
+// a child of the exported logger, even if exported later, see
+// FieldLogger.With
+func With(keysAndValues ...interface{}) FieldLogger {
+	return newLazyLogger(func(parent FieldLogger) FieldLogger {
+		return parent.With(keysAndValues...)
+	})
+}
+
+// a child of the exported logger, even if exported later, see
+// FieldLogger.Named
+func Named(name string) FieldLogger {
+	return newLazyLogger(func(parent FieldLogger) FieldLogger {
+		return parent.Named(name)
+	})
 }
 
 func Debug(v ...interface{}) {
-	gLogger.Debug(v...)
+	gPkgLogger.Debug(v...)
 }
 
 func Debugf(format string, v ...interface{}) {
-	gLogger.Debugf(format, v...)
+	gPkgLogger.Debugf(format, v...)
+}
+
+func Debugw(msg string, keysAndValues ...interface{}) {
+	fieldLogger(gPkgLogger).Debugw(msg, keysAndValues...)
 }
 
 func Info(v ...interface{}) {
-	gLogger.Info(v...)
+	gPkgLogger.Info(v...)
 }
 
 func Infof(format string, v ...interface{}) {
-	gLogger.Infof(format, v...)
+	gPkgLogger.Infof(format, v...)
+}
+
+func Infow(msg string, keysAndValues ...interface{}) {
+	fieldLogger(gPkgLogger).Infow(msg, keysAndValues...)
 }
 
 func Warn(v ...interface{}) {
-	gLogger.Warn(v...)
+	gPkgLogger.Warn(v...)
 }
 
 func Warnf(format string, v ...interface{}) {
-	gLogger.Warnf(format, v...)
+	gPkgLogger.Warnf(format, v...)
+}
+
+func Warnw(msg string, keysAndValues ...interface{}) {
+	fieldLogger(gPkgLogger).Warnw(msg, keysAndValues...)
 }
 
 func Error(v ...interface{}) {
-	gLogger.Error(v...)
+	gPkgLogger.Error(v...)
 }
+
 func Errorf(format string, v ...interface{}) {
-	gLogger.Errorf(format, v...)
+	gPkgLogger.Errorf(format, v...)
+}
+
+func Errorw(msg string, keysAndValues ...interface{}) {
+	fieldLogger(gPkgLogger).Errorw(msg, keysAndValues...)
+}
+
+func Panic(v ...interface{}) {
+	gPkgLogger.Panic(v...)
+}
+
+func Panicf(format string, v ...interface{}) {
+	gPkgLogger.Panicf(format, v...)
+}
+
+func Panicw(msg string, keysAndValues ...interface{}) {
+	fieldLogger(gPkgLogger).Panicw(msg, keysAndValues...)
 }
 
 func Fatal(v ...interface{}) {
-	gLogger.Fatal(v...)
+	gPkgLogger.Fatal(v...)
 }
 
 func Fatalf(format string, v ...interface{}) {
-	gLogger.Fatalf(format, v...)
+	gPkgLogger.Fatalf(format, v...)
+}
+
+func Fatalw(msg string, keysAndValues ...interface{}) {
+	fieldLogger(gPkgLogger).Fatalw(msg, keysAndValues...)
 }
 
 func Close() {
@@ -1,25 +1,15 @@
 package log
 
 import (
-	"errors"
 	"fmt"
 	"log"
 	"os"
 	"path"
+	"strconv"
 	"strings"
 	"time"
 )
 
-// levels
-const (
-	debugLevel   = 0
-	infoLevel = 1
-	warnLevel = 2
-	errorLevel   = 3
-	panicLevel   = 4
-	fatalLevel   = 5
-)
-
 const (
 	printDebugLevel   = "[debug ] "
 	printInfoLevel 	  = "[info ] "
@@ -30,29 +20,22 @@ const (
 )
 
 type LoggerLeaf struct {
-	level      int
+	levels     *levels
 	baseLogger *log.Logger
 	baseFile   *os.File
+	name       string
+	// " key=value" pairs added by With
+	fields    string
+	callDepth int
+	// false for the children, which do not own the output
+	root bool
 }
 
 func NewLoggerLeaf(strLevel string, pathname string, flag int) (*LoggerLeaf, error) {
 	// level
-	var level int
-	switch strings.ToLower(strLevel) {
-	case "debug":
-		level = debugLevel
-	case "info":
-		level = infoLevel
-	case "warn":
-		level = warnLevel
-	case "error":
-		level = errorLevel
-	case "panic":
-		level = panicLevel
-	case "fatal":
-		level = fatalLevel
-	default:
-		return nil, errors.New("unknown level: " + strLevel)
+	level, err := parseLevel(strLevel)
+	if err != nil {
+		return nil, err
 	}
 
 	// logger
@@ -82,15 +65,21 @@ func NewLoggerLeaf(strLevel string, pathname string, flag int) (*LoggerLeaf, err
 
 	// new
 	logger := new(LoggerLeaf)
-	logger.level = level
+	logger.levels = newLevels(level)
 	logger.baseLogger = baseLogger
 	logger.baseFile = baseFile
+	logger.root = true
+	logger.callDepth = 4
 
 	return logger, nil
 }
 
 // It's dangerous to call the method on logging
+// only the root logger is closed, with its children
 func (logger *LoggerLeaf) Close() {
+	if !logger.root {
+		return
+	}
 	if logger.baseFile != nil {
 		logger.baseFile.Close()
 	}
@@ -99,39 +88,112 @@ func (logger *LoggerLeaf) Close() {
 	logger.baseFile = nil
 }
 
-func (logger *LoggerLeaf) doPrintf(level int, printLevel string, format string, a ...interface{}) {
-	if level < logger.level {
-		return
+// the children share the output of logger, closing them does nothing
+func (logger *LoggerLeaf) child() *LoggerLeaf {
+	c := new(LoggerLeaf)
+	*c = *logger
+	c.root = false
+	return c
+}
+
+func (logger *LoggerLeaf) withCallerSkip(skip int) Logger {
+	c := logger.child()
+	c.callDepth += skip
+	return c
+}
+
+// the level of the logger and its children
+func (logger *LoggerLeaf) SetLevel(strLevel string) error {
+	return logger.levels.set(strLevel)
+}
+
+// overrides the level of the descendants named name, see Logger.SetNamedLevel
+func (logger *LoggerLeaf) SetNamedLevel(name string, strLevel string) error {
+	return logger.levels.setNamed(name, strLevel)
+}
+
+func (logger *LoggerLeaf) With(keysAndValues ...interface{}) FieldLogger {
+	c := logger.child()
+	c.fields = string(appendFields([]byte(logger.fields), keysAndValues))
+	return c
+}
+
+func (logger *LoggerLeaf) Named(name string) FieldLogger {
+	c := logger.child()
+	if c.name == "" {
+		c.name = name
+	} else {
+		c.name += "." + name
 	}
+	return c
+}
+
+// " key=value" for every pair, the values are quoted if needed
+func appendFields(b []byte, keysAndValues []interface{}) []byte {
+	for i := 0; i < len(keysAndValues); i += 2 {
+		b = append(b, ' ')
+		if i+1 == len(keysAndValues) {
+			b = append(b, "!BADKEY="...)
+			b = appendValue(b, keysAndValues[i])
+			break
+		}
+		b = append(b, fmt.Sprint(keysAndValues[i])...)
+		b = append(b, '=')
+		b = appendValue(b, keysAndValues[i+1])
+	}
+	return b
+}
+
+func appendValue(b []byte, v interface{}) []byte {
+	s := fmt.Sprint(v)
+	if s == "" || strings.ContainsAny(s, " =\"\n") {
+		return strconv.AppendQuote(b, s)
+	}
+	return append(b, s...)
+}
+
+func (logger *LoggerLeaf) output(level int, printLevel string, msg string, keysAndValues []interface{}) {
 	if logger.baseLogger == nil {
 		panic("logger closed")
 	}
 
-	format = printLevel + format
-	logger.baseLogger.Output(3, fmt.Sprintf(format, a...))
+	b := make([]byte, 0, len(printLevel)+len(logger.name)+len(msg)+len(logger.fields)+2)
+	b = append(b, printLevel...)
+	if logger.name != "" {
+		b = append(b, logger.name...)
+		b = append(b, ": "...)
+	}
+	b = append(b, msg...)
+	b = append(b, logger.fields...)
+	b = appendFields(b, keysAndValues)
+	logger.baseLogger.Output(logger.callDepth, string(b))
 
 	if level == fatalLevel {
 		os.Exit(1)
-	}else if level == panicLevel{
-		panic(fmt.Sprintf(format,a...))
+	} else if level == panicLevel {
+		panic(msg)
 	}
 }
 
-func (logger *LoggerLeaf) doPrint(level int, printLevel string, a ...interface{}) {
-	if level < logger.level {
+func (logger *LoggerLeaf) doPrintf(level int, printLevel string, format string, a ...interface{}) {
+	if !logger.levels.enabled(logger.name, level) {
 		return
 	}
-	if logger.baseLogger == nil {
-		panic("logger closed")
-	}
+	logger.output(level, printLevel, fmt.Sprintf(format, a...), nil)
+}
 
-	logger.baseLogger.Output(3, fmt.Sprintf(printLevel,a...))
+func (logger *LoggerLeaf) doPrint(level int, printLevel string, a ...interface{}) {
+	if !logger.levels.enabled(logger.name, level) {
+		return
+	}
+	logger.output(level, printLevel, fmt.Sprint(a...), nil)
+}
 
-	if level == fatalLevel {
-		os.Exit(1)
-	}else if level == panicLevel{
-		panic(fmt.Sprintf(printLevel,a...))
+func (logger *LoggerLeaf) doPrintw(level int, printLevel string, msg string, keysAndValues []interface{}) {
+	if !logger.levels.enabled(logger.name, level) {
+		return
 	}
+	logger.output(level, printLevel, msg, keysAndValues)
 }
 
 func (logger *LoggerLeaf) Debugf(format string, a ...interface{}) {
@@ -182,4 +244,26 @@ func (logger *LoggerLeaf) Fatal(a ...interface{}) {
 	logger.doPrint(fatalLevel, printFatalLevel, a...)
 }
 
+func (logger *LoggerLeaf) Debugw(msg string, keysAndValues ...interface{}) {
+	logger.doPrintw(debugLevel, printDebugLevel, msg, keysAndValues)
+}
+
+func (logger *LoggerLeaf) Infow(msg string, keysAndValues ...interface{}) {
+	logger.doPrintw(infoLevel, printInfoLevel, msg, keysAndValues)
+}
+
+func (logger *LoggerLeaf) Warnw(msg string, keysAndValues ...interface{}) {
+	logger.doPrintw(warnLevel, printWarnLevel, msg, keysAndValues)
+}
+
+func (logger *LoggerLeaf) Errorw(msg string, keysAndValues ...interface{}) {
+	logger.doPrintw(errorLevel, printErrorLevel, msg, keysAndValues)
+}
 
+func (logger *LoggerLeaf) Panicw(msg string, keysAndValues ...interface{}) {
+	logger.doPrintw(panicLevel, printPanicLevel, msg, keysAndValues)
+}
+
+func (logger *LoggerLeaf) Fatalw(msg string, keysAndValues ...interface{}) {
+	logger.doPrintw(fatalLevel, printFatalLevel, msg, keysAndValues)
+}
@@ -0,0 +1,209 @@
+package log
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoggerLeafFields(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leaf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logger, err := NewLoggerLeaf("debug", dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Infow("login", "user", 1, "ip", "127.0.0.1")
+	child := logger.Named("game").Named("room").With("user", 2)
+	child.Infof("joined %v", "room 1")
+	child.Warnw("left", "reason", "timed out", "dangling")
+	child.Close()
+	child.Info("child still open")
+	logger.Debug("still", "open")
+	logger.Close()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.log"))
+	if len(files) != 1 {
+		t.Fatalf("%v log files", len(files))
+	}
+	b, _ := ioutil.ReadFile(files[0])
+	want := []string{
+		"[info ] login user=1 ip=127.0.0.1",
+		"[info ] game.room: joined room 1 user=2",
+		`[warn ] game.room: left user=2 reason="timed out" !BADKEY=dangling`,
+		"[info ] game.room: child still open user=2",
+		"[debug ] stillopen",
+	}
+	if s := strings.TrimSpace(string(b)); s != strings.Join(want, "\n") {
+		t.Fatalf("got\n%v", s)
+	}
+}
+
+// a Logger without structured logging nor levels
+type plainRecorder struct {
+	Logger
+	lines []string
+}
+
+func (r *plainRecorder) Info(v ...interface{}) {
+	r.lines = append(r.lines, fmt.Sprint(v...))
+}
+
+func TestPlainLogger(t *testing.T) {
+	defer Export(gLogger)
+	// made before Export
+	game := Named("game")
+	r := new(plainRecorder)
+	Export(r)
+
+	Infow("login", "user", 1)
+	game.With("user", 2).Infof("joined %v", "room 1")
+	if err := SetLevel("info"); err == nil {
+		t.Fatal("level set")
+	}
+
+	want := []string{"login user=1", "game: joined room 1 user=2"}
+	if strings.Join(r.lines, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("got %q", r.lines)
+	}
+}
+
+func TestWithFunc(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leaf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	leaf, err := NewLoggerLeaf("debug", dir, log.Lshortfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	span := ""
+	logger := WithFunc(leaf.Named("game"), func() []interface{} {
+		if span == "" {
+			return nil
+		}
+		return []interface{}{"span_id", span}
+	})
+	logger.Info("no span")
+	span = "1"
+	logger.Infof("in span %v", span)
+	logger.With("user", 1).Infow("login", "ip", "127.0.0.1")
+	leaf.Close()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.log"))
+	if len(files) != 1 {
+		t.Fatalf("%v log files", len(files))
+	}
+	b, _ := ioutil.ReadFile(files[0])
+	want := []string{
+		"[info ] game: no span",
+		"[info ] game: in span 1 span_id=1",
+		"[info ] game: login user=1 span_id=1 ip=127.0.0.1",
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("got\n%s", b)
+	}
+	for i, line := range lines {
+		if !strings.HasPrefix(line, "log_test.go:") || !strings.HasSuffix(line, want[i]) {
+			t.Fatalf("line %v: %v", i, line)
+		}
+	}
+}
+
+func TestLevels(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leaf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	leaf, err := NewLoggerLeaf("info", dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zap := NewLoggerZap("info", dir)
+
+	for _, logger := range []interface {
+		FieldLogger
+		LevelLogger
+	}{leaf, zap} {
+		room := logger.Named("game").Named("room")
+		logger.Debug("hidden 1")
+		logger.SetNamedLevel("game", "debug")
+		room.Debug("shown 1")
+		logger.Named("gate").Debug("hidden 2")
+		logger.SetLevel("error")
+		room.Info("shown 2")
+		logger.Info("hidden 3")
+		logger.SetNamedLevel("game", "")
+		room.Warn("hidden 4")
+		if err := logger.SetLevel("verbose"); err == nil {
+			t.Fatal("unknown level set")
+		}
+		logger.Close()
+	}
+
+	for _, pattern := range []string{"*_*.log", "default.log"} {
+		files, _ := filepath.Glob(filepath.Join(dir, pattern))
+		if len(files) != 1 {
+			t.Fatalf("%v: %v files", pattern, len(files))
+		}
+		b, _ := ioutil.ReadFile(files[0])
+		s := string(b)
+		if strings.Contains(s, "hidden") || !strings.Contains(s, "shown 1") || !strings.Contains(s, "shown 2") {
+			t.Fatalf("%v:\n%v", pattern, s)
+		}
+	}
+}
+
+func TestLoggerZapConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leaf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err := NewLoggerZapConfig(&Config{Encoding: "xml"}); err == nil {
+		t.Fatal("unknown encoding accepted")
+	}
+
+	logger, err := NewLoggerZapConfig(&Config{
+		Level:       "info",
+		Path:        dir,
+		FileName:    "game.log",
+		NoErrorFile: true,
+		Encoding:    "json",
+		TimeFormat:  "2006",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Debug("hidden")
+	logger.Errorw("failed", "user", 1)
+	logger.Close()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(files) != 1 || filepath.Base(files[0]) != "game.log" {
+		t.Fatalf("files %v", files)
+	}
+	b, _ := ioutil.ReadFile(files[0])
+	var entry map[string]interface{}
+	if err := json.Unmarshal(b, &entry); err != nil {
+		t.Fatalf("%v: %s", err, b)
+	}
+	if entry["msg"] != "failed" || entry["user"] != 1.0 || entry["level"] != "error" || len(entry["ts"].(string)) != 4 {
+		t.Fatalf("entry %v", entry)
+	}
+}
@@ -1,123 +1,272 @@
 package log
 
 import (
+	"errors"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"gopkg.in/natefinch/lumberjack.v2"
+	"io"
 	"os"
 	"path"
+	"time"
 )
 
 
 type LoggerZap struct {
 	sugarLogger *zap.SugaredLogger
+	name        string
+	levels      *levels
+	// the files of the root logger
+	closers []io.Closer
 }
 
-var levelMap = map[string]zapcore.Level{
-	"debug":  zapcore.DebugLevel,
-	"info":   zapcore.InfoLevel,
-	"warn":   zapcore.WarnLevel,
-	"error":  zapcore.ErrorLevel,
-	"panic": zapcore.PanicLevel,
-	"fatal":  zapcore.FatalLevel,
+func getLoggerLevel(lvl string) int {
+	if level, err := parseLevel(lvl); err == nil {
+		return level
+	}
+	return debugLevel
 }
 
-func getLoggerLevel(lvl string) zapcore.Level {
-	if level, ok := levelMap[lvl]; ok {
-		return level
+// filters the entries by the levels of the logger named name, the cores
+// it wraps enable every level
+type levelCore struct {
+	zapcore.Core
+	name   string
+	levels *levels
+}
+
+func newLevelCore(core zapcore.Core, level int) *levelCore {
+	return &levelCore{Core: core, levels: newLevels(level)}
+}
+
+func fromZapLevel(lvl zapcore.Level) int {
+	switch {
+	case lvl <= zapcore.DebugLevel:
+		return debugLevel
+	case lvl == zapcore.InfoLevel:
+		return infoLevel
+	case lvl == zapcore.WarnLevel:
+		return warnLevel
+	case lvl == zapcore.ErrorLevel:
+		return errorLevel
+	case lvl < zapcore.FatalLevel:
+		return panicLevel
+	default:
+		return fatalLevel
 	}
-	return zapcore.DebugLevel
 }
 
-func NewLoggerZap(strLevel string, pathname string) *LoggerZap{
-	if pathname != ""{
-		return newLoggerZapFile(strLevel,pathname)
+func (c *levelCore) Enabled(lvl zapcore.Level) bool {
+	return c.levels.enabled(c.name, fromZapLevel(lvl))
+}
+
+func (c *levelCore) With(fields []zapcore.Field) zapcore.Core {
+	return &levelCore{Core: c.Core.With(fields), name: c.name, levels: c.levels}
+}
+
+func (c *levelCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.Enabled(ent.Level) {
+		return ce
 	}
-	return newLoggerZapStdOut(strLevel, pathname)
+	return c.Core.Check(ent, ce)
 }
 
-func newLoggerZapFile(strLevel string, pathname string)*LoggerZap{
-	encoder := getEncoder()
-	logLevel := getLoggerLevel(strLevel)
-	defaultLevel := zap.LevelEnablerFunc(func(lvl zapcore.Level) bool {
-		return lvl >= logLevel
-	})
-	errorLevel := zap.LevelEnablerFunc(func(lvl zapcore.Level) bool {
-		return lvl >= zapcore.ErrorLevel
-	})
+func newLoggerZap(core *levelCore) *LoggerZap {
+	log := zap.New(core, zap.AddCaller(), zap.AddCallerSkip(1))
+	return &LoggerZap{sugarLogger: log.Sugar(), levels: core.levels}
+}
 
-	logName := path.Join(pathname, "default.log")
-	errLogName := path.Join(pathname, "error.log")
-	infoWriter := getLogWriter(logName)
-	errorWriter := getLogWriter(errLogName)
+// the zero value writes console lines to stdout at the debug level
+type Config struct {
+	// unknown levels are reset to debug
+	Level string
+	// the directory of the log files, the logs are written to stdout if
+	// empty
+	Path string
+	// "default.log" by default
+	FileName string
+	// the errors are also written to ErrorFileName, "error.log" by default
+	ErrorFileName string
+	NoErrorFile   bool
+	// also writes the logs to stdout when Path is set
+	Stdout bool
 
-	core := zapcore.NewTee(
-		zapcore.NewCore(encoder, zapcore.AddSync(infoWriter), defaultLevel),
-		zapcore.NewCore(encoder, zapcore.AddSync(errorWriter), errorLevel),
-	)
-	log := zap.New(core, zap.AddCaller(),zap.AddCallerSkip(2))
-	return &LoggerZap{sugarLogger:log.Sugar()}
+	// rotation, see lumberjack.Logger
+	// megabytes, 100 by default
+	MaxSize int
+	// days, the old files are not removed by age if 0
+	MaxAge int
+	// 10 by default, all the old files are retained if negative
+	MaxBackups int
+	// gzip the old files
+	Compress bool
+
+	// "console" (default) or "json"
+	Encoding string
+	// a time.Format layout, ISO8601 if empty
+	TimeFormat string
 }
 
-func newLoggerZapStdOut(strLevel string, pathname string)*LoggerZap{
-	encoder := getEncoder()
-	logLevel := getLoggerLevel(strLevel)
+// unknown levels are reset to debug
+func NewLoggerZap(strLevel string, pathname string) *LoggerZap {
+	logger, _ := NewLoggerZapConfig(&Config{Level: strLevel, Path: pathname})
+	return logger
+}
 
-	core := zapcore.NewCore(encoder, zapcore.AddSync(os.Stdout), zap.NewAtomicLevelAt(logLevel))
-	log := zap.New(core, zap.AddCaller(),zap.AddCallerSkip(2))
-	return &LoggerZap{sugarLogger:log.Sugar()}
+func NewLoggerZapConfig(config *Config) (*LoggerZap, error) {
+	level := getLoggerLevel(config.Level)
+	encoder, err := getEncoder(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Path == "" {
+		core := zapcore.NewCore(encoder, zapcore.AddSync(os.Stdout), zapcore.DebugLevel)
+		return newLoggerZap(newLevelCore(core, level)), nil
+	}
+
+	fileName := config.FileName
+	if fileName == "" {
+		fileName = "default.log"
+	}
+	errorFileName := config.ErrorFileName
+	if errorFileName == "" {
+		errorFileName = "error.log"
+	}
+
+	var closers []io.Closer
+	var cores []zapcore.Core
+	infoWriter := getLogWriter(path.Join(config.Path, fileName), config)
+	closers = append(closers, infoWriter)
+	cores = append(cores, zapcore.NewCore(encoder, zapcore.AddSync(infoWriter), zapcore.DebugLevel))
+	if !config.NoErrorFile {
+		errorWriter := getLogWriter(path.Join(config.Path, errorFileName), config)
+		closers = append(closers, errorWriter)
+		cores = append(cores, zapcore.NewCore(encoder, zapcore.AddSync(errorWriter), zapcore.ErrorLevel))
+	}
+	if config.Stdout {
+		cores = append(cores, zapcore.NewCore(encoder, zapcore.AddSync(os.Stdout), zapcore.DebugLevel))
+	}
+
+	logger := newLoggerZap(newLevelCore(zapcore.NewTee(cores...), level))
+	logger.closers = closers
+	return logger, nil
 }
 
-func getEncoder() zapcore.Encoder {
+func getEncoder(config *Config) (zapcore.Encoder, error) {
 	encoderConfig := zap.NewProductionEncoderConfig()
 	encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
-	encoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
-	return zapcore.NewConsoleEncoder(encoderConfig)
+	if config.TimeFormat != "" {
+		layout := config.TimeFormat
+		encoderConfig.EncodeTime = func(t time.Time, enc zapcore.PrimitiveArrayEncoder) {
+			enc.AppendString(t.Format(layout))
+		}
+	}
+
+	switch config.Encoding {
+	case "", "console":
+		encoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
+		return zapcore.NewConsoleEncoder(encoderConfig), nil
+	case "json":
+		return zapcore.NewJSONEncoder(encoderConfig), nil
+	default:
+		return nil, errors.New("unknown log encoding: " + config.Encoding)
+	}
 }
 
-func getLogWriter(fileName string) zapcore.WriteSyncer {
-	lumberJackLogger := &lumberjack.Logger{
+func getLogWriter(fileName string, config *Config) *lumberjack.Logger {
+	maxSize := config.MaxSize
+	if maxSize <= 0 {
+		maxSize = 100
+	}
+	maxBackups := config.MaxBackups
+	if maxBackups == 0 {
+		maxBackups = 10
+	} else if maxBackups < 0 {
+		maxBackups = 0
+	}
+
+	return &lumberjack.Logger{
 		Filename:   fileName,
-		MaxSize:    100,
-		MaxBackups: 10,
-		Compress:   false,
+		MaxSize:    maxSize,
+		MaxAge:     config.MaxAge,
+		MaxBackups: maxBackups,
+		Compress:   config.Compress,
+	}
+}
+
+func (logger *LoggerZap) child(sugarLogger *zap.SugaredLogger) *LoggerZap {
+	return &LoggerZap{sugarLogger: sugarLogger, name: logger.name, levels: logger.levels}
+}
+
+func (logger *LoggerZap) withCallerSkip(skip int) Logger {
+	return logger.child(logger.sugarLogger.Desugar().WithOptions(zap.AddCallerSkip(skip)).Sugar())
+}
+
+// the level of the logger and its children
+func (logger *LoggerZap) SetLevel(strLevel string) error {
+	return logger.levels.set(strLevel)
+}
+
+// overrides the level of the descendants named name, see Logger.SetNamedLevel
+func (logger *LoggerZap) SetNamedLevel(name string, strLevel string) error {
+	return logger.levels.setNamed(name, strLevel)
+}
+
+func (logger *LoggerZap) With(keysAndValues ...interface{}) FieldLogger {
+	return logger.child(logger.sugarLogger.With(keysAndValues...))
+}
+
+func (logger *LoggerZap) Named(name string) FieldLogger {
+	c := logger.child(logger.sugarLogger.Named(name))
+	if c.name == "" {
+		c.name = name
+	} else {
+		c.name += "." + name
 	}
-	return zapcore.AddSync(lumberJackLogger)
+	// the core filters by the new name
+	c.sugarLogger = c.sugarLogger.Desugar().WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		if lc, ok := core.(*levelCore); ok {
+			return &levelCore{Core: lc.Core, name: c.name, levels: lc.levels}
+		}
+		return core
+	})).Sugar()
+	return c
 }
 
 func (logger *LoggerZap) Debugf(format string, a ...interface{}) {
-	logger.sugarLogger.Debugf(format,a...)
+	logger.sugarLogger.Debugf(format, a...)
 }
 
 func (logger *LoggerZap) Infof(format string, a ...interface{}) {
-	logger.sugarLogger.Infof(format,a...)
+	logger.sugarLogger.Infof(format, a...)
 }
 
 func (logger *LoggerZap) Warnf(format string, a ...interface{}) {
-	logger.sugarLogger.Warnf(format,a...)
+	logger.sugarLogger.Warnf(format, a...)
 }
 
 func (logger *LoggerZap) Errorf(format string, a ...interface{}) {
-	logger.sugarLogger.Errorf(format,a...)
+	logger.sugarLogger.Errorf(format, a...)
 }
 
-func (logger *LoggerZap) Panicf(format string,a ...interface{}) {
-	logger.sugarLogger.Panicf(format,a...)
+func (logger *LoggerZap) Panicf(format string, a ...interface{}) {
+	logger.sugarLogger.Panicf(format, a...)
 }
 
 func (logger *LoggerZap) Fatalf(format string, a ...interface{}) {
-	logger.sugarLogger.Fatalf(format,a...)
+	logger.sugarLogger.Fatalf(format, a...)
 }
 
 func (logger *LoggerZap) Debug(a ...interface{}) {
 	logger.sugarLogger.Debug(a...)
 }
 
-func (logger *LoggerZap) Info( a ...interface{}) {
+func (logger *LoggerZap) Info(a ...interface{}) {
 	logger.sugarLogger.Info(a...)
 }
 
-func (logger *LoggerZap) Warn( a ...interface{}) {
+func (logger *LoggerZap) Warn(a ...interface{}) {
 	logger.sugarLogger.Warn(a...)
 }
 
@@ -133,7 +282,34 @@ func (logger *LoggerZap) Fatal(a ...interface{}) {
 	logger.sugarLogger.Fatal(a...)
 }
 
+func (logger *LoggerZap) Debugw(msg string, keysAndValues ...interface{}) {
+	logger.sugarLogger.Debugw(msg, keysAndValues...)
+}
+
+func (logger *LoggerZap) Infow(msg string, keysAndValues ...interface{}) {
+	logger.sugarLogger.Infow(msg, keysAndValues...)
+}
+
+func (logger *LoggerZap) Warnw(msg string, keysAndValues ...interface{}) {
+	logger.sugarLogger.Warnw(msg, keysAndValues...)
+}
+
+func (logger *LoggerZap) Errorw(msg string, keysAndValues ...interface{}) {
+	logger.sugarLogger.Errorw(msg, keysAndValues...)
+}
+
+func (logger *LoggerZap) Panicw(msg string, keysAndValues ...interface{}) {
+	logger.sugarLogger.Panicw(msg, keysAndValues...)
+}
+
+func (logger *LoggerZap) Fatalw(msg string, keysAndValues ...interface{}) {
+	logger.sugarLogger.Fatalw(msg, keysAndValues...)
+}
+
+// closes the files of the root logger
 func (logger *LoggerZap) Close() {
 	logger.sugarLogger.Sync()
+	for _, c := range logger.closers {
+		c.Close()
+	}
 }
-
@@ -0,0 +1,261 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// reference: https://prometheus.io/docs/instrumenting/exposition_formats/
+//
+// metrics are created once, usually as package variables, and the values
+// are selected by their label values with With:
+//
+//	var msgCount = metrics.NewCounter("msg_total", "Messages.", "id")
+//	msgCount.With("Hello").Inc()
+type Registry struct {
+	mutex   sync.Mutex
+	metrics map[string]metric
+}
+
+type metric interface {
+	describe() *desc
+	// must goroutine safe
+	write(w *bufio.Writer)
+}
+
+type desc struct {
+	name       string
+	help       string
+	typ        string
+	labelNames []string
+}
+
+func (d *desc) describe() *desc {
+	return d
+}
+
+var DefaultRegistry = NewRegistry()
+
+func NewRegistry() *Registry {
+	r := new(Registry)
+	r.metrics = make(map[string]metric)
+	return r
+}
+
+var nameRegexp = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+func (r *Registry) register(m metric) {
+	d := m.describe()
+	if !nameRegexp.MatchString(d.name) {
+		panic(fmt.Sprintf("metric %v: invalid name", d.name))
+	}
+	for _, l := range d.labelNames {
+		if !nameRegexp.MatchString(l) || strings.Contains(l, ":") || l == "le" {
+			panic(fmt.Sprintf("metric %v: invalid label name %v", d.name, l))
+		}
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, ok := r.metrics[d.name]; ok {
+		panic(fmt.Sprintf("metric %v: already registered", d.name))
+	}
+	r.metrics[d.name] = m
+}
+
+// writes the metrics in the Prometheus text format
+// goroutine safe
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mutex.Lock()
+	ms := make([]metric, 0, len(r.metrics))
+	for _, m := range r.metrics {
+		ms = append(ms, m)
+	}
+	r.mutex.Unlock()
+	sort.Slice(ms, func(i, j int) bool {
+		return ms[i].describe().name < ms[j].describe().name
+	})
+
+	bw := bufio.NewWriter(w)
+	for _, m := range ms {
+		d := m.describe()
+		fmt.Fprintf(bw, "# HELP %v %v\n", d.name, helpEscaper.Replace(d.help))
+		fmt.Fprintf(bw, "# TYPE %v %v\n", d.name, d.typ)
+		m.write(bw)
+	}
+	return bw.Flush()
+}
+
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		r.WriteText(w)
+	})
+}
+
+// goroutine safe
+func WriteText(w io.Writer) error {
+	return DefaultRegistry.WriteText(w)
+}
+
+func Handler() http.Handler {
+	return DefaultRegistry.Handler()
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+)
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// name{label="value",...} value
+func writeSample(w *bufio.Writer, name string, labelNames []string, labelValues []string, extraName string, extraValue string, value float64) {
+	w.WriteString(name)
+	if len(labelNames) > 0 || extraName != "" {
+		w.WriteByte('{')
+		for i := range labelNames {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(labelNames[i])
+			w.WriteString(`="`)
+			w.WriteString(labelEscaper.Replace(labelValues[i]))
+			w.WriteByte('"')
+		}
+		if extraName != "" {
+			if len(labelNames) > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(extraName)
+			w.WriteString(`="`)
+			w.WriteString(extraValue)
+			w.WriteByte('"')
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+// a float64 updated atomically
+type value struct {
+	bits uint64
+}
+
+func (v *value) add(delta float64) {
+	for {
+		old := atomic.LoadUint64(&v.bits)
+		n := math.Float64bits(math.Float64frombits(old) + delta)
+		if atomic.CompareAndSwapUint64(&v.bits, old, n) {
+			return
+		}
+	}
+}
+
+func (v *value) set(f float64) {
+	atomic.StoreUint64(&v.bits, math.Float64bits(f))
+}
+
+func (v *value) get() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&v.bits))
+}
+
+// the values of a metric by label values
+type vec struct {
+	desc
+	mutex    sync.RWMutex
+	children map[string]*child
+}
+
+type child struct {
+	labelValues []string
+	value       interface{}
+}
+
+func newVec(name string, help string, typ string, labelNames []string) vec {
+	return vec{
+		desc: desc{
+			name:       name,
+			help:       help,
+			typ:        typ,
+			labelNames: append([]string(nil), labelNames...),
+		},
+		children: make(map[string]*child),
+	}
+}
+
+func (v *vec) with(labelValues []string, newValue func() interface{}) interface{} {
+	if len(labelValues) != len(v.labelNames) {
+		panic(fmt.Sprintf("metric %v: %v label values expected", v.name, len(v.labelNames)))
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	v.mutex.RLock()
+	c := v.children[key]
+	v.mutex.RUnlock()
+	if c != nil {
+		return c.value
+	}
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	c = v.children[key]
+	if c == nil {
+		c = &child{labelValues: append([]string(nil), labelValues...), value: newValue()}
+		v.children[key] = c
+	}
+	return c.value
+}
+
+func (v *vec) delete(labelValues []string) {
+	key := strings.Join(labelValues, "\xff")
+
+	v.mutex.Lock()
+	delete(v.children, key)
+	v.mutex.Unlock()
+}
+
+// sorted by label values
+func (v *vec) sorted() []*child {
+	v.mutex.RLock()
+	children := make([]*child, 0, len(v.children))
+	keys := make([]string, 0, len(v.children))
+	for key := range v.children {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		children = append(children, v.children[key])
+	}
+	v.mutex.RUnlock()
+	return children
+}
+
+// goroutines
+func init() {
+	NewGaugeFunc("leaf_goroutines", "Number of goroutines.", func(emit func(float64, ...string)) {
+		emit(float64(runtime.NumGoroutine()))
+	})
+}
@@ -0,0 +1,90 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func text(r *Registry) string {
+	var b bytes.Buffer
+	r.WriteText(&b)
+	return b.String()
+}
+
+func TestWriteText(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("test_total", "A counter.", "id")
+	c.With("b").Inc()
+	c.With("a").Add(2)
+	c.With("\"\\\n").Inc()
+	g := r.NewGauge("test_gauge", "A gauge\nwith two lines.")
+	g.With().Set(1.5)
+	r.NewGaugeFunc("test_func", "A gauge func.", func(emit func(float64, ...string)) {
+		emit(3, "x")
+	}, "name")
+
+	want := `# HELP test_func A gauge func.
+# TYPE test_func gauge
+test_func{name="x"} 3
+# HELP test_gauge A gauge\nwith two lines.
+# TYPE test_gauge gauge
+test_gauge 1.5
+# HELP test_total A counter.
+# TYPE test_total counter
+test_total{id="\"\\\n"} 1
+test_total{id="a"} 2
+test_total{id="b"} 1
+`
+	if s := text(r); s != want {
+		t.Fatalf("got\n%v", s)
+	}
+
+	c.Delete("a")
+	if strings.Contains(text(r), `id="a"`) {
+		t.Fatal("deleted value written")
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	r := NewRegistry()
+	h := r.NewHistogram("test_seconds", "A histogram.", []float64{1, 2})
+	h.With().Observe(0.5)
+	h.With().Observe(1)
+	h.With().Observe(1.5)
+	h.With().Observe(3)
+
+	want := `# HELP test_seconds A histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{le="1"} 2
+test_seconds_bucket{le="2"} 3
+test_seconds_bucket{le="+Inf"} 4
+test_seconds_sum 6
+test_seconds_count 4
+`
+	if s := text(r); s != want {
+		t.Fatalf("got\n%v", s)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("test_total", "A counter.")
+
+	for _, f := range []func(){
+		func() { r.NewGauge("test_total", "Duplicate.") },
+		func() { r.NewGauge("0test", "Invalid name.") },
+		func() { r.NewGauge("test_le", "Reserved label.", "le") },
+		func() { r.NewCounter("test_labels", "Label count.", "a").With() },
+		func() { r.NewCounter("test_negative", "Negative.").With().Add(-1) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatal("no panic")
+				}
+			}()
+			f()
+		}()
+	}
+}
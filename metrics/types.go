@@ -0,0 +1,243 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"sort"
+	"sync/atomic"
+)
+
+// Counter
+type Counter struct {
+	vec
+}
+
+// only goes up
+type CounterValue struct {
+	v value
+}
+
+func (r *Registry) NewCounter(name string, help string, labelNames ...string) *Counter {
+	c := &Counter{vec: newVec(name, help, "counter", labelNames)}
+	r.register(c)
+	if len(labelNames) == 0 {
+		c.With()
+	}
+	return c
+}
+
+func NewCounter(name string, help string, labelNames ...string) *Counter {
+	return DefaultRegistry.NewCounter(name, help, labelNames...)
+}
+
+// goroutine safe
+func (c *Counter) With(labelValues ...string) *CounterValue {
+	return c.with(labelValues, func() interface{} { return new(CounterValue) }).(*CounterValue)
+}
+
+// goroutine safe
+func (c *Counter) Delete(labelValues ...string) {
+	c.delete(labelValues)
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	for _, child := range c.sorted() {
+		writeSample(w, c.name, c.labelNames, child.labelValues, "", "", child.value.(*CounterValue).Get())
+	}
+}
+
+// goroutine safe
+func (v *CounterValue) Inc() {
+	v.v.add(1)
+}
+
+// goroutine safe
+func (v *CounterValue) Add(delta float64) {
+	if delta < 0 {
+		panic("counter cannot decrease")
+	}
+	v.v.add(delta)
+}
+
+// goroutine safe
+func (v *CounterValue) Get() float64 {
+	return v.v.get()
+}
+
+// Gauge
+type Gauge struct {
+	vec
+}
+
+type GaugeValue struct {
+	v value
+}
+
+func (r *Registry) NewGauge(name string, help string, labelNames ...string) *Gauge {
+	g := &Gauge{vec: newVec(name, help, "gauge", labelNames)}
+	r.register(g)
+	if len(labelNames) == 0 {
+		g.With()
+	}
+	return g
+}
+
+func NewGauge(name string, help string, labelNames ...string) *Gauge {
+	return DefaultRegistry.NewGauge(name, help, labelNames...)
+}
+
+// goroutine safe
+func (g *Gauge) With(labelValues ...string) *GaugeValue {
+	return g.with(labelValues, func() interface{} { return new(GaugeValue) }).(*GaugeValue)
+}
+
+// goroutine safe
+func (g *Gauge) Delete(labelValues ...string) {
+	g.delete(labelValues)
+}
+
+func (g *Gauge) write(w *bufio.Writer) {
+	for _, child := range g.sorted() {
+		writeSample(w, g.name, g.labelNames, child.labelValues, "", "", child.value.(*GaugeValue).Get())
+	}
+}
+
+// goroutine safe
+func (v *GaugeValue) Set(f float64) {
+	v.v.set(f)
+}
+
+// goroutine safe
+func (v *GaugeValue) Add(delta float64) {
+	v.v.add(delta)
+}
+
+// goroutine safe
+func (v *GaugeValue) Inc() {
+	v.v.add(1)
+}
+
+// goroutine safe
+func (v *GaugeValue) Dec() {
+	v.v.add(-1)
+}
+
+// goroutine safe
+func (v *GaugeValue) Get() float64 {
+	return v.v.get()
+}
+
+// GaugeFunc
+// the values are collected when the metrics are written
+type GaugeFunc struct {
+	desc
+	collect func(emit func(value float64, labelValues ...string))
+}
+
+// collect must goroutine safe, it calls emit once per label values
+func (r *Registry) NewGaugeFunc(name string, help string, collect func(emit func(value float64, labelValues ...string)), labelNames ...string) *GaugeFunc {
+	g := &GaugeFunc{
+		desc: desc{
+			name:       name,
+			help:       help,
+			typ:        "gauge",
+			labelNames: append([]string(nil), labelNames...),
+		},
+		collect: collect,
+	}
+	r.register(g)
+	return g
+}
+
+func NewGaugeFunc(name string, help string, collect func(emit func(value float64, labelValues ...string)), labelNames ...string) *GaugeFunc {
+	return DefaultRegistry.NewGaugeFunc(name, help, collect, labelNames...)
+}
+
+func (g *GaugeFunc) write(w *bufio.Writer) {
+	g.collect(func(value float64, labelValues ...string) {
+		if len(labelValues) != len(g.labelNames) {
+			panic(fmt.Sprintf("metric %v: %v label values expected", g.name, len(g.labelNames)))
+		}
+		writeSample(w, g.name, g.labelNames, labelValues, "", "", value)
+	})
+}
+
+// Histogram
+type Histogram struct {
+	vec
+	buckets []float64
+}
+
+// the observations are counted in buckets by upper bound
+type HistogramValue struct {
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     value
+}
+
+// latencies in seconds, from 5ms to 10s
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// buckets are the upper bounds, DefBuckets if nil, +Inf is implicit
+func (r *Registry) NewHistogram(name string, help string, buckets []float64, labelNames ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	if !sort.Float64sAreSorted(buckets) {
+		panic(fmt.Sprintf("metric %v: buckets must be sorted", name))
+	}
+
+	h := &Histogram{vec: newVec(name, help, "histogram", labelNames), buckets: buckets}
+	r.register(h)
+	if len(labelNames) == 0 {
+		h.With()
+	}
+	return h
+}
+
+func NewHistogram(name string, help string, buckets []float64, labelNames ...string) *Histogram {
+	return DefaultRegistry.NewHistogram(name, help, buckets, labelNames...)
+}
+
+// goroutine safe
+func (h *Histogram) With(labelValues ...string) *HistogramValue {
+	return h.with(labelValues, func() interface{} {
+		return &HistogramValue{buckets: h.buckets, counts: make([]uint64, len(h.buckets))}
+	}).(*HistogramValue)
+}
+
+// goroutine safe
+func (h *Histogram) Delete(labelValues ...string) {
+	h.delete(labelValues)
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	for _, child := range h.sorted() {
+		v := child.value.(*HistogramValue)
+
+		// the buckets are cumulative
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += atomic.LoadUint64(&v.counts[i])
+			writeSample(w, h.name+"_bucket", h.labelNames, child.labelValues, "le", formatFloat(upper), float64(cumulative))
+		}
+		count := atomic.LoadUint64(&v.count)
+		if count < cumulative {
+			count = cumulative
+		}
+		writeSample(w, h.name+"_bucket", h.labelNames, child.labelValues, "le", "+Inf", float64(count))
+		writeSample(w, h.name+"_sum", h.labelNames, child.labelValues, "", "", v.sum.get())
+		writeSample(w, h.name+"_count", h.labelNames, child.labelValues, "", "", float64(count))
+	}
+}
+
+// goroutine safe
+func (v *HistogramValue) Observe(f float64) {
+	if i := sort.SearchFloat64s(v.buckets, f); i < len(v.buckets) {
+		atomic.AddUint64(&v.counts[i], 1)
+	}
+	v.sum.add(f)
+	atomic.AddUint64(&v.count, 1)
+}
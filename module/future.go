@@ -0,0 +1,71 @@
+package module
+
+import (
+	"context"
+	"github.com/name5566/leaf/chanrpc"
+	"github.com/name5566/leaf/future"
+	"time"
+)
+
+// a future resolved by the module, its callbacks and WithTimeout run on the
+// goroutine of the module
+func (s *Skeleton) NewFuture() *future.Future {
+	return future.New(s)
+}
+
+// the future of AsynCall to a function with no return value, resolved with
+// nil
+func (s *Skeleton) AsynCall0Future(server *chanrpc.Server, id interface{}, args ...interface{}) *future.Future {
+	f := future.New(s)
+	s.asynCallFuture(server, id, args, func(err error) {
+		f.Resolve(nil, err)
+	})
+	return f
+}
+
+// the future of AsynCall to a function with one return value, resolved with
+// the return value
+func (s *Skeleton) AsynCallFuture(server *chanrpc.Server, id interface{}, args ...interface{}) *future.Future {
+	f := future.New(s)
+	s.asynCallFuture(server, id, args, func(ret interface{}, err error) {
+		f.Resolve(ret, err)
+	})
+	return f
+}
+
+// the future of AsynCall to a function with multiple return values,
+// resolved with the []interface{} of the return values
+func (s *Skeleton) AsynCallNFuture(server *chanrpc.Server, id interface{}, args ...interface{}) *future.Future {
+	f := future.New(s)
+	s.asynCallFuture(server, id, args, func(ret []interface{}, err error) {
+		f.Resolve(ret, err)
+	})
+	return f
+}
+
+// args is copied, the callback must not be appended to the array of the
+// caller
+func (s *Skeleton) asynCallFuture(server *chanrpc.Server, id interface{}, args []interface{}, cb interface{}) {
+	callArgs := make([]interface{}, len(args)+1)
+	copy(callArgs, args)
+	callArgs[len(args)] = cb
+	s.AsynCall(server, id, callArgs...)
+}
+
+// the future of GoContext, canceling the future cancels the task
+func (s *Skeleton) GoFuture(fn func(ctx context.Context) (interface{}, error)) *future.Future {
+	f := future.New(s)
+	task := s.GoContext(fn, f.Resolve)
+	f.OnCancel(task.Cancel)
+	return f
+}
+
+// resolved with nil after d
+func (s *Skeleton) AfterFuture(d time.Duration) *future.Future {
+	f := future.New(s)
+	t := s.AfterFunc(d, func() {
+		f.Resolve(nil, nil)
+	})
+	f.OnCancel(t.Stop)
+	return f
+}
@@ -0,0 +1,80 @@
+package module
+
+import (
+	"fmt"
+	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/metrics"
+	"path"
+	"reflect"
+	"strings"
+)
+
+// implemented by the modules embedding a Skeleton
+type instrumented interface {
+	setName(name string, logName string)
+	pending() (goPending int, timerPending int)
+}
+
+// the logger is named logName
+func (s *Skeleton) setName(name string, logName string) {
+	if s.server == nil {
+		return
+	}
+	s.server.SetName(name)
+	s.commandServer.SetName(name + ":command")
+
+	named := log.Named(logName)
+	s.logger = log.WithFunc(named, s.spanFields)
+	s.server.SetLogger(s.logger)
+	s.commandServer.SetLogger(s.logger)
+	s.client.SetLogger(s.logger)
+	// f of Go runs on other goroutines, which must not read the span
+	s.g.SetLogger(named)
+	s.dispatcher.SetLogger(s.logger)
+}
+
+// goroutine safe
+func (s *Skeleton) pending() (int, int) {
+	if s.g == nil {
+		return 0, 0
+	}
+	return s.g.Pending(), s.dispatcher.Pending()
+}
+
+func moduleName(mi Module) string {
+	return strings.TrimPrefix(fmt.Sprintf("%T", mi), "*")
+}
+
+// the last element of the package path of the module, but internal, e.g.
+// "game" for server/game/internal.Module
+func logName(mi Module) string {
+	t := reflect.TypeOf(mi)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	p := strings.TrimSuffix(t.PkgPath(), "/internal")
+	if p == "" {
+		return moduleName(mi)
+	}
+	return path.Base(p)
+}
+
+// the modules are registered before the metrics are collected
+func init() {
+	metrics.NewGaugeFunc("leaf_go_pending", "Go calls whose callback is not called yet.", func(emit func(float64, ...string)) {
+		for _, m := range mods {
+			if i, ok := m.mi.(instrumented); ok {
+				goPending, _ := i.pending()
+				emit(float64(goPending), moduleName(m.mi))
+			}
+		}
+	}, "module")
+	metrics.NewGaugeFunc("leaf_timer_pending", "Timers not stopped whose callback is not called yet.", func(emit func(float64, ...string)) {
+		for _, m := range mods {
+			if i, ok := m.mi.(instrumented); ok {
+				_, timerPending := i.pending()
+				emit(float64(timerPending), moduleName(m.mi))
+			}
+		}
+	}, "module")
+}
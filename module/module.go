@@ -32,6 +32,9 @@ func Register(mi Module) {
 func Init() {
 	for i := 0; i < len(mods); i++ {
 		mods[i].mi.OnInit()
+		if inst, ok := mods[i].mi.(instrumented); ok {
+			inst.setName(moduleName(mods[i].mi), logName(mods[i].mi))
+		}
 	}
 
 	for i := 0; i < len(mods); i++ {
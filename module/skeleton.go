@@ -1,23 +1,54 @@
 package module
 
 import (
+	"context"
 	"github.com/name5566/leaf/chanrpc"
+	"github.com/name5566/leaf/conf"
 	"github.com/name5566/leaf/console"
 	"github.com/name5566/leaf/go"
+	"github.com/name5566/leaf/log"
 	"github.com/name5566/leaf/timer"
+	"github.com/name5566/leaf/trace"
+	"strings"
+	"sync"
 	"time"
 )
 
+var (
+	defaultJobStore     timer.JobStore
+	onceDefaultJobStore sync.Once
+)
+
 type Skeleton struct {
-	GoLen              int
+	GoLen int
+	// at most GoMaxWorkers calls of Go run at once if positive, the calls
+	// beyond wait in a queue of GoQueueLen, see g.NewPool
+	GoMaxWorkers       int
+	GoQueueLen         int
+	GoRejectPolicy     g.RejectPolicy
 	TimerDispatcherLen int
-	AsynCallLen        int
-	ChanRPCServer      *chanrpc.Server
-	g                  *g.Go
-	dispatcher         *timer.Dispatcher
-	client             *chanrpc.Client
-	server             *chanrpc.Server
-	commandServer      *chanrpc.Server
+	// the timers share a timing wheel of this tick if positive, see
+	// timer.NewWheelDispatcher
+	TimerWheelTick time.Duration
+	// the clock of the timers, timer.RealClock if nil
+	Clock timer.Clock
+	// the last runs of the jobs, a timer.FileJobStore of conf.JobFile, shared
+	// by the skeletons, if nil
+	JobStore      timer.JobStore
+	AsynCallLen   int
+	ChanRPCServer *chanrpc.Server
+	g             *g.Go
+	dispatcher    *timer.Dispatcher
+	jobs          *timer.Scheduler
+	// the jobs command reaches the module once it has a job
+	jobsRegistered bool
+	client         *chanrpc.Client
+	server         *chanrpc.Server
+	commandServer  *chanrpc.Server
+	// the span of the callback of Go executed
+	span trace.SpanContext
+	// nil until named by Init of the package
+	logger log.FieldLogger
 }
 
 func (s *Skeleton) Init() {
@@ -31,8 +62,27 @@ func (s *Skeleton) Init() {
 		s.AsynCallLen = 0
 	}
 
-	s.g = g.New(s.GoLen)
-	s.dispatcher = timer.NewDispatcher(s.TimerDispatcherLen)
+	if s.Clock == nil {
+		s.Clock = timer.RealClock
+	}
+	if s.JobStore == nil {
+		onceDefaultJobStore.Do(func() {
+			defaultJobStore = timer.NewFileJobStore(conf.JobFile)
+		})
+		s.JobStore = defaultJobStore
+	}
+
+	if s.GoMaxWorkers > 0 {
+		s.g = g.NewPool(s.GoLen, s.GoMaxWorkers, s.GoQueueLen, s.GoRejectPolicy)
+	} else {
+		s.g = g.New(s.GoLen)
+	}
+	if s.TimerWheelTick > 0 {
+		s.dispatcher = timer.NewWheelDispatcherWithClock(s.TimerDispatcherLen, s.TimerWheelTick, s.Clock)
+	} else {
+		s.dispatcher = timer.NewDispatcherWithClock(s.TimerDispatcherLen, s.Clock)
+	}
+	s.jobs = timer.NewScheduler(s.dispatcher, s.JobStore)
 	s.client = chanrpc.NewClient(s.AsynCallLen)
 	s.server = s.ChanRPCServer
 
@@ -40,18 +90,24 @@ func (s *Skeleton) Init() {
 		s.server = chanrpc.NewServer(0)
 	}
 	s.commandServer = chanrpc.NewServer(0)
+	s.commandServer.Register("jobs.list", s.listJobs)
+	s.commandServer.Register("jobs.run", s.runJob)
 }
 
 func (s *Skeleton) Run(closeSig chan bool) {
 	for {
 		select {
 		case <-closeSig:
+			if s.jobsRegistered {
+				console.UnregisterJobs(s.commandServer)
+			}
 			s.commandServer.Close()
 			s.server.Close()
 			for !s.g.Idle() || !s.client.Idle() {
 				s.g.Close()
 				s.client.Close()
 			}
+			s.dispatcher.Close()
 			return
 		case ri := <-s.client.ChanAsynRet:
 			s.client.Cb(ri)
@@ -67,6 +123,31 @@ func (s *Skeleton) Run(closeSig chan bool) {
 	}
 }
 
+// the logger of the module, named after the package of the module, e.g.
+// "game" for server/game/internal.Module, which logs the errors of the calls
+// and the panics of the callbacks, the level of a module is changed by the
+// loglevel command of the console
+// the messages carry the trace_id and span_id of Span, the logger must be
+// used from the goroutine of the module, f of Go uses log.Named or the
+// fields of trace.Fields instead
+// the logger is not named until the module is initialized
+func (s *Skeleton) Logger() log.FieldLogger {
+	if s.logger == nil {
+		return log.WithFunc(log.With(), s.spanFields)
+	}
+	return s.logger
+}
+
+func (s *Skeleton) spanFields() []interface{} {
+	return trace.Fields(s.Span())
+}
+
+// the time of the timers, game logic comparing times, as cooldowns, should
+// use it rather than time.Now
+func (s *Skeleton) Now() time.Time {
+	return s.Clock.Now()
+}
+
 func (s *Skeleton) AfterFunc(d time.Duration, cb func()) *timer.Timer {
 	if s.TimerDispatcherLen == 0 {
 		panic("invalid TimerDispatcherLen")
@@ -75,6 +156,14 @@ func (s *Skeleton) AfterFunc(d time.Duration, cb func()) *timer.Timer {
 	return s.dispatcher.AfterFunc(d, cb)
 }
 
+func (s *Skeleton) TickFunc(d time.Duration, mode timer.TickMode, cb func()) *timer.Timer {
+	if s.TimerDispatcherLen == 0 {
+		panic("invalid TimerDispatcherLen")
+	}
+
+	return s.dispatcher.TickFunc(d, mode, cb)
+}
+
 func (s *Skeleton) CronFunc(cronExpr *timer.CronExpr, cb func()) *timer.Cron {
 	if s.TimerDispatcherLen == 0 {
 		panic("invalid TimerDispatcherLen")
@@ -83,14 +172,93 @@ func (s *Skeleton) CronFunc(cronExpr *timer.CronExpr, cb func()) *timer.Cron {
 	return s.dispatcher.CronFunc(cronExpr, cb)
 }
 
+// a cron job named name, unique in the process, whose runs are kept in
+// JobStore, the runs missed while the process was down are caught up
+// according to catchUp, see the jobs command of the console
+func (s *Skeleton) CronJob(name string, cronExpr *timer.CronExpr, catchUp timer.CatchUp, cb func()) (*timer.Job, error) {
+	if s.TimerDispatcherLen == 0 {
+		panic("invalid TimerDispatcherLen")
+	}
+
+	if !s.jobsRegistered {
+		s.jobsRegistered = true
+		console.RegisterJobs(s.commandServer)
+	}
+	return s.jobs.Add(name, cronExpr, catchUp, cb)
+}
+
+func (s *Skeleton) listJobs(args []interface{}) interface{} {
+	var jobs []string
+	for _, j := range s.jobs.Jobs() {
+		jobs = append(jobs, j.String())
+	}
+	return strings.Join(jobs, "\r\n")
+}
+
+func (s *Skeleton) runJob(args []interface{}) interface{} {
+	return s.jobs.Run(args[0].(string)) == nil
+}
+
+// the span of the call, or of the callback of AsynCall, Go or GoContext,
+// executed by the module, zero if none, f of Go runs on another goroutine
+// and must be given the span explicitly
+// goroutine not safe, the goroutine of the module
+func (s *Skeleton) Span() trace.SpanContext {
+	if s.server == nil {
+		return trace.SpanContext{}
+	}
+	if sc := s.server.Span(); sc.IsValid() {
+		return sc
+	}
+	if sc := s.client.Span(); sc.IsValid() {
+		return sc
+	}
+	return s.span
+}
+
+// sc is the span of the callback executed until the function returned is
+// called
+func (s *Skeleton) inSpan(sc trace.SpanContext) func() {
+	prev := s.span
+	s.span = sc
+	return func() {
+		s.span = prev
+	}
+}
+
+// cb runs in the span of the caller, see Span
 func (s *Skeleton) Go(f func(), cb func()) {
 	if s.GoLen == 0 {
 		panic("invalid GoLen")
 	}
 
+	if sc := s.Span(); sc.IsValid() && cb != nil {
+		done := cb
+		cb = func() {
+			defer s.inSpan(sc)()
+			done()
+		}
+	}
 	s.g.Go(f, cb)
 }
 
+// f is cancelled when the module is closed or by the task, see g.GoContext,
+// cb runs in the span of the caller, see Span
+func (s *Skeleton) GoContext(f func(ctx context.Context) (interface{}, error), cb func(ret interface{}, err error)) *g.Task {
+	if s.GoLen == 0 {
+		panic("invalid GoLen")
+	}
+
+	if sc := s.Span(); sc.IsValid() && cb != nil {
+		done := cb
+		cb = func(ret interface{}, err error) {
+			defer s.inSpan(sc)()
+			done(ret, err)
+		}
+	}
+	return s.g.GoContext(f, cb)
+}
+
 func (s *Skeleton) NewLinearContext() *g.LinearContext {
 	if s.GoLen == 0 {
 		panic("invalid GoLen")
@@ -99,12 +267,22 @@ func (s *Skeleton) NewLinearContext() *g.LinearContext {
 	return s.g.NewLinearContext()
 }
 
+// the calls of Go of a key, as a player ID, run in order
+func (s *Skeleton) NewKeyedContext() *g.KeyedContext {
+	if s.GoLen == 0 {
+		panic("invalid GoLen")
+	}
+
+	return s.g.NewKeyedContext()
+}
+
 func (s *Skeleton) AsynCall(server *chanrpc.Server, id interface{}, args ...interface{}) {
 	if s.AsynCallLen == 0 {
 		panic("invalid AsynCallLen")
 	}
 
 	s.client.Attach(server)
+	s.client.SetSpan(s.Span())
 	s.client.AsynCall(id, args...)
 }
 
@@ -0,0 +1,57 @@
+package network
+
+import (
+	"sync"
+)
+
+// buffers are pooled in power of two size classes from 64 bytes to 64 KB,
+// larger buffers are allocated and released to the GC as usual
+const (
+	minPoolBufShift = 6
+	maxPoolBufShift = 16
+)
+
+// the pools keep *[]byte, a slice put in a sync.Pool as such is allocated,
+// the empty *[]byte are pooled in turn for the next PutBuffer
+var (
+	bufPools   [maxPoolBufShift - minPoolBufShift + 1]sync.Pool
+	bufHolders = sync.Pool{New: func() interface{} { return new([]byte) }}
+)
+
+func bufPoolIndex(size int) int {
+	i := 0
+	for c := 1 << minPoolBufShift; c < size; c <<= 1 {
+		i++
+	}
+	return i
+}
+
+// goroutine safe
+// the returned buffer has length n and unspecified content
+func GetBuffer(n int) []byte {
+	if n > 1<<maxPoolBufShift {
+		return make([]byte, n)
+	}
+
+	i := bufPoolIndex(n)
+	if h, ok := bufPools[i].Get().(*[]byte); ok {
+		b := *h
+		*h = nil
+		bufHolders.Put(h)
+		return b[:n]
+	}
+	return make([]byte, n, 1<<uint(i+minPoolBufShift))
+}
+
+// goroutine safe
+// b must not be used by the caller after the call
+func PutBuffer(b []byte) {
+	c := cap(b)
+	if c < 1<<minPoolBufShift || c > 1<<maxPoolBufShift || c&(c-1) != 0 {
+		return
+	}
+
+	h := bufHolders.Get().(*[]byte)
+	*h = b[:0]
+	bufPools[bufPoolIndex(c)].Put(h)
+}
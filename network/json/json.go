@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"github.com/name5566/leaf/chanrpc"
 	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/trace"
 	"reflect"
 )
 
@@ -95,6 +96,12 @@ func (p *Processor) SetRawHandler(msgID string, msgRawHandler MsgHandler) {
 
 // goroutine safe
 func (p *Processor) Route(msg interface{}, userData interface{}) error {
+	return p.RouteSpan(trace.SpanContext{}, msg, userData)
+}
+
+// the call of the router is a child of sc, see network.SpanRouter
+// goroutine safe
+func (p *Processor) RouteSpan(sc trace.SpanContext, msg interface{}, userData interface{}) error {
 	// raw
 	if msgRaw, ok := msg.(MsgRaw); ok {
 		i, ok := p.msgInfo[msgRaw.msgID]
@@ -121,7 +128,7 @@ func (p *Processor) Route(msg interface{}, userData interface{}) error {
 		i.msgHandler([]interface{}{msg, userData})
 	}
 	if i.msgRouter != nil {
-		i.msgRouter.Go(msgType, msg, userData)
+		i.msgRouter.GoFrom(sc, msgType, msg, userData)
 	}
 	return nil
 }
@@ -155,6 +162,18 @@ func (p *Processor) Unmarshal(data []byte) (interface{}, error) {
 	panic("bug")
 }
 
+// goroutine safe
+// raw messages are copied by encoding/json
+func (p *Processor) ReleasesData() bool {
+	return true
+}
+
+// goroutine safe
+// encoding/json returns UTF-8 text
+func (p *Processor) TextFrames() bool {
+	return true
+}
+
 // goroutine safe
 func (p *Processor) Marshal(msg interface{}) ([][]byte, error) {
 	msgType := reflect.TypeOf(msg)
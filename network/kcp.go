@@ -0,0 +1,509 @@
+package network
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// reference: https://github.com/skywind3000/kcp
+//
+// A KCP-style ARQ tuned for low latency (no congestion window, fast
+// retransmit after 2 skipped acks, 1.5x RTO backoff). Messages are split
+// into fragments and delivered reliably and in order, the fragments of a
+// message must fit in the receive window, see kcpMaxMsgLen.
+//
+// -----------------------------------------------------------
+// | conv | cmd | frg | wnd | ts | sn | una | len | data ... |
+// -----------------------------------------------------------
+// little endian, cmd, frg are 1 byte, wnd 2 bytes, the others 4 bytes
+const (
+	kcpCmdPush  = 81
+	kcpCmdAck   = 82
+	kcpCmdPing  = 83
+	kcpCmdClose = 85
+
+	// fits in the ethernet MTU
+	kcpMTU = 1400
+
+	kcpOverhead   = 24
+	kcpWndSnd     = 128
+	kcpWndRcv     = 128
+	kcpRTOMin     = 30
+	kcpRTODef     = 200
+	kcpRTOMax     = 60000
+	kcpFastResend = 2
+	kcpDeadLink   = 20
+
+	// the longest message, in fragments of the MTU
+	kcpMaxMsgLen = (kcpMTU - kcpOverhead) * (kcpWndRcv - 1)
+)
+
+type kcpSegment struct {
+	conv     uint32
+	cmd      uint8
+	frg      uint8
+	wnd      uint16
+	ts       uint32
+	sn       uint32
+	una      uint32
+	data     []byte
+	resendts uint32
+	rto      uint32
+	fastack  uint32
+	xmit     uint32
+}
+
+func (seg *kcpSegment) encode(b []byte) []byte {
+	binary.LittleEndian.PutUint32(b, seg.conv)
+	b[4] = seg.cmd
+	b[5] = seg.frg
+	binary.LittleEndian.PutUint16(b[6:], seg.wnd)
+	binary.LittleEndian.PutUint32(b[8:], seg.ts)
+	binary.LittleEndian.PutUint32(b[12:], seg.sn)
+	binary.LittleEndian.PutUint32(b[16:], seg.una)
+	binary.LittleEndian.PutUint32(b[20:], uint32(len(seg.data)))
+	return b[kcpOverhead:]
+}
+
+// a session is only opened by a well-formed datagram of a new conversation,
+// a conv other than 0, pings and the pushes of the first window, the peer
+// having received nothing
+func kcpFirstDatagram(data []byte) (conv uint32, ok bool) {
+	conv = binary.LittleEndian.Uint32(data)
+	if conv == 0 {
+		return 0, false
+	}
+
+	for len(data) > 0 {
+		if len(data) < kcpOverhead || binary.LittleEndian.Uint32(data) != conv {
+			return 0, false
+		}
+		cmd := data[4]
+		frg := data[5]
+		sn := binary.LittleEndian.Uint32(data[12:])
+		una := binary.LittleEndian.Uint32(data[16:])
+		length := binary.LittleEndian.Uint32(data[20:])
+		data = data[kcpOverhead:]
+
+		if una != 0 || length > uint32(len(data)) || length > kcpMTU-kcpOverhead {
+			return 0, false
+		}
+		switch cmd {
+		case kcpCmdPush:
+			if sn >= kcpWndRcv || frg >= kcpWndRcv {
+				return 0, false
+			}
+		case kcpCmdPing:
+			if length != 0 {
+				return 0, false
+			}
+		default:
+			return 0, false
+		}
+		data = data[length:]
+	}
+	return conv, true
+}
+
+func timediff(later uint32, earlier uint32) int32 {
+	return int32(later - earlier)
+}
+
+// goroutine not safe
+type kcp struct {
+	conv    uint32
+	mtu     int
+	mss     int
+	current uint32
+
+	sndUna uint32
+	sndNxt uint32
+	rcvNxt uint32
+	sndWnd uint32
+	rcvWnd uint32
+	rmtWnd uint32
+
+	rxSrtt   int32
+	rxRttvar int32
+	rxRto    int32
+	interval int32
+
+	sndQueue []*kcpSegment
+	sndBuf   []*kcpSegment
+	rcvQueue []*kcpSegment
+	rcvBuf   []*kcpSegment
+	acklist  []uint32
+
+	// too many retransmissions
+	dead bool
+	// the peer closed the session
+	closed bool
+
+	buffer []byte
+	output func([]byte)
+}
+
+func newKCP(conv uint32, mtu int, interval int32, output func([]byte)) *kcp {
+	k := new(kcp)
+	k.conv = conv
+	k.mtu = mtu
+	k.mss = mtu - kcpOverhead
+	k.sndWnd = kcpWndSnd
+	k.rcvWnd = kcpWndRcv
+	k.rmtWnd = kcpWndRcv
+	k.rxRto = kcpRTODef
+	k.interval = interval
+	k.buffer = make([]byte, 0, mtu)
+	k.output = output
+	return k
+}
+
+// segments not acknowledged yet
+func (k *kcp) waitSnd() int {
+	return len(k.sndBuf) + len(k.sndQueue)
+}
+
+// drops the oldest message of the queue not sent yet, if any
+func (k *kcp) dropOldest() {
+	for i, seg := range k.sndQueue {
+		if seg.frg == 0 {
+			k.sndQueue = append(k.sndQueue[:0], k.sndQueue[i+1:]...)
+			return
+		}
+	}
+}
+
+func (k *kcp) send(msg []byte) error {
+	count := (len(msg) + k.mss - 1) / k.mss
+	if count == 0 {
+		count = 1
+	}
+	// never received in full otherwise
+	if count >= kcpWndRcv {
+		return errors.New("message too long")
+	}
+
+	for i := 0; i < count; i++ {
+		size := len(msg)
+		if size > k.mss {
+			size = k.mss
+		}
+		seg := new(kcpSegment)
+		seg.data = append([]byte(nil), msg[:size]...)
+		seg.frg = uint8(count - i - 1)
+		k.sndQueue = append(k.sndQueue, seg)
+		msg = msg[size:]
+	}
+	return nil
+}
+
+// a complete message if any
+func (k *kcp) recv() ([]byte, bool) {
+	n := 0
+	size := 0
+	for _, seg := range k.rcvQueue {
+		n++
+		size += len(seg.data)
+		if seg.frg == 0 {
+			break
+		}
+	}
+	if n == 0 || k.rcvQueue[n-1].frg != 0 {
+		return nil, false
+	}
+
+	msg := make([]byte, 0, size)
+	for _, seg := range k.rcvQueue[:n] {
+		msg = append(msg, seg.data...)
+	}
+	k.rcvQueue = k.rcvQueue[n:]
+	k.moveRcvBuf()
+
+	return msg, true
+}
+
+func (k *kcp) moveRcvBuf() {
+	for len(k.rcvBuf) > 0 {
+		seg := k.rcvBuf[0]
+		if seg.sn != k.rcvNxt || uint32(len(k.rcvQueue)) >= k.rcvWnd {
+			break
+		}
+		k.rcvQueue = append(k.rcvQueue, seg)
+		k.rcvBuf = k.rcvBuf[1:]
+		k.rcvNxt++
+	}
+}
+
+func (k *kcp) updateAck(rtt int32) {
+	if k.rxSrtt == 0 {
+		k.rxSrtt = rtt
+		k.rxRttvar = rtt / 2
+	} else {
+		delta := rtt - k.rxSrtt
+		if delta < 0 {
+			delta = -delta
+		}
+		k.rxRttvar = (3*k.rxRttvar + delta) / 4
+		k.rxSrtt = (7*k.rxSrtt + rtt) / 8
+		if k.rxSrtt < 1 {
+			k.rxSrtt = 1
+		}
+	}
+
+	rto := 4 * k.rxRttvar
+	if rto < k.interval {
+		rto = k.interval
+	}
+	rto += k.rxSrtt
+	if rto < kcpRTOMin {
+		rto = kcpRTOMin
+	} else if rto > kcpRTOMax {
+		rto = kcpRTOMax
+	}
+	k.rxRto = rto
+}
+
+func (k *kcp) shrinkBuf() {
+	if len(k.sndBuf) > 0 {
+		k.sndUna = k.sndBuf[0].sn
+	} else {
+		k.sndUna = k.sndNxt
+	}
+}
+
+func (k *kcp) parseAck(sn uint32) {
+	if timediff(sn, k.sndUna) < 0 || timediff(sn, k.sndNxt) >= 0 {
+		return
+	}
+	for i, seg := range k.sndBuf {
+		if seg.sn == sn {
+			k.sndBuf = append(k.sndBuf[:i], k.sndBuf[i+1:]...)
+			break
+		}
+		if timediff(sn, seg.sn) < 0 {
+			break
+		}
+	}
+}
+
+func (k *kcp) parseUna(una uint32) {
+	i := 0
+	for i < len(k.sndBuf) && timediff(una, k.sndBuf[i].sn) > 0 {
+		i++
+	}
+	k.sndBuf = k.sndBuf[i:]
+}
+
+func (k *kcp) parseFastack(sn uint32) {
+	if timediff(sn, k.sndUna) < 0 || timediff(sn, k.sndNxt) >= 0 {
+		return
+	}
+	for _, seg := range k.sndBuf {
+		if timediff(sn, seg.sn) < 0 {
+			break
+		} else if sn != seg.sn {
+			seg.fastack++
+		}
+	}
+}
+
+func (k *kcp) parseData(newSeg *kcpSegment) {
+	sn := newSeg.sn
+	if timediff(sn, k.rcvNxt+k.rcvWnd) >= 0 || timediff(sn, k.rcvNxt) < 0 {
+		return
+	}
+
+	// insert ordered, ignore duplicates
+	i := len(k.rcvBuf)
+	for i > 0 {
+		seg := k.rcvBuf[i-1]
+		if seg.sn == sn {
+			return
+		}
+		if timediff(sn, seg.sn) > 0 {
+			break
+		}
+		i--
+	}
+	k.rcvBuf = append(k.rcvBuf, nil)
+	copy(k.rcvBuf[i+1:], k.rcvBuf[i:])
+	k.rcvBuf[i] = newSeg
+
+	k.moveRcvBuf()
+}
+
+func (k *kcp) input(data []byte) error {
+	var maxack uint32
+	ackFlag := false
+
+	for len(data) >= kcpOverhead {
+		conv := binary.LittleEndian.Uint32(data)
+		cmd := data[4]
+		frg := data[5]
+		wnd := binary.LittleEndian.Uint16(data[6:])
+		ts := binary.LittleEndian.Uint32(data[8:])
+		sn := binary.LittleEndian.Uint32(data[12:])
+		una := binary.LittleEndian.Uint32(data[16:])
+		length := binary.LittleEndian.Uint32(data[20:])
+		data = data[kcpOverhead:]
+
+		if conv != k.conv {
+			return errors.New("kcp conv mismatch")
+		}
+		if uint32(len(data)) < length {
+			return errors.New("kcp segment truncated")
+		}
+
+		k.rmtWnd = uint32(wnd)
+		k.parseUna(una)
+		k.shrinkBuf()
+
+		switch cmd {
+		case kcpCmdAck:
+			if rtt := timediff(k.current, ts); rtt >= 0 {
+				k.updateAck(rtt)
+			}
+			k.parseAck(sn)
+			k.shrinkBuf()
+			if !ackFlag || timediff(sn, maxack) > 0 {
+				ackFlag = true
+				maxack = sn
+			}
+		case kcpCmdPush:
+			if timediff(sn, k.rcvNxt+k.rcvWnd) < 0 {
+				k.acklist = append(k.acklist, sn, ts)
+				if timediff(sn, k.rcvNxt) >= 0 {
+					seg := new(kcpSegment)
+					seg.frg = frg
+					seg.sn = sn
+					seg.data = append([]byte(nil), data[:length]...)
+					k.parseData(seg)
+				}
+			}
+		case kcpCmdPing:
+		case kcpCmdClose:
+			k.closed = true
+		default:
+			return errors.New("kcp invalid command")
+		}
+
+		data = data[length:]
+	}
+
+	if ackFlag {
+		k.parseFastack(maxack)
+	}
+	return nil
+}
+
+func (k *kcp) wndUnused() uint16 {
+	if uint32(len(k.rcvQueue)) < k.rcvWnd {
+		return uint16(k.rcvWnd - uint32(len(k.rcvQueue)))
+	}
+	return 0
+}
+
+func (k *kcp) makeSpace(n int) {
+	if len(k.buffer)+n > k.mtu {
+		k.output(k.buffer)
+		k.buffer = k.buffer[:0]
+	}
+}
+
+func (k *kcp) flushBuffer() {
+	if len(k.buffer) > 0 {
+		k.output(k.buffer)
+		k.buffer = k.buffer[:0]
+	}
+}
+
+func (k *kcp) appendSegment(seg *kcpSegment) {
+	k.makeSpace(kcpOverhead + len(seg.data))
+	n := len(k.buffer)
+	k.buffer = k.buffer[:n+kcpOverhead]
+	seg.encode(k.buffer[n:])
+	k.buffer = append(k.buffer, seg.data...)
+}
+
+// current is the time in milliseconds
+func (k *kcp) flush(current uint32) {
+	k.current = current
+
+	var seg kcpSegment
+	seg.conv = k.conv
+	seg.cmd = kcpCmdAck
+	seg.wnd = k.wndUnused()
+	seg.una = k.rcvNxt
+
+	// acks
+	for i := 0; i < len(k.acklist); i += 2 {
+		seg.sn = k.acklist[i]
+		seg.ts = k.acklist[i+1]
+		k.appendSegment(&seg)
+	}
+	k.acklist = k.acklist[:0]
+
+	// window, at least one segment to probe a full remote window
+	cwnd := k.sndWnd
+	if k.rmtWnd < cwnd {
+		cwnd = k.rmtWnd
+	}
+	if cwnd == 0 {
+		cwnd = 1
+	}
+	for len(k.sndQueue) > 0 && timediff(k.sndNxt, k.sndUna+cwnd) < 0 {
+		newSeg := k.sndQueue[0]
+		k.sndQueue = k.sndQueue[1:]
+		newSeg.conv = k.conv
+		newSeg.cmd = kcpCmdPush
+		newSeg.sn = k.sndNxt
+		k.sndNxt++
+		k.sndBuf = append(k.sndBuf, newSeg)
+	}
+
+	// (re)transmissions
+	for _, s := range k.sndBuf {
+		needSend := false
+		if s.xmit == 0 {
+			needSend = true
+			s.rto = uint32(k.rxRto)
+			s.resendts = current + s.rto
+		} else if timediff(current, s.resendts) >= 0 {
+			needSend = true
+			s.rto += s.rto / 2
+			if s.rto > kcpRTOMax {
+				s.rto = kcpRTOMax
+			}
+			s.resendts = current + s.rto
+		} else if s.fastack >= kcpFastResend {
+			needSend = true
+			s.fastack = 0
+			s.resendts = current + s.rto
+		}
+
+		if needSend {
+			s.xmit++
+			s.ts = current
+			s.wnd = seg.wnd
+			s.una = k.rcvNxt
+			k.appendSegment(s)
+
+			if s.xmit >= kcpDeadLink {
+				k.dead = true
+			}
+		}
+	}
+
+	k.flushBuffer()
+}
+
+// unreliable, cmd is kcpCmdPing or kcpCmdClose
+func (k *kcp) sendCmd(cmd uint8) {
+	var seg kcpSegment
+	seg.conv = k.conv
+	seg.cmd = cmd
+	seg.wnd = k.wndUnused()
+	seg.una = k.rcvNxt
+	k.appendSegment(&seg)
+	k.flushBuffer()
+}
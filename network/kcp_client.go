@@ -0,0 +1,180 @@
+package network
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"github.com/name5566/leaf/log"
+	"net"
+	"sync"
+	"time"
+)
+
+type KCPClient struct {
+	sync.Mutex
+	Addr            string
+	ConnectInterval time.Duration
+	PendingWriteNum int
+	WriteOverflow   OverflowPolicy
+	WriteTimeout    time.Duration
+	MaxMsgLen       uint32
+	Interval        time.Duration
+	IdleTimeout     time.Duration
+	AutoReconnect   bool
+	NewAgent        func(*KCPConn) Agent
+	kcpConn         *KCPConn
+	wg              sync.WaitGroup
+	closeFlag       bool
+}
+
+func (client *KCPClient) Start() {
+	client.init()
+
+	client.wg.Add(1)
+	go client.connect()
+}
+
+func (client *KCPClient) init() {
+	client.Lock()
+	defer client.Unlock()
+
+	if client.ConnectInterval <= 0 {
+		client.ConnectInterval = 3 * time.Second
+		log.Infof("invalid ConnectInterval, reset to %v", client.ConnectInterval)
+	}
+	if client.PendingWriteNum <= 0 {
+		client.PendingWriteNum = 100
+		log.Infof("invalid PendingWriteNum, reset to %v", client.PendingWriteNum)
+	}
+	if client.WriteOverflow == OverflowBlock && client.WriteTimeout <= 0 {
+		client.WriteTimeout = time.Second
+		log.Infof("invalid WriteTimeout, reset to %v", client.WriteTimeout)
+	}
+	if client.MaxMsgLen <= 0 {
+		client.MaxMsgLen = 4096
+		log.Infof("invalid MaxMsgLen, reset to %v", client.MaxMsgLen)
+	} else if client.MaxMsgLen > kcpMaxMsgLen {
+		client.MaxMsgLen = kcpMaxMsgLen
+		log.Infof("invalid MaxMsgLen, reset to %v", client.MaxMsgLen)
+	}
+	if client.Interval <= 0 {
+		client.Interval = 10 * time.Millisecond
+		log.Infof("invalid Interval, reset to %v", client.Interval)
+	}
+	if client.IdleTimeout <= 0 {
+		client.IdleTimeout = 30 * time.Second
+		log.Infof("invalid IdleTimeout, reset to %v", client.IdleTimeout)
+	}
+	if client.NewAgent == nil {
+		log.Fatalf("NewAgent must not be nil")
+	}
+
+	client.closeFlag = false
+}
+
+func (client *KCPClient) dial() *net.UDPConn {
+	for {
+		udpAddr, err := net.ResolveUDPAddr("udp4", client.Addr)
+		if err == nil {
+			var conn *net.UDPConn
+			conn, err = net.DialUDP("udp", nil, udpAddr)
+			if err == nil {
+				return conn
+			}
+		}
+
+		client.Lock()
+		closeFlag := client.closeFlag
+		client.Unlock()
+		if closeFlag {
+			return nil
+		}
+
+		log.Infof("connect to %v error: %v", client.Addr, err)
+		time.Sleep(client.ConnectInterval)
+	}
+}
+
+// never 0
+func newConv() uint32 {
+	for {
+		var b [4]byte
+		rand.Read(b[:])
+		if conv := binary.LittleEndian.Uint32(b[:]); conv != 0 {
+			return conv
+		}
+	}
+}
+
+func (client *KCPClient) connect() {
+	defer client.wg.Done()
+
+reconnect:
+	conn := client.dial()
+	if conn == nil {
+		return
+	}
+
+	udpConn := newUDPConn(conn, 16*client.PendingWriteNum, OverflowDropNewest, 0, kcpMTU, kcpMetrics)
+	kcpConn := newKCPConn(udpConn, nil, newConv(), client.PendingWriteNum, client.WriteOverflow, client.WriteTimeout, client.MaxMsgLen, client.Interval, client.IdleTimeout)
+
+	client.Lock()
+	if client.closeFlag {
+		client.Unlock()
+		udpConn.Close()
+		return
+	}
+	client.kcpConn = kcpConn
+	client.Unlock()
+
+	// the server opens the session before the first message
+	kcpConn.Lock()
+	kcpConn.kcp.sendCmd(kcpCmdPing)
+	kcpConn.Unlock()
+	go kcpConn.update()
+
+	var wgRead sync.WaitGroup
+	wgRead.Add(1)
+	go func() {
+		defer wgRead.Done()
+		for {
+			data, _, err := udpConn.ReadMsg()
+			if err != nil {
+				kcpConn.Destroy()
+				return
+			}
+			kcpConn.input(data)
+		}
+	}()
+
+	agent := client.NewAgent(kcpConn)
+	agent.Run()
+
+	// cleanup
+	kcpConn.Close()
+	<-kcpConn.closeSig
+	udpConn.Close()
+	wgRead.Wait()
+	client.Lock()
+	client.kcpConn = nil
+	client.Unlock()
+	agent.OnClose()
+
+	client.Lock()
+	closeFlag := client.closeFlag
+	client.Unlock()
+	if client.AutoReconnect && !closeFlag {
+		time.Sleep(client.ConnectInterval)
+		goto reconnect
+	}
+}
+
+func (client *KCPClient) Close() {
+	client.Lock()
+	client.closeFlag = true
+	if client.kcpConn != nil {
+		client.kcpConn.Destroy()
+	}
+	client.Unlock()
+
+	client.wg.Wait()
+}
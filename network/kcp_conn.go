@@ -0,0 +1,279 @@
+package network
+
+import (
+	"errors"
+	"github.com/name5566/leaf/log"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// a reliable session over UDP, see kcp
+type KCPConn struct {
+	sync.Mutex
+	kcp             *kcp
+	udpConn         *UDPConn
+	localAddr       net.Addr
+	remoteAddr      *net.UDPAddr
+	pendingWriteNum int
+	maxMsgLen       uint32
+	idleTimeout     time.Duration
+	start           time.Time
+	lastRecv        time.Time
+	lastSend        time.Time
+	interval        time.Duration
+	readSig         chan struct{}
+	closeSig        chan struct{}
+	closeFlag       bool
+	deadFlag        bool
+	onDead          func()
+
+	overflowPolicy  OverflowPolicy
+	overflowTimeout time.Duration
+	waiters         *writeWaiters
+	// closed when the send window has room again, nil if nobody waits
+	roomSig chan struct{}
+}
+
+// remoteAddr is nil for a connected UDP socket
+func newKCPConn(udpConn *UDPConn, remoteAddr *net.UDPAddr, conv uint32, pendingWriteNum int, overflowPolicy OverflowPolicy, overflowTimeout time.Duration, maxMsgLen uint32, interval time.Duration, idleTimeout time.Duration) *KCPConn {
+	kcpConn := new(KCPConn)
+	kcpConn.udpConn = udpConn
+	kcpConn.localAddr = udpConn.conn.LocalAddr()
+	kcpConn.remoteAddr = remoteAddr
+	if remoteAddr == nil {
+		kcpConn.remoteAddr, _ = udpConn.conn.RemoteAddr().(*net.UDPAddr)
+	}
+	kcpConn.pendingWriteNum = pendingWriteNum
+	kcpConn.overflowPolicy = overflowPolicy
+	kcpConn.overflowTimeout = overflowTimeout
+	kcpConn.waiters = newWriteWaiters()
+	kcpConn.maxMsgLen = maxMsgLen
+	kcpConn.interval = interval
+	kcpConn.idleTimeout = idleTimeout
+	kcpConn.start = time.Now()
+	kcpConn.lastRecv = kcpConn.start
+	kcpConn.lastSend = kcpConn.start
+	kcpConn.readSig = make(chan struct{}, 1)
+	kcpConn.closeSig = make(chan struct{})
+	kcpConn.kcp = newKCP(conv, kcpMTU, int32(interval/time.Millisecond), func(b []byte) {
+		kcpConn.lastSend = time.Now()
+		udpConn.WriteMsg(remoteAddr, b)
+	})
+
+	return kcpConn
+}
+
+func (kcpConn *KCPConn) now() uint32 {
+	return uint32(time.Since(kcpConn.start) / time.Millisecond)
+}
+
+// flushes the session every interval until it dies
+func (kcpConn *KCPConn) update() {
+	ticker := time.NewTicker(kcpConn.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-kcpConn.closeSig:
+			return
+		}
+
+		kcpConn.Lock()
+		kcpConn.kcp.flush(kcpConn.now())
+		switch {
+		case kcpConn.kcp.dead:
+			log.Debug("close conn: dead link")
+			kcpConn.doDestroy()
+		case time.Since(kcpConn.lastRecv) > kcpConn.idleTimeout:
+			log.Debug("close conn: idle timeout")
+			kcpConn.doDestroy()
+		case kcpConn.closeFlag && kcpConn.kcp.waitSnd() == 0:
+			kcpConn.doDestroy()
+		case time.Since(kcpConn.lastSend) > kcpConn.idleTimeout/3:
+			// keepalive
+			kcpConn.kcp.sendCmd(kcpCmdPing)
+		}
+		kcpConn.Unlock()
+	}
+}
+
+// called with the lock held
+func (kcpConn *KCPConn) die() {
+	if kcpConn.deadFlag {
+		return
+	}
+	kcpConn.deadFlag = true
+	close(kcpConn.closeSig)
+
+	if kcpConn.onDead != nil {
+		kcpConn.onDead()
+	}
+}
+
+// goroutine safe
+func (kcpConn *KCPConn) input(data []byte) {
+	kcpConn.Lock()
+	defer kcpConn.Unlock()
+	if kcpConn.deadFlag {
+		return
+	}
+
+	kcpConn.kcp.current = kcpConn.now()
+	if err := kcpConn.kcp.input(data); err != nil {
+		log.Debugf("kcp input error: %v", err)
+		return
+	}
+	kcpConn.lastRecv = time.Now()
+	if kcpConn.kcp.closed {
+		kcpConn.setClosed()
+		kcpConn.die()
+		return
+	}
+	if kcpConn.roomSig != nil && kcpConn.kcp.waitSnd() < kcpConn.pendingWriteNum {
+		close(kcpConn.roomSig)
+		kcpConn.roomSig = nil
+	}
+
+	select {
+	case kcpConn.readSig <- struct{}{}:
+	default:
+	}
+}
+
+func (kcpConn *KCPConn) doDestroy() {
+	if kcpConn.deadFlag {
+		return
+	}
+
+	kcpConn.kcp.sendCmd(kcpCmdClose)
+	kcpConn.setClosed()
+	kcpConn.die()
+}
+
+// called with the lock held, the writers waiting for room give up
+func (kcpConn *KCPConn) setClosed() {
+	if !kcpConn.closeFlag {
+		kcpConn.waiters.release()
+		kcpConn.closeFlag = true
+	}
+}
+
+func (kcpConn *KCPConn) Destroy() {
+	kcpConn.Lock()
+	defer kcpConn.Unlock()
+
+	kcpConn.doDestroy()
+}
+
+// the pending messages are sent before the session is closed
+func (kcpConn *KCPConn) Close() {
+	kcpConn.Lock()
+	defer kcpConn.Unlock()
+
+	kcpConn.setClosed()
+}
+
+func (kcpConn *KCPConn) LocalAddr() net.Addr {
+	return kcpConn.localAddr
+}
+
+func (kcpConn *KCPConn) RemoteAddr() net.Addr {
+	return kcpConn.remoteAddr
+}
+
+// goroutine not safe
+func (kcpConn *KCPConn) ReadMsg() ([]byte, error) {
+	for {
+		kcpConn.Lock()
+		msg, ok := kcpConn.kcp.recv()
+		deadFlag := kcpConn.deadFlag
+		kcpConn.Unlock()
+
+		if ok {
+			return msg, nil
+		}
+		if deadFlag {
+			return nil, io.EOF
+		}
+
+		select {
+		case <-kcpConn.readSig:
+		case <-kcpConn.closeSig:
+		}
+	}
+}
+
+// args must not be modified by the others goroutines
+func (kcpConn *KCPConn) WriteMsg(args ...[]byte) error {
+	kcpConn.Lock()
+	defer kcpConn.Unlock()
+	if kcpConn.closeFlag {
+		return nil
+	}
+
+	// get len
+	var msgLen uint32
+	for i := 0; i < len(args); i++ {
+		msgLen += uint32(len(args[i]))
+	}
+
+	// check len
+	if msgLen > kcpConn.maxMsgLen {
+		return errors.New("message too long")
+	} else if msgLen < 1 {
+		return errors.New("message too short")
+	}
+
+	if kcpConn.kcp.waitSnd() >= kcpConn.pendingWriteNum {
+		if err := kcpConn.overflow(); err != nil {
+			if err == errConnClosed {
+				return nil
+			}
+			return err
+		}
+	}
+
+	// merge the args
+	msg := make([]byte, msgLen)
+	l := 0
+	for i := 0; i < len(args); i++ {
+		copy(msg[l:], args[i])
+		l += len(args[i])
+	}
+
+	err := kcpConn.kcp.send(msg)
+	if err != nil {
+		return err
+	}
+	kcpConn.kcp.flush(kcpConn.now())
+
+	return nil
+}
+
+// the policy for a full send window, as in pushWrite, the segments sent and
+// not acknowledged yet are never dropped
+func (kcpConn *KCPConn) overflow() error {
+	if kcpConn.roomSig == nil {
+		kcpConn.roomSig = make(chan struct{})
+	}
+	roomSig := kcpConn.roomSig
+
+	err := overflow(kcpConn.overflowPolicy, kcpConn.overflowTimeout, kcpConn, kcpConn.waiters, kcpConn.kcp.dropOldest, func(timeout <-chan time.Time, done <-chan struct{}) bool {
+		select {
+		case <-roomSig:
+			return true
+		case <-timeout:
+		case <-done:
+		}
+		return false
+	})
+	if err == ErrChanFull {
+		log.Debug("close conn: channel full")
+		kcpConn.doDestroy()
+	}
+
+	return err
+}
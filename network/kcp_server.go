@@ -0,0 +1,177 @@
+package network
+
+import (
+	"github.com/name5566/leaf/log"
+	"net"
+	"sync"
+	"time"
+)
+
+// demultiplexes the datagrams by remote address into KCP sessions, see
+// kcpFirstDatagram for the datagrams opening a session, the sessions count
+// against MaxConnNum from their first datagram, before any message
+type KCPServer struct {
+	Addr            string
+	MaxConnNum      int
+	PendingWriteNum int
+	WriteOverflow   OverflowPolicy
+	WriteTimeout    time.Duration
+	MaxMsgLen       uint32
+	Interval        time.Duration
+	IdleTimeout     time.Duration
+	NewAgent        func(*KCPConn) Agent
+	udpConn         *UDPConn
+	conns           map[string]*KCPConn
+	mutexConns      sync.Mutex
+	wgLn            sync.WaitGroup
+	wgConns         sync.WaitGroup
+}
+
+func (server *KCPServer) Start() {
+	server.init()
+	go server.run()
+}
+
+func (server *KCPServer) init() {
+	udpAddr, err := net.ResolveUDPAddr("udp4", server.Addr)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	if server.MaxConnNum <= 0 {
+		server.MaxConnNum = 100
+		log.Infof("invalid MaxConnNum, reset to %v", server.MaxConnNum)
+	}
+	if server.PendingWriteNum <= 0 {
+		server.PendingWriteNum = 100
+		log.Infof("invalid PendingWriteNum, reset to %v", server.PendingWriteNum)
+	}
+	if server.WriteOverflow == OverflowBlock && server.WriteTimeout <= 0 {
+		server.WriteTimeout = time.Second
+		log.Infof("invalid WriteTimeout, reset to %v", server.WriteTimeout)
+	}
+	if server.MaxMsgLen <= 0 {
+		server.MaxMsgLen = 4096
+		log.Infof("invalid MaxMsgLen, reset to %v", server.MaxMsgLen)
+	} else if server.MaxMsgLen > kcpMaxMsgLen {
+		server.MaxMsgLen = kcpMaxMsgLen
+		log.Infof("invalid MaxMsgLen, reset to %v", server.MaxMsgLen)
+	}
+	if server.Interval <= 0 {
+		server.Interval = 10 * time.Millisecond
+		log.Infof("invalid Interval, reset to %v", server.Interval)
+	}
+	if server.IdleTimeout <= 0 {
+		server.IdleTimeout = 30 * time.Second
+		log.Infof("invalid IdleTimeout, reset to %v", server.IdleTimeout)
+	}
+	if server.NewAgent == nil {
+		log.Fatalf("NewAgent must not be nil")
+	}
+
+	// lost datagrams are retransmitted, don't close the shared socket
+	server.udpConn = newUDPConn(conn, 16*server.PendingWriteNum, OverflowDropNewest, 0, kcpMTU, kcpMetrics)
+	server.conns = make(map[string]*KCPConn)
+}
+
+func (server *KCPServer) run() {
+	server.wgLn.Add(1)
+	defer server.wgLn.Done()
+
+	for {
+		data, addr, err := server.udpConn.ReadMsg()
+		if err != nil {
+			server.mutexConns.Lock()
+			closed := server.conns == nil
+			server.mutexConns.Unlock()
+			if closed {
+				return
+			}
+			log.Debugf("read message: %v", err)
+			continue
+		}
+		if len(data) < kcpOverhead {
+			continue
+		}
+
+		key := addr.String()
+		server.mutexConns.Lock()
+		if server.conns == nil {
+			server.mutexConns.Unlock()
+			return
+		}
+		kcpConn := server.conns[key]
+		newFlag := kcpConn == nil
+		if newFlag {
+			conv, ok := kcpFirstDatagram(data)
+			if !ok {
+				server.mutexConns.Unlock()
+				continue
+			}
+			if len(server.conns) >= server.MaxConnNum {
+				server.mutexConns.Unlock()
+				log.Debug("too many connections")
+				continue
+			}
+			kcpConn = server.newConn(key, addr, conv)
+		}
+		server.mutexConns.Unlock()
+
+		if newFlag {
+			server.serve(kcpConn)
+		}
+		kcpConn.input(data)
+	}
+}
+
+// called with mutexConns held
+func (server *KCPServer) newConn(key string, addr *net.UDPAddr, conv uint32) *KCPConn {
+	kcpConn := newKCPConn(server.udpConn, addr, conv, server.PendingWriteNum, server.WriteOverflow, server.WriteTimeout, server.MaxMsgLen, server.Interval, server.IdleTimeout)
+	kcpConn.onDead = func() {
+		server.mutexConns.Lock()
+		if server.conns != nil && server.conns[key] == kcpConn {
+			delete(server.conns, key)
+		}
+		server.mutexConns.Unlock()
+	}
+	server.conns[key] = kcpConn
+	server.wgConns.Add(1)
+	kcpMetrics.accepted.Inc()
+
+	go kcpConn.update()
+
+	return kcpConn
+}
+
+func (server *KCPServer) serve(kcpConn *KCPConn) {
+	agent := server.NewAgent(kcpConn)
+	go func() {
+		agent.Run()
+
+		// cleanup
+		kcpConn.Close()
+		kcpMetrics.closed.Inc()
+		agent.OnClose()
+
+		server.wgConns.Done()
+	}()
+}
+
+func (server *KCPServer) Close() {
+	server.mutexConns.Lock()
+	conns := server.conns
+	server.conns = nil
+	server.mutexConns.Unlock()
+
+	for _, kcpConn := range conns {
+		kcpConn.Destroy()
+	}
+	server.wgConns.Wait()
+
+	server.udpConn.Close()
+	server.wgLn.Wait()
+}
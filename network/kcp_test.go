@@ -0,0 +1,332 @@
+package network
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestKCPLossyLink(t *testing.T) {
+	var toA, toB [][]byte
+	n := 0
+	lossy := func(queue *[][]byte) func([]byte) {
+		return func(b []byte) {
+			// drop every third datagram
+			n++
+			if n%3 == 0 {
+				return
+			}
+			*queue = append(*queue, append([]byte(nil), b...))
+		}
+	}
+	a := newKCP(1, kcpMTU, 10, lossy(&toB))
+	b := newKCP(1, kcpMTU, 10, lossy(&toA))
+
+	var msgs [][]byte
+	for i := 0; i < 50; i++ {
+		msg := bytes.Repeat([]byte(fmt.Sprint(i)), 1+i*60)
+		msgs = append(msgs, msg)
+		if err := a.send(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var current uint32
+	var recv [][]byte
+	for i := 0; i < 2000 && len(recv) < len(msgs); i++ {
+		current += 10
+		a.flush(current)
+		b.flush(current)
+
+		for _, d := range toB {
+			b.current = current
+			if err := b.input(d); err != nil {
+				t.Fatal(err)
+			}
+		}
+		toB = toB[:0]
+		for _, d := range toA {
+			a.current = current
+			if err := a.input(d); err != nil {
+				t.Fatal(err)
+			}
+		}
+		toA = toA[:0]
+
+		for {
+			msg, ok := b.recv()
+			if !ok {
+				break
+			}
+			recv = append(recv, msg)
+		}
+	}
+
+	if len(recv) != len(msgs) {
+		t.Fatalf("got %v messages, want %v", len(recv), len(msgs))
+	}
+	for i := range msgs {
+		if !bytes.Equal(recv[i], msgs[i]) {
+			t.Fatalf("message %v corrupted", i)
+		}
+	}
+	if a.dead || b.dead {
+		t.Fatal("link dead")
+	}
+}
+
+type kcpEchoAgent struct {
+	conn *KCPConn
+}
+
+func (a *kcpEchoAgent) Run() {
+	for {
+		data, err := a.conn.ReadMsg()
+		if err != nil {
+			return
+		}
+		a.conn.WriteMsg(data)
+	}
+}
+
+func (a *kcpEchoAgent) OnClose() {}
+
+type kcpClientAgent struct {
+	conn *KCPConn
+	msgs [][]byte
+	err  error
+	wg   *sync.WaitGroup
+}
+
+func (a *kcpClientAgent) Run() {
+	defer a.wg.Done()
+
+	for _, msg := range a.msgs {
+		a.conn.WriteMsg(msg)
+	}
+	for _, msg := range a.msgs {
+		data, err := a.conn.ReadMsg()
+		if err != nil {
+			a.err = err
+			return
+		}
+		if !bytes.Equal(data, msg) {
+			a.err = fmt.Errorf("got %q, want %q", data, msg)
+			return
+		}
+	}
+}
+
+func (a *kcpClientAgent) OnClose() {}
+
+func TestKCPServer(t *testing.T) {
+	server := new(KCPServer)
+	server.Addr = "127.0.0.1:0"
+	server.NewAgent = func(conn *KCPConn) Agent {
+		return &kcpEchoAgent{conn: conn}
+	}
+	server.Start()
+	defer server.Close()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	a := &kcpClientAgent{wg: &wg}
+	for i := 0; i < 20; i++ {
+		a.msgs = append(a.msgs, bytes.Repeat([]byte{byte('a' + i)}, 1+i*150))
+	}
+
+	client := new(KCPClient)
+	client.Addr = server.udpConn.conn.LocalAddr().String()
+	client.NewAgent = func(conn *KCPConn) Agent {
+		a.conn = conn
+		return a
+	}
+	client.Start()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout")
+	}
+	client.Close()
+
+	if a.err != nil {
+		t.Fatal(a.err)
+	}
+}
+
+func TestKCPMaxMsgLen(t *testing.T) {
+	var toB [][]byte
+	a := newKCP(1, kcpMTU, 10, func(d []byte) {
+		toB = append(toB, append([]byte(nil), d...))
+	})
+	b := newKCP(1, kcpMTU, 10, func([]byte) {})
+
+	if err := a.send(make([]byte, kcpMaxMsgLen+1)); err == nil {
+		t.Fatal("message longer than the receive window accepted")
+	}
+	msg := bytes.Repeat([]byte{'m'}, kcpMaxMsgLen)
+	if err := a.send(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	// b acks nothing, the window of a stays open for a single flush
+	a.flush(10)
+	for _, d := range toB {
+		if err := b.input(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, ok := b.recv()
+	if !ok || !bytes.Equal(got, msg) {
+		t.Fatalf("message of %v bytes not received", len(msg))
+	}
+}
+
+func TestKCPServerFirstDatagram(t *testing.T) {
+	server := new(KCPServer)
+	server.Addr = "127.0.0.1:0"
+	server.NewAgent = func(conn *KCPConn) Agent {
+		return &kcpEchoAgent{conn: conn}
+	}
+	server.Start()
+	defer server.Close()
+
+	conn, err := net.DialUDP("udp", nil, server.udpConn.conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	seg := kcpSegment{conv: 7, cmd: kcpCmdAck}
+	ack := make([]byte, kcpOverhead)
+	seg.encode(ack)
+	seg = kcpSegment{conv: 7, cmd: kcpCmdPush, sn: 1000}
+	push := make([]byte, kcpOverhead)
+	seg.encode(push)
+	seg = kcpSegment{cmd: kcpCmdPing}
+	noConv := make([]byte, kcpOverhead)
+	seg.encode(noConv)
+	for _, d := range [][]byte{ack, push, noConv, bytes.Repeat([]byte{0xff}, 100)} {
+		conn.Write(d)
+	}
+
+	seg = kcpSegment{conv: 7, cmd: kcpCmdPing}
+	ping := make([]byte, kcpOverhead)
+	seg.encode(ping)
+	conn.Write(ping)
+
+	for i := 0; i < 100; i++ {
+		server.mutexConns.Lock()
+		n := len(server.conns)
+		server.mutexConns.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	server.mutexConns.Lock()
+	defer server.mutexConns.Unlock()
+	if len(server.conns) != 1 {
+		t.Fatalf("%v sessions, want 1", len(server.conns))
+	}
+	for _, kcpConn := range server.conns {
+		if kcpConn.kcp.conv != 7 {
+			t.Fatalf("session opened by conv %v", kcpConn.kcp.conv)
+		}
+	}
+}
+
+func TestKCPConnOverflow(t *testing.T) {
+	sink, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	cases := []struct {
+		policy OverflowPolicy
+		err    error
+		closed bool
+	}{
+		{OverflowDisconnect, ErrChanFull, true},
+		{OverflowDropOldest, nil, false},
+		{OverflowDropNewest, ErrDropNewest, false},
+		{OverflowBlock, ErrWriteTimeout, false},
+	}
+	for _, c := range cases {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		udpConn := newUDPConn(conn, 16, OverflowDropNewest, 0, kcpMTU, kcpMetrics)
+		kcpConn := newKCPConn(udpConn, sink.LocalAddr().(*net.UDPAddr), 1, 2, c.policy, 20*time.Millisecond, 4096, 10*time.Millisecond, time.Minute)
+
+		// nothing is acknowledged, the third message finds the window full
+		for i := 0; i < 2; i++ {
+			if err := kcpConn.WriteMsg([]byte("m")); err != nil {
+				t.Fatalf("%v: %v", c.policy, err)
+			}
+		}
+		err = kcpConn.WriteMsg([]byte("m"))
+		kcpConn.Lock()
+		closed := kcpConn.closeFlag
+		kcpConn.Unlock()
+		if err != c.err || closed != c.closed {
+			t.Fatalf("%v: got %v, closed %v", c.policy, err, closed)
+		}
+		kcpConn.Destroy()
+		udpConn.Destroy()
+	}
+}
+
+func TestKCPConnPeerClose(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	udpConn := newUDPConn(conn, 16, OverflowDropNewest, 0, kcpMTU, kcpMetrics)
+	defer udpConn.Destroy()
+	kcpConn := newKCPConn(udpConn, conn.LocalAddr().(*net.UDPAddr), 1, 2, OverflowBlock, time.Minute, 4096, 10*time.Millisecond, time.Minute)
+
+	// a writer waiting for room gives up when the peer closes
+	for i := 0; i < 2; i++ {
+		kcpConn.WriteMsg([]byte("m"))
+	}
+	done := make(chan error)
+	go func() {
+		done <- kcpConn.WriteMsg([]byte("m"))
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	seg := kcpSegment{conv: 1, cmd: kcpCmdClose}
+	data := make([]byte, kcpOverhead)
+	seg.encode(data)
+	kcpConn.input(data)
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("writer still waiting")
+	}
+	kcpConn.Lock()
+	closed := kcpConn.closeFlag
+	kcpConn.Unlock()
+	if !closed {
+		t.Fatal("conn not closed by the peer")
+	}
+	if _, err := kcpConn.ReadMsg(); err == nil {
+		t.Fatal("read after the peer closed")
+	}
+}
@@ -0,0 +1,51 @@
+package network
+
+import (
+	"github.com/name5566/leaf/metrics"
+	"io"
+)
+
+var (
+	connsAccepted = metrics.NewCounter("leaf_network_conns_accepted_total", "Connections accepted by the servers.", "transport")
+	connsClosed   = metrics.NewCounter("leaf_network_conns_closed_total", "Server connections closed.", "transport")
+	bytesTotal    = metrics.NewCounter("leaf_network_bytes_total", "Bytes read and written by the connections.", "transport", "direction")
+	writeOverflow = metrics.NewCounter("leaf_network_write_overflow_total", "Writes that found a full write channel.").With()
+)
+
+// the counters of a transport
+type connMetrics struct {
+	accepted *metrics.CounterValue
+	closed   *metrics.CounterValue
+	in       *metrics.CounterValue
+	out      *metrics.CounterValue
+}
+
+func newConnMetrics(transport string) *connMetrics {
+	return &connMetrics{
+		accepted: connsAccepted.With(transport),
+		closed:   connsClosed.With(transport),
+		in:       bytesTotal.With(transport, "in"),
+		out:      bytesTotal.With(transport, "out"),
+	}
+}
+
+var (
+	tcpMetrics = newConnMetrics("tcp")
+	wsMetrics  = newConnMetrics("ws")
+	udpMetrics = newConnMetrics("udp")
+	kcpMetrics = newConnMetrics("kcp")
+)
+
+// counts the bytes read from r
+type countingReader struct {
+	r       io.Reader
+	counter *metrics.CounterValue
+}
+
+func (cr countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	if n > 0 {
+		cr.counter.Add(float64(n))
+	}
+	return n, err
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"github.com/name5566/leaf/chanrpc"
 	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/trace"
 	"github.com/vmihailenco/msgpack/v4"
 	"math"
 	"reflect"
@@ -103,6 +104,12 @@ func (p *Processor) SetRawHandler(id uint16, msgRawHandler MsgHandler) {
 
 // goroutine safe
 func (p *Processor) Route(msg interface{}, userData interface{}) error {
+	return p.RouteSpan(trace.SpanContext{}, msg, userData)
+}
+
+// the call of the router is a child of sc, see network.SpanRouter
+// goroutine safe
+func (p *Processor) RouteSpan(sc trace.SpanContext, msg interface{}, userData interface{}) error {
 	// raw
 	if msgRaw, ok := msg.(MsgRaw); ok {
 		if _,ok := p.msgInfo[msgRaw.msgID]; !ok{
@@ -126,7 +133,7 @@ func (p *Processor) Route(msg interface{}, userData interface{}) error {
 		i.msgHandler([]interface{}{msg, userData})
 	}
 	if i.msgRouter != nil {
-		i.msgRouter.Go(msgType, msg, userData)
+		i.msgRouter.GoFrom(sc, msgType, msg, userData)
 	}
 	return nil
 }
@@ -158,6 +165,17 @@ func (p *Processor) Unmarshal(data []byte) (interface{}, error) {
 	}
 }
 
+// goroutine safe
+// raw messages reference the unmarshaled data
+func (p *Processor) ReleasesData() bool {
+	for _, i := range p.msgInfo {
+		if i.msgRawHandler != nil {
+			return false
+		}
+	}
+	return true
+}
+
 // goroutine safe
 func (p *Processor) Marshal(msg interface{}) ([][]byte, error) {
 	msgType := reflect.TypeOf(msg)
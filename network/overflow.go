@@ -0,0 +1,143 @@
+package network
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// what a connection does when its write channel is full
+type OverflowPolicy int
+
+const (
+	// destroy the connection (default)
+	OverflowDisconnect OverflowPolicy = iota
+	// discard the oldest pending message to make room, the write succeeds
+	OverflowDropOldest
+	// discard the message being written
+	OverflowDropNewest
+	// wait for room up to the write timeout, the conn can be closed meanwhile
+	OverflowBlock
+)
+
+var (
+	ErrChanFull     = errors.New("close conn: channel full")
+	ErrDropNewest   = errors.New("channel full: message dropped")
+	ErrWriteTimeout = errors.New("channel full: write timeout")
+)
+
+// goroutine safe
+// number of writes that found a full write channel since the process started
+func WriteOverflowCount() uint64 {
+	return uint64(writeOverflow.Get())
+}
+
+func (policy OverflowPolicy) String() string {
+	switch policy {
+	case OverflowDisconnect:
+		return "disconnect"
+	case OverflowDropOldest:
+		return "drop-oldest"
+	case OverflowDropNewest:
+		return "drop-newest"
+	case OverflowBlock:
+		return "block"
+	default:
+		return "unknown"
+	}
+}
+
+// the writers of a conn waiting for room with OverflowBlock, they wait with
+// the mutex of the conn released
+type writeWaiters struct {
+	wg   sync.WaitGroup
+	done chan struct{}
+}
+
+func newWriteWaiters() *writeWaiters {
+	w := new(writeWaiters)
+	w.done = make(chan struct{})
+	return w
+}
+
+// called once, with the mutex of the conn held, before the write channel is
+// closed, the writers waiting give up
+func (w *writeWaiters) release() {
+	close(w.done)
+	w.wg.Wait()
+}
+
+// send queues the message unless timeout fires or done is closed first,
+// mutex is held again when wait returns
+func (w *writeWaiters) wait(mutex sync.Locker, timeout time.Duration, send func(timeout <-chan time.Time, done <-chan struct{}) bool) error {
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+
+	w.wg.Add(1)
+	mutex.Unlock()
+	ok := send(t.C, w.done)
+	w.wg.Done()
+	mutex.Lock()
+
+	if ok {
+		return nil
+	}
+	select {
+	case <-w.done:
+		return errConnClosed
+	default:
+		return ErrWriteTimeout
+	}
+}
+
+var errConnClosed = errors.New("conn closed while waiting")
+
+// the policy for a write channel found full, called with the mutex of the
+// conn held, dropOldest discards the oldest pending message and queues the
+// new one, send is as in writeWaiters.wait, the caller must destroy the
+// conn when ErrChanFull is returned and release the message unless nil is
+// returned
+func overflow(policy OverflowPolicy, timeout time.Duration, mutex sync.Locker, waiters *writeWaiters, dropOldest func(), send func(timeout <-chan time.Time, done <-chan struct{}) bool) error {
+	writeOverflow.Inc()
+
+	switch policy {
+	case OverflowDropOldest:
+		dropOldest()
+		return nil
+	case OverflowDropNewest:
+		return ErrDropNewest
+	case OverflowBlock:
+		return waiters.wait(mutex, timeout, send)
+	default:
+		return ErrChanFull
+	}
+}
+
+// b is queued on writeChan according to policy, see overflow, release is
+// called with the message dropped by OverflowDropOldest if not nil
+func pushWrite(writeChan chan []byte, b []byte, policy OverflowPolicy, timeout time.Duration, mutex sync.Locker, waiters *writeWaiters, release func([]byte)) error {
+	select {
+	case writeChan <- b:
+		return nil
+	default:
+	}
+
+	return overflow(policy, timeout, mutex, waiters, func() {
+		select {
+		case old := <-writeChan:
+			if release != nil {
+				release(old)
+			}
+		default:
+		}
+		writeChan <- b
+	}, func(timeout <-chan time.Time, done <-chan struct{}) bool {
+		select {
+		case writeChan <- b:
+			return true
+		case <-timeout:
+		case <-done:
+		}
+		return false
+	})
+}
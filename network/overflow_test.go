@@ -0,0 +1,82 @@
+package network
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPushWrite(t *testing.T) {
+	tests := []struct {
+		policy  OverflowPolicy
+		err     error
+		head    string
+		dropped string
+	}{
+		{OverflowDisconnect, ErrChanFull, "a", ""},
+		{OverflowDropOldest, nil, "b", "a"},
+		{OverflowDropNewest, ErrDropNewest, "a", ""},
+		{OverflowBlock, ErrWriteTimeout, "a", ""},
+	}
+
+	for _, test := range tests {
+		var mutex sync.Mutex
+		waiters := newWriteWaiters()
+		writeChan := make(chan []byte, 2)
+		dropped := ""
+		release := func(b []byte) {
+			dropped += string(b)
+		}
+		mutex.Lock()
+		pushWrite(writeChan, []byte("a"), test.policy, time.Millisecond, &mutex, waiters, release)
+		pushWrite(writeChan, []byte("b"), test.policy, time.Millisecond, &mutex, waiters, release)
+
+		n := WriteOverflowCount()
+		err := pushWrite(writeChan, []byte("c"), test.policy, time.Millisecond, &mutex, waiters, release)
+		mutex.Unlock()
+		if err != test.err {
+			t.Errorf("%v: got error %v, want %v", test.policy, err, test.err)
+		}
+		if WriteOverflowCount() != n+1 {
+			t.Errorf("%v: overflow not counted", test.policy)
+		}
+		if head := string(<-writeChan); head != test.head {
+			t.Errorf("%v: got head %v, want %v", test.policy, head, test.head)
+		}
+		if dropped != test.dropped {
+			t.Errorf("%v: released %q, want %q", test.policy, dropped, test.dropped)
+		}
+	}
+}
+
+func TestOverflowBlockClose(t *testing.T) {
+	// nobody reads the other end, the write loop is stuck
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	tcpConn := newTCPConn(c1, 1, OverflowBlock, time.Hour, 1, 64, NewMsgParser())
+
+	errs := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			errs <- tcpConn.WriteMsg([]byte("x"))
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	closed := make(chan struct{})
+	go func() {
+		tcpConn.Destroy()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Destroy blocked by a waiting writer")
+	}
+	for i := 0; i < 3; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
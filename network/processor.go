@@ -1,5 +1,9 @@
 package network
 
+import (
+	"github.com/name5566/leaf/trace"
+)
+
 type Processor interface {
 	// must goroutine safe
 	Route(msg interface{}, userData interface{}) error
@@ -8,3 +12,39 @@ type Processor interface {
 	// must goroutine safe
 	Marshal(msg interface{}) ([][]byte, error)
 }
+
+// A Processor may implement SpanRouter to make the calls of its routers
+// children of the span of the message, which the gate starts for every
+// message routed.
+type SpanRouter interface {
+	// must goroutine safe
+	RouteSpan(sc trace.SpanContext, msg interface{}, userData interface{}) error
+}
+
+// A Processor may implement DataReleaser to declare that the messages
+// returned by Unmarshal hold no reference to data. Only then data may be
+// recycled once Unmarshal returns, which zero-copy reads and pooled read
+// buffers rely on.
+type DataReleaser interface {
+	// must goroutine safe
+	ReleasesData() bool
+}
+
+// A Processor may implement TextFramer to declare that Marshal returns UTF-8
+// text, which is sent in WebSocket text frames with WSFrameAuto.
+type TextFramer interface {
+	// must goroutine safe
+	TextFrames() bool
+}
+
+// goroutine safe
+func TextFrames(processor Processor) bool {
+	t, ok := processor.(TextFramer)
+	return ok && t.TextFrames()
+}
+
+// goroutine safe
+func ReleasesData(processor Processor) bool {
+	r, ok := processor.(DataReleaser)
+	return ok && r.ReleasesData()
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/golang/protobuf/proto"
 	"github.com/name5566/leaf/chanrpc"
 	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/trace"
 	"reflect"
 )
 
@@ -113,6 +114,12 @@ func (p *Processor) SetRawMergedHandler(id uint16, msgRawMergedHandler MsgHandle
 
 // goroutine safe
 func (p *Processor) Route(msg interface{}, userData interface{}) error {
+	return p.RouteSpan(trace.SpanContext{}, msg, userData)
+}
+
+// the call of the router is a child of sc, see network.SpanRouter
+// goroutine safe
+func (p *Processor) RouteSpan(sc trace.SpanContext, msg interface{}, userData interface{}) error {
 	// raw
 	if msgRaw, ok := msg.(MsgRaw); ok {
 		_, ok := p.msgInfo[msgRaw.msgID]
@@ -146,7 +153,7 @@ func (p *Processor) Route(msg interface{}, userData interface{}) error {
 		i.msgRawHandler([]interface{}{msg, userData})
 	}
 	if i.msgRouter != nil {
-		i.msgRouter.Go(msgType, msg, userData)
+		i.msgRouter.GoFrom(sc, msgType, msg, userData)
 	}
 	return nil
 }
@@ -180,6 +187,17 @@ func (p *Processor) Unmarshal(data []byte) (interface{}, error) {
 	}
 }
 
+// goroutine safe
+// raw messages reference the unmarshaled data
+func (p *Processor) ReleasesData() bool {
+	for _, i := range p.msgInfo {
+		if i.msgRawHandler != nil || i.msgRawMergedHandler != nil {
+			return false
+		}
+	}
+	return true
+}
+
 // goroutine safe
 func (p *Processor) Marshal(msg interface{}) ([][]byte, error) {
 	msgType := reflect.TypeOf(msg)
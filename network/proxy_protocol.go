@@ -0,0 +1,229 @@
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// reference: https://www.haproxy.org/download/2.0/doc/proxy-protocol.txt
+//
+// every accepted connection must start with a PROXY protocol v1 or v2
+// header, which gives the address of the client to RemoteAddr
+type proxyListener struct {
+	net.Listener
+	timeout time.Duration
+}
+
+func (ln *proxyListener) Accept() (net.Conn, error) {
+	conn, err := ln.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyConn{Conn: conn, timeout: ln.timeout}, nil
+}
+
+// the header is read on the first Read or RemoteAddr, not to block Accept
+type proxyConn struct {
+	net.Conn
+	timeout    time.Duration
+	once       sync.Once
+	reader     *bufio.Reader
+	remoteAddr net.Addr
+	err        error
+}
+
+func (conn *proxyConn) init() {
+	conn.once.Do(func() {
+		conn.reader = bufio.NewReader(conn.Conn)
+		conn.Conn.SetReadDeadline(time.Now().Add(conn.timeout))
+		conn.remoteAddr, conn.err = readProxyHeader(conn.reader)
+		conn.Conn.SetReadDeadline(time.Time{})
+		if conn.err != nil {
+			conn.Conn.Close()
+		}
+	})
+}
+
+func (conn *proxyConn) Read(b []byte) (int, error) {
+	conn.init()
+	if conn.err != nil {
+		return 0, conn.err
+	}
+	return conn.reader.Read(b)
+}
+
+func (conn *proxyConn) RemoteAddr() net.Addr {
+	conn.init()
+	if conn.remoteAddr != nil {
+		return conn.remoteAddr
+	}
+	return conn.Conn.RemoteAddr()
+}
+
+var proxySignatureV2 = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// returns a nil address for the health checks of the proxy (UNKNOWN, LOCAL)
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	sig, err := r.Peek(len(proxySignatureV2))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(sig, proxySignatureV2) {
+		return readProxyHeaderV2(r)
+	}
+	if bytes.HasPrefix(sig, []byte("PROXY ")) {
+		return readProxyHeaderV1(r)
+	}
+	return nil, errors.New("missing PROXY protocol header")
+}
+
+// PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n
+func readProxyHeaderV1(r *bufio.Reader) (net.Addr, error) {
+	// at most 107 bytes
+	var line []byte
+	for len(line) < 107 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("invalid PROXY protocol header")
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errors.New("invalid PROXY protocol header")
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil {
+		return nil, errors.New("invalid PROXY protocol header")
+	}
+
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// ------------------------------------------------------------
+// | signature 12 | ver cmd 1 | family 1 | len 2 | addresses |
+// ------------------------------------------------------------
+func readProxyHeaderV2(r *bufio.Reader) (net.Addr, error) {
+	var header [16]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	if header[12]>>4 != 2 {
+		return nil, errors.New("invalid PROXY protocol version")
+	}
+	addrs := make([]byte, binary.BigEndian.Uint16(header[14:]))
+	if _, err := io.ReadFull(r, addrs); err != nil {
+		return nil, err
+	}
+
+	// LOCAL
+	if header[12]&0xF == 0 {
+		return nil, nil
+	}
+
+	switch header[13] >> 4 {
+	case 1:
+		// AF_INET
+		if len(addrs) < 12 {
+			return nil, errors.New("invalid PROXY protocol header")
+		}
+		return &net.TCPAddr{IP: net.IP(addrs[:4]), Port: int(binary.BigEndian.Uint16(addrs[8:]))}, nil
+	case 2:
+		// AF_INET6
+		if len(addrs) < 36 {
+			return nil, errors.New("invalid PROXY protocol header")
+		}
+		return &net.TCPAddr{IP: net.IP(addrs[:16]), Port: int(binary.BigEndian.Uint16(addrs[32:]))}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// parses IPs and CIDRs
+func parseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, errors.New("invalid trusted proxy " + proxy)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+func isTrusted(ip net.IP, trusted []*net.IPNet) bool {
+	for _, ipNet := range trusted {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// the client address is the rightmost X-Forwarded-For entry not added by
+// a trusted proxy, the header is ignored unless the peer is trusted
+func forwardedAddr(remoteAddr string, xff []string, trusted []*net.IPNet) net.Addr {
+	host, port, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return nil
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil
+	}
+	p, _ := strconv.Atoi(port)
+	addr := &net.TCPAddr{IP: ip, Port: p}
+	if !isTrusted(ip, trusted) {
+		return addr
+	}
+
+	var entries []string
+	for _, v := range xff {
+		entries = append(entries, strings.Split(v, ",")...)
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(entries[i]))
+		if ip == nil {
+			break
+		}
+		addr = &net.TCPAddr{IP: ip}
+		if !isTrusted(ip, trusted) {
+			break
+		}
+	}
+	return addr
+}
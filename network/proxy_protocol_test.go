@@ -0,0 +1,72 @@
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+func TestReadProxyHeader(t *testing.T) {
+	v2 := append([]byte(nil), proxySignatureV2...)
+	v2 = append(v2, 0x21, 0x11, 0, 12)
+	v2 = append(v2, 10, 0, 0, 1, 10, 0, 0, 2, 0x1F, 0x90, 0x01, 0xBB)
+
+	cases := []struct {
+		header string
+		addr   string
+	}{
+		{"PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n", "192.168.0.1:56324"},
+		{"PROXY TCP6 ::1 ::1 56324 443\r\n", "[::1]:56324"},
+		{"PROXY UNKNOWN\r\n", ""},
+		{string(v2), "10.0.0.1:8080"},
+	}
+	for _, c := range cases {
+		r := bufio.NewReader(bytes.NewReader([]byte(c.header + "GET")))
+		addr, err := readProxyHeader(r)
+		if err != nil {
+			t.Fatalf("%q: %v", c.header, err)
+		}
+		if (addr == nil && c.addr != "") || (addr != nil && addr.String() != c.addr) {
+			t.Fatalf("%q: got %v, want %v", c.header, addr, c.addr)
+		}
+		if rest, _ := r.Peek(3); string(rest) != "GET" {
+			t.Fatalf("%q: header not consumed", c.header)
+		}
+	}
+
+	for _, header := range []string{"GET / HTTP/1.1\r\n", "PROXY TCP4 x y 1 2\r\n"} {
+		r := bufio.NewReader(bytes.NewReader([]byte(header)))
+		if _, err := readProxyHeader(r); err == nil {
+			t.Fatalf("%q: accepted", header)
+		}
+	}
+}
+
+func TestForwardedAddr(t *testing.T) {
+	trusted, err := parseTrustedProxies([]string{"10.0.0.0/8", "127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		remoteAddr string
+		xff        []string
+		addr       string
+	}{
+		{"1.2.3.4:5000", []string{"5.6.7.8"}, "1.2.3.4:5000"},
+		{"127.0.0.1:5000", nil, "127.0.0.1:5000"},
+		{"127.0.0.1:5000", []string{"9.9.9.9, 5.6.7.8, 10.0.0.2"}, "5.6.7.8:0"},
+		{"127.0.0.1:5000", []string{"5.6.7.8", "10.0.0.2"}, "5.6.7.8:0"},
+		{"127.0.0.1:5000", []string{"junk, 10.0.0.2"}, "10.0.0.2:0"},
+	}
+	for _, c := range cases {
+		addr := forwardedAddr(c.remoteAddr, c.xff, trusted)
+		if addr.String() != c.addr {
+			t.Fatalf("%v %v: got %v, want %v", c.remoteAddr, c.xff, addr, c.addr)
+		}
+	}
+
+	if _, err := parseTrustedProxies([]string{"proxy"}); err == nil {
+		t.Fatal("invalid proxy accepted")
+	}
+}
@@ -13,6 +13,10 @@ type TCPClient struct {
 	ConnNum         int
 	ConnectInterval time.Duration
 	PendingWriteNum int
+	WriteOverflow   OverflowPolicy
+	WriteTimeout    time.Duration
+	MaxWriteBatch   int
+	ReadBufferSize  int
 	AutoReconnect   bool
 	NewAgent        func(*TCPConn) Agent
 	conns           ConnSet
@@ -24,7 +28,12 @@ type TCPClient struct {
 	MinMsgLen    uint32
 	MaxMsgLen    uint32
 	LittleEndian bool
-	msgParser    *MsgParser
+	// set to the zero-copy mode of MsgFramer by Start
+	ZeroCopyRead bool
+
+	// overrides the msg parser settings above but ZeroCopyRead, see
+	// ZeroCopyFramer
+	MsgFramer MsgFramer
 }
 
 func (client *TCPClient) Start() {
@@ -52,6 +61,18 @@ func (client *TCPClient) init() {
 		client.PendingWriteNum = 100
 		log.Infof("invalid PendingWriteNum, reset to %v", client.PendingWriteNum)
 	}
+	if client.WriteOverflow == OverflowBlock && client.WriteTimeout <= 0 {
+		client.WriteTimeout = time.Second
+		log.Infof("invalid WriteTimeout, reset to %v", client.WriteTimeout)
+	}
+	if client.MaxWriteBatch <= 0 {
+		client.MaxWriteBatch = 64
+		log.Infof("invalid MaxWriteBatch, reset to %v", client.MaxWriteBatch)
+	}
+	if client.ReadBufferSize <= 0 {
+		client.ReadBufferSize = 4096
+		log.Infof("invalid ReadBufferSize, reset to %v", client.ReadBufferSize)
+	}
 	if client.NewAgent == nil {
 		log.Fatalf("NewAgent must not be nil")
 	}
@@ -63,10 +84,14 @@ func (client *TCPClient) init() {
 	client.closeFlag = false
 
 	// msg parser
-	msgParser := NewMsgParser()
-	msgParser.SetMsgLen(client.LenMsgLen, client.MinMsgLen, client.MaxMsgLen)
-	msgParser.SetByteOrder(client.LittleEndian)
-	client.msgParser = msgParser
+	if client.MsgFramer == nil {
+		msgParser := NewMsgParser()
+		msgParser.SetMsgLen(client.LenMsgLen, client.MinMsgLen, client.MaxMsgLen)
+		msgParser.SetByteOrder(client.LittleEndian)
+		msgParser.SetZeroCopy(client.ZeroCopyRead)
+		client.MsgFramer = msgParser
+	}
+	client.ZeroCopyRead = initZeroCopy(client.MsgFramer, client.ZeroCopyRead)
 }
 
 func (client *TCPClient) dial() net.Conn {
@@ -100,7 +125,7 @@ reconnect:
 	client.conns[conn] = struct{}{}
 	client.Unlock()
 
-	tcpConn := newTCPConn(conn, client.PendingWriteNum, client.msgParser)
+	tcpConn := newTCPConn(conn, client.PendingWriteNum, client.WriteOverflow, client.WriteTimeout, client.MaxWriteBatch, client.ReadBufferSize, client.MsgFramer)
 	agent := client.NewAgent(tcpConn)
 	agent.Run()
 
@@ -4,50 +4,115 @@ import (
 	"github.com/name5566/leaf/log"
 	"net"
 	"sync"
+	"time"
 )
 
 type ConnSet map[net.Conn]struct{}
 
+// a queued write, the zero value closes the conn
+type tcpWrite struct {
+	b []byte
+	// from GetBuffer, released to the pool once written
+	pooled bool
+}
+
+func (w tcpWrite) release() {
+	if w.pooled {
+		PutBuffer(w.b)
+	}
+}
+
 type TCPConn struct {
 	sync.Mutex
 	conn      net.Conn
-	writeChan chan []byte
+	reader    *MsgReader
+	writeChan chan tcpWrite
 	closeFlag bool
-	msgParser *MsgParser
+	msgFramer MsgFramer
+
+	overflowPolicy  OverflowPolicy
+	overflowTimeout time.Duration
+	waiters         *writeWaiters
 }
 
-func newTCPConn(conn net.Conn, pendingWriteNum int, msgParser *MsgParser) *TCPConn {
+func newTCPConn(conn net.Conn, pendingWriteNum int, overflowPolicy OverflowPolicy, overflowTimeout time.Duration, maxWriteBatch int, readBufferSize int, msgFramer MsgFramer) *TCPConn {
 	tcpConn := new(TCPConn)
 	tcpConn.conn = conn
-	tcpConn.writeChan = make(chan []byte, pendingWriteNum)
-	tcpConn.msgParser = msgParser
+	tcpConn.reader = NewMsgReader(countingReader{conn, tcpMetrics.in}, readBufferSize)
+	tcpConn.writeChan = make(chan tcpWrite, pendingWriteNum)
+	tcpConn.msgFramer = msgFramer
+	tcpConn.overflowPolicy = overflowPolicy
+	tcpConn.overflowTimeout = overflowTimeout
+	tcpConn.waiters = newWriteWaiters()
 
 	go func() {
-		for b := range tcpConn.writeChan {
-			if b == nil {
-				break
-			}
-
-			_, err := conn.Write(b)
-			if err != nil {
-				break
-			}
-		}
+		tcpConn.writeLoop(maxWriteBatch)
 
 		conn.Close()
 		tcpConn.Lock()
-		tcpConn.closeFlag = true
+		if !tcpConn.closeFlag {
+			tcpConn.waiters.release()
+			tcpConn.closeFlag = true
+		}
 		tcpConn.Unlock()
 	}()
 
 	return tcpConn
 }
 
+// the queued messages are coalesced into one vectored write (writev)
+// of at most maxWriteBatch buffers, the pooled buffers are released once
+// written
+func (tcpConn *TCPConn) writeLoop(maxWriteBatch int) {
+	batch := make([]tcpWrite, 0, maxWriteBatch)
+	bufs := make(net.Buffers, 0, maxWriteBatch)
+
+	for w := range tcpConn.writeChan {
+		if w.b == nil {
+			return
+		}
+		batch = append(batch[:0], w)
+
+		closing := false
+	drain:
+		for len(batch) < maxWriteBatch {
+			select {
+			case w := <-tcpConn.writeChan:
+				if w.b == nil {
+					closing = true
+					break drain
+				}
+				batch = append(batch, w)
+			default:
+				break drain
+			}
+		}
+
+		// WriteTo consumes bufs, batch keeps the buffers for the pool
+		bufs = bufs[:0]
+		for i := range batch {
+			bufs = append(bufs, batch[i].b)
+		}
+		n, err := bufs.WriteTo(tcpConn.conn)
+		tcpMetrics.out.Add(float64(n))
+		for i := range batch {
+			batch[i].release()
+			batch[i] = tcpWrite{}
+		}
+		if err != nil || closing {
+			return
+		}
+	}
+}
+
 func (tcpConn *TCPConn) doDestroy() {
-	tcpConn.conn.(*net.TCPConn).SetLinger(0)
+	if conn, ok := tcpConn.conn.(*net.TCPConn); ok {
+		conn.SetLinger(0)
+	}
 	tcpConn.conn.Close()
 
 	if !tcpConn.closeFlag {
+		tcpConn.waiters.release()
 		close(tcpConn.writeChan)
 		tcpConn.closeFlag = true
 	}
@@ -67,33 +132,87 @@ func (tcpConn *TCPConn) Close() {
 		return
 	}
 
-	tcpConn.doWrite(nil)
+	// the close marker is never dropped
+	if len(tcpConn.writeChan) == cap(tcpConn.writeChan) {
+		log.Debug("close conn: channel full")
+		tcpConn.doDestroy()
+		return
+	}
+
+	tcpConn.waiters.release()
+	tcpConn.writeChan <- tcpWrite{}
 	tcpConn.closeFlag = true
 }
 
-func (tcpConn *TCPConn) doWrite(b []byte) {
-	if len(tcpConn.writeChan) == cap(tcpConn.writeChan) {
+// w is queued as in pushWrite
+func (tcpConn *TCPConn) doWrite(w tcpWrite) error {
+	select {
+	case tcpConn.writeChan <- w:
+		return nil
+	default:
+	}
+
+	err := overflow(tcpConn.overflowPolicy, tcpConn.overflowTimeout, tcpConn, tcpConn.waiters, func() {
+		select {
+		case old := <-tcpConn.writeChan:
+			old.release()
+		default:
+		}
+		tcpConn.writeChan <- w
+	}, func(timeout <-chan time.Time, done <-chan struct{}) bool {
+		select {
+		case tcpConn.writeChan <- w:
+			return true
+		case <-timeout:
+		case <-done:
+		}
+		return false
+	})
+	if err == ErrChanFull {
 		log.Debug("close conn: channel full")
 		tcpConn.doDestroy()
-		return
 	}
 
-	tcpConn.writeChan <- b
+	return err
 }
 
-// b must not be modified by the others goroutines
+// b is written as is, without a copy, it must not be modified by the others
+// goroutines, the errors of the write overflow policy are not reported, see
+// WriteMsg
 func (tcpConn *TCPConn) Write(b []byte) {
+	if b == nil {
+		return
+	}
+
+	tcpConn.doLockedWrite(tcpWrite{b: b})
+}
+
+// b must come from GetBuffer, the conn takes the ownership of b
+func (tcpConn *TCPConn) write(b []byte) error {
+	return tcpConn.doLockedWrite(tcpWrite{b: b, pooled: true})
+}
+
+func (tcpConn *TCPConn) doLockedWrite(w tcpWrite) error {
 	tcpConn.Lock()
 	defer tcpConn.Unlock()
-	if tcpConn.closeFlag || b == nil {
-		return
+	if tcpConn.closeFlag {
+		w.release()
+		return nil
 	}
 
-	tcpConn.doWrite(b)
+	err := tcpConn.doWrite(w)
+	if err != nil {
+		w.release()
+	}
+	if err == errConnClosed {
+		return nil
+	}
+	return err
 }
 
+// goroutine not safe
 func (tcpConn *TCPConn) Read(b []byte) (int, error) {
-	return tcpConn.conn.Read(b)
+	return tcpConn.reader.Read(b)
 }
 
 func (tcpConn *TCPConn) LocalAddr() net.Addr {
@@ -104,10 +223,16 @@ func (tcpConn *TCPConn) RemoteAddr() net.Addr {
 	return tcpConn.conn.RemoteAddr()
 }
 
+// goroutine not safe
 func (tcpConn *TCPConn) ReadMsg() ([]byte, error) {
-	return tcpConn.msgParser.Read(tcpConn)
+	tcpConn.reader.Release()
+	return tcpConn.msgFramer.ReadMsg(tcpConn.reader)
 }
 
 func (tcpConn *TCPConn) WriteMsg(args ...[]byte) error {
-	return tcpConn.msgParser.Write(tcpConn, args...)
+	msg, err := tcpConn.msgFramer.FrameMsg(args...)
+	if err != nil {
+		return err
+	}
+	return tcpConn.write(msg)
 }
@@ -0,0 +1,459 @@
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/name5566/leaf/log"
+	"hash/crc32"
+	"io"
+	"math"
+)
+
+// splits the TCP stream into messages, MsgParser is the default one
+type MsgFramer interface {
+	// goroutine safe
+	// reads one message from the reader of a connection
+	ReadMsg(r *MsgReader) ([]byte, error)
+	// goroutine safe
+	// returns the frame of the message made of args, the connection writes
+	// the frame and releases it with PutBuffer, so it should come from
+	// GetBuffer
+	FrameMsg(args ...[]byte) ([]byte, error)
+}
+
+// the buffered reader of a connection, a MsgFramer reads the stream with the
+// methods of bufio.Reader and ReadData
+type MsgReader struct {
+	*bufio.Reader
+	borrowed []byte
+}
+
+func NewMsgReader(rd io.Reader, size int) *MsgReader {
+	return &MsgReader{Reader: bufio.NewReaderSize(rd, size)}
+}
+
+// goroutine not safe
+// reads n bytes of message data, see MsgParser.SetZeroCopy for the meaning
+// of zeroCopy
+func (r *MsgReader) ReadData(n int, zeroCopy bool) ([]byte, error) {
+	if zeroCopy && n <= r.Size() {
+		data, err := r.Peek(n)
+		if err != nil {
+			return nil, err
+		}
+		r.Discard(n)
+		return data, nil
+	}
+
+	data := GetBuffer(n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		PutBuffer(data)
+		return nil, err
+	}
+
+	// larger than the read buffer, released by the next read
+	if zeroCopy {
+		r.Lend(data)
+	}
+
+	return data, nil
+}
+
+// goroutine not safe
+// data, from GetBuffer, is released to the pool by the next Release, for
+// the data a MsgFramer returns in zero-copy mode
+func (r *MsgReader) Lend(data []byte) {
+	PutBuffer(r.borrowed)
+	r.borrowed = data
+}
+
+// goroutine not safe
+// releases the data lent, called by the connection before reading a message
+func (r *MsgReader) Release() {
+	if r.borrowed != nil {
+		PutBuffer(r.borrowed)
+		r.borrowed = nil
+	}
+}
+
+// A MsgFramer may implement ZeroCopyFramer to read in zero-copy mode, see
+// MsgParser.SetZeroCopy, TCPServer and TCPClient turn it on for ZeroCopyRead
+type ZeroCopyFramer interface {
+	// It's dangerous to call the method on reading or writing
+	SetZeroCopy(zeroCopy bool)
+	// goroutine safe
+	ZeroCopy() bool
+}
+
+// goroutine safe
+func ZeroCopy(msgFramer MsgFramer) bool {
+	z, ok := msgFramer.(ZeroCopyFramer)
+	return ok && z.ZeroCopy()
+}
+
+// the zero-copy mode of msgFramer is turned on if zeroCopy, the mode of
+// msgFramer is returned
+func initZeroCopy(msgFramer MsgFramer, zeroCopy bool) bool {
+	if !zeroCopy {
+		return ZeroCopy(msgFramer)
+	}
+	z, ok := msgFramer.(ZeroCopyFramer)
+	if !ok {
+		log.Infof("%T has no zero-copy mode, zero-copy read disabled", msgFramer)
+		return false
+	}
+	z.SetZeroCopy(true)
+	return true
+}
+
+func checkMsgLen(msgLen uint32, minMsgLen uint32, maxMsgLen uint32) error {
+	if msgLen > maxMsgLen {
+		return errors.New("message too long")
+	} else if msgLen < minMsgLen {
+		return errors.New("message too short")
+	}
+	return nil
+}
+
+func argsLen(args [][]byte) uint32 {
+	var msgLen uint32
+	for i := 0; i < len(args); i++ {
+		msgLen += uint32(len(args[i]))
+	}
+	return msgLen
+}
+
+func copyArgs(msg []byte, args [][]byte) {
+	l := 0
+	for i := 0; i < len(args); i++ {
+		copy(msg[l:], args[i])
+		l += len(args[i])
+	}
+}
+
+// ---------------------
+// | uvarint len | data |
+// ---------------------
+type VarintParser struct {
+	minMsgLen uint32
+	maxMsgLen uint32
+	zeroCopy  bool
+}
+
+func NewVarintParser() *VarintParser {
+	p := new(VarintParser)
+	p.minMsgLen = 1
+	p.maxMsgLen = 4096
+
+	return p
+}
+
+// It's dangerous to call the method on reading or writing
+func (p *VarintParser) SetMsgLen(minMsgLen uint32, maxMsgLen uint32) {
+	if minMsgLen != 0 {
+		p.minMsgLen = minMsgLen
+	}
+	if maxMsgLen != 0 {
+		p.maxMsgLen = maxMsgLen
+	}
+}
+
+// It's dangerous to call the method on reading or writing
+// see MsgParser.SetZeroCopy
+func (p *VarintParser) SetZeroCopy(zeroCopy bool) {
+	p.zeroCopy = zeroCopy
+}
+
+// goroutine safe
+func (p *VarintParser) ZeroCopy() bool {
+	return p.zeroCopy
+}
+
+// goroutine safe
+func (p *VarintParser) ReadMsg(r *MsgReader) ([]byte, error) {
+	msgLen, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if msgLen > math.MaxUint32 {
+		return nil, errors.New("message too long")
+	}
+	if err := checkMsgLen(uint32(msgLen), p.minMsgLen, p.maxMsgLen); err != nil {
+		return nil, err
+	}
+
+	return r.ReadData(int(msgLen), p.zeroCopy)
+}
+
+// goroutine safe
+func (p *VarintParser) FrameMsg(args ...[]byte) ([]byte, error) {
+	msgLen := argsLen(args)
+	if err := checkMsgLen(msgLen, p.minMsgLen, p.maxMsgLen); err != nil {
+		return nil, err
+	}
+
+	var bufMsgLen [binary.MaxVarintLen32]byte
+	n := binary.PutUvarint(bufMsgLen[:], uint64(msgLen))
+
+	msg := GetBuffer(n + int(msgLen))
+	copy(msg, bufMsgLen[:n])
+	copyArgs(msg[n:], args)
+
+	return msg, nil
+}
+
+// ----------------
+// | data | delim |
+// ----------------
+// e.g. newline-delimited text, the data must not contain the delimiter
+// empty messages are skipped on reading, and with a '\n' delimiter a '\r'
+// before it is dropped (CRLF)
+type DelimiterParser struct {
+	delim     byte
+	minMsgLen uint32
+	maxMsgLen uint32
+	zeroCopy  bool
+}
+
+func NewDelimiterParser(delim byte) *DelimiterParser {
+	p := new(DelimiterParser)
+	p.delim = delim
+	p.minMsgLen = 1
+	p.maxMsgLen = 4096
+
+	return p
+}
+
+// It's dangerous to call the method on reading or writing
+func (p *DelimiterParser) SetMsgLen(minMsgLen uint32, maxMsgLen uint32) {
+	if minMsgLen != 0 {
+		p.minMsgLen = minMsgLen
+	}
+	if maxMsgLen != 0 {
+		p.maxMsgLen = maxMsgLen
+	}
+}
+
+// It's dangerous to call the method on reading or writing
+// see MsgParser.SetZeroCopy
+func (p *DelimiterParser) SetZeroCopy(zeroCopy bool) {
+	p.zeroCopy = zeroCopy
+}
+
+// goroutine safe
+func (p *DelimiterParser) ZeroCopy() bool {
+	return p.zeroCopy
+}
+
+// goroutine safe
+func (p *DelimiterParser) ReadMsg(r *MsgReader) ([]byte, error) {
+	line, err := r.ReadSlice(p.delim)
+	for err == nil && len(p.trim(line)) == 0 {
+		line, err = r.ReadSlice(p.delim)
+	}
+
+	// longer than the read buffer
+	var msgData []byte
+	for err == bufio.ErrBufferFull {
+		if uint32(len(msgData)+len(line)) > p.maxMsgLen {
+			PutBuffer(msgData)
+			return nil, errors.New("message too long")
+		}
+		msgData = appendBuffer(msgData, line)
+		line, err = r.ReadSlice(p.delim)
+	}
+	if err != nil {
+		PutBuffer(msgData)
+		return nil, err
+	}
+
+	if msgData == nil {
+		line = p.trim(line)
+		if err := checkMsgLen(uint32(len(line)), p.minMsgLen, p.maxMsgLen); err != nil {
+			return nil, err
+		}
+		if p.zeroCopy {
+			return line, nil
+		}
+		return appendBuffer(nil, line), nil
+	}
+
+	// the '\r' may end the previous slice
+	msgData = p.trim(appendBuffer(msgData, line))
+	if err := checkMsgLen(uint32(len(msgData)), p.minMsgLen, p.maxMsgLen); err != nil {
+		PutBuffer(msgData)
+		return nil, err
+	}
+	if p.zeroCopy {
+		r.Lend(msgData)
+	}
+	return msgData, nil
+}
+
+// the line without its delimiter and the '\r' of a CRLF
+func (p *DelimiterParser) trim(line []byte) []byte {
+	line = line[:len(line)-1]
+	if p.delim == '\n' && len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	return line
+}
+
+// goroutine safe
+func (p *DelimiterParser) FrameMsg(args ...[]byte) ([]byte, error) {
+	msgLen := argsLen(args)
+	if err := checkMsgLen(msgLen, p.minMsgLen, p.maxMsgLen); err != nil {
+		return nil, err
+	}
+	for i := 0; i < len(args); i++ {
+		if bytes.IndexByte(args[i], p.delim) >= 0 {
+			return nil, errors.New("message contains delimiter")
+		}
+	}
+
+	msg := GetBuffer(int(msgLen) + 1)
+	copyArgs(msg, args)
+	msg[msgLen] = p.delim
+
+	return msg, nil
+}
+
+// appends b to a pooled buffer
+func appendBuffer(buf []byte, b []byte) []byte {
+	if len(buf)+len(b) <= cap(buf) {
+		return append(buf, b...)
+	}
+
+	newBuf := GetBuffer(len(buf) + len(b))
+	copy(newBuf, buf)
+	copy(newBuf[len(buf):], b)
+	PutBuffer(buf)
+	return newBuf
+}
+
+// --------------------------------------
+// | len | flags | crc32 (IEEE) | data |
+// --------------------------------------
+// len is 4 bytes, flags 2 bytes, the checksum covers the data only
+type HeaderParser struct {
+	minMsgLen    uint32
+	maxMsgLen    uint32
+	littleEndian bool
+	zeroCopy     bool
+	flags        uint16
+	checkFlags   func(flags uint16) error
+}
+
+const headerLen = 10
+
+func NewHeaderParser() *HeaderParser {
+	p := new(HeaderParser)
+	p.minMsgLen = 1
+	p.maxMsgLen = 4096
+	p.littleEndian = false
+
+	return p
+}
+
+// It's dangerous to call the method on reading or writing
+func (p *HeaderParser) SetMsgLen(minMsgLen uint32, maxMsgLen uint32) {
+	if minMsgLen != 0 {
+		p.minMsgLen = minMsgLen
+	}
+	if maxMsgLen != 0 {
+		p.maxMsgLen = maxMsgLen
+	}
+}
+
+// It's dangerous to call the method on reading or writing
+func (p *HeaderParser) SetByteOrder(littleEndian bool) {
+	p.littleEndian = littleEndian
+}
+
+// It's dangerous to call the method on reading or writing
+// see MsgParser.SetZeroCopy
+func (p *HeaderParser) SetZeroCopy(zeroCopy bool) {
+	p.zeroCopy = zeroCopy
+}
+
+// goroutine safe
+func (p *HeaderParser) ZeroCopy() bool {
+	return p.zeroCopy
+}
+
+// It's dangerous to call the method on reading or writing
+// flags written with every message
+func (p *HeaderParser) SetFlags(flags uint16) {
+	p.flags = flags
+}
+
+// It's dangerous to call the method on reading or writing
+// checkFlags is called with the flags of every message read, a message is
+// rejected if it returns an error
+func (p *HeaderParser) SetFlagsChecker(checkFlags func(flags uint16) error) {
+	p.checkFlags = checkFlags
+}
+
+func (p *HeaderParser) byteOrder() binary.ByteOrder {
+	if p.littleEndian {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}
+
+// goroutine safe
+func (p *HeaderParser) ReadMsg(r *MsgReader) ([]byte, error) {
+	header, err := r.Peek(headerLen)
+	if err != nil {
+		return nil, err
+	}
+
+	order := p.byteOrder()
+	msgLen := order.Uint32(header)
+	flags := order.Uint16(header[4:])
+	checksum := order.Uint32(header[6:])
+	r.Discard(headerLen)
+
+	if err := checkMsgLen(msgLen, p.minMsgLen, p.maxMsgLen); err != nil {
+		return nil, err
+	}
+	if p.checkFlags != nil {
+		if err := p.checkFlags(flags); err != nil {
+			return nil, err
+		}
+	}
+
+	msgData, err := r.ReadData(int(msgLen), p.zeroCopy)
+	if err != nil {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(msgData) != checksum {
+		// in zero-copy mode, msgData is released by the next read
+		if !p.zeroCopy {
+			PutBuffer(msgData)
+		}
+		return nil, errors.New("message checksum mismatch")
+	}
+
+	return msgData, nil
+}
+
+// goroutine safe
+func (p *HeaderParser) FrameMsg(args ...[]byte) ([]byte, error) {
+	msgLen := argsLen(args)
+	if err := checkMsgLen(msgLen, p.minMsgLen, p.maxMsgLen); err != nil {
+		return nil, err
+	}
+
+	msg := GetBuffer(headerLen + int(msgLen))
+	copyArgs(msg[headerLen:], args)
+
+	order := p.byteOrder()
+	order.PutUint32(msg, msgLen)
+	order.PutUint16(msg[4:], p.flags)
+	order.PutUint32(msg[6:], crc32.ChecksumIEEE(msg[headerLen:]))
+
+	return msg, nil
+}
@@ -0,0 +1,114 @@
+package network
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testMsgFramer(t *testing.T, name string, msgFramer MsgFramer) {
+	c1, c2 := net.Pipe()
+	w := newTCPConn(c1, 16, OverflowBlock, time.Second, 4, 16, msgFramer)
+	r := newTCPConn(c2, 16, OverflowBlock, time.Second, 4, 16, msgFramer)
+	defer w.Destroy()
+	defer r.Destroy()
+
+	msgs := [][]byte{
+		[]byte("a"),
+		bytes.Repeat([]byte("b"), 15),
+		bytes.Repeat([]byte("c"), 300),
+	}
+	go func() {
+		for _, msg := range msgs {
+			if err := w.WriteMsg(msg[:1], msg[1:]); err != nil {
+				t.Errorf("%v: write error: %v", name, err)
+			}
+		}
+	}()
+
+	for _, msg := range msgs {
+		data, err := r.ReadMsg()
+		if err != nil {
+			t.Fatalf("%v: read error: %v", name, err)
+		}
+		if !bytes.Equal(data, msg) {
+			t.Fatalf("%v: got %q, want %q", name, data, msg)
+		}
+	}
+}
+
+func TestMsgFramers(t *testing.T) {
+	for _, zeroCopy := range []bool{false, true} {
+		msgParser := NewMsgParser()
+		msgParser.SetZeroCopy(zeroCopy)
+		testMsgFramer(t, "len", msgParser)
+
+		varintParser := NewVarintParser()
+		varintParser.SetZeroCopy(zeroCopy)
+		testMsgFramer(t, "varint", varintParser)
+
+		delimiterParser := NewDelimiterParser('\n')
+		delimiterParser.SetZeroCopy(zeroCopy)
+		testMsgFramer(t, "delimiter", delimiterParser)
+
+		headerParser := NewHeaderParser()
+		headerParser.SetFlags(7)
+		headerParser.SetZeroCopy(zeroCopy)
+		testMsgFramer(t, "header", headerParser)
+	}
+}
+
+func TestMsgFramerReader(t *testing.T) {
+	msgFramer := NewVarintParser()
+	var stream []byte
+	for _, msg := range []string{"a", "bc"} {
+		frame, err := msgFramer.FrameMsg([]byte(msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream = append(stream, frame...)
+	}
+
+	r := NewMsgReader(bytes.NewReader(stream), 16)
+	for _, msg := range []string{"a", "bc"} {
+		data, err := msgFramer.ReadMsg(r)
+		if err != nil || string(data) != msg {
+			t.Fatalf("got %q, %v, want %q", data, err, msg)
+		}
+	}
+}
+
+func TestDelimiterParserLines(t *testing.T) {
+	long := strings.Repeat("x", 15)
+	stream := long + "\r\n\na\r\n\r\n\nb\nc\rd\n"
+	for _, zeroCopy := range []bool{false, true} {
+		msgFramer := NewDelimiterParser('\n')
+		msgFramer.SetZeroCopy(zeroCopy)
+
+		// the '\r' of the long line ends the full read buffer
+		r := NewMsgReader(strings.NewReader(stream), 16)
+		for _, msg := range []string{long, "a", "b", "c\rd"} {
+			r.Release()
+			data, err := msgFramer.ReadMsg(r)
+			if err != nil || string(data) != msg {
+				t.Fatalf("zero-copy %v: got %q, %v, want %q", zeroCopy, data, err, msg)
+			}
+		}
+		if _, err := msgFramer.ReadMsg(r); err != io.EOF {
+			t.Fatalf("zero-copy %v: got %v, want EOF", zeroCopy, err)
+		}
+	}
+}
+
+func TestHeaderParserChecksum(t *testing.T) {
+	stream := []byte{0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 'x'}
+	tcpConn := newTCPConn(&replayConn{stream: stream}, 1, OverflowDisconnect, 0, 1, 64, NewHeaderParser())
+	defer tcpConn.Close()
+
+	if _, err := tcpConn.ReadMsg(); err == nil {
+		t.Fatal("corrupted message accepted")
+	}
+}
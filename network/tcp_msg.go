@@ -3,7 +3,6 @@ package network
 import (
 	"encoding/binary"
 	"errors"
-	"io"
 	"math"
 )
 
@@ -15,6 +14,7 @@ type MsgParser struct {
 	minMsgLen    uint32
 	maxMsgLen    uint32
 	littleEndian bool
+	zeroCopy     bool
 }
 
 func NewMsgParser() *MsgParser {
@@ -61,13 +61,28 @@ func (p *MsgParser) SetByteOrder(littleEndian bool) {
 	p.littleEndian = littleEndian
 }
 
+// It's dangerous to call the method on reading or writing
+//
+// In zero-copy mode, Read returns a slice of the connection's read buffer
+// instead of a copy. The slice is only valid until the next Read on the same
+// connection, so it must not be kept or modified (see DataReleaser).
+func (p *MsgParser) SetZeroCopy(zeroCopy bool) {
+	p.zeroCopy = zeroCopy
+}
+
 // goroutine safe
-func (p *MsgParser) Read(conn *TCPConn) ([]byte, error) {
-	var b [4]byte
-	bufMsgLen := b[:p.lenMsgLen]
+func (p *MsgParser) ZeroCopy() bool {
+	return p.zeroCopy
+}
 
+// goroutine safe
+// unless in zero-copy mode, the returned data comes from GetBuffer and the
+// caller may release it to the pool with PutBuffer once it is no longer
+// referenced
+func (p *MsgParser) ReadMsg(r *MsgReader) ([]byte, error) {
 	// read len
-	if _, err := io.ReadFull(conn, bufMsgLen); err != nil {
+	bufMsgLen, err := r.Peek(p.lenMsgLen)
+	if err != nil {
 		return nil, err
 	}
 
@@ -98,16 +113,12 @@ func (p *MsgParser) Read(conn *TCPConn) ([]byte, error) {
 	}
 
 	// data
-	msgData := make([]byte, msgLen)
-	if _, err := io.ReadFull(conn, msgData); err != nil {
-		return nil, err
-	}
-
-	return msgData, nil
+	r.Discard(p.lenMsgLen)
+	return r.ReadData(int(msgLen), p.zeroCopy)
 }
 
 // goroutine safe
-func (p *MsgParser) Write(conn *TCPConn, args ...[]byte) error {
+func (p *MsgParser) FrameMsg(args ...[]byte) ([]byte, error) {
 	// get len
 	var msgLen uint32
 	for i := 0; i < len(args); i++ {
@@ -116,12 +127,12 @@ func (p *MsgParser) Write(conn *TCPConn, args ...[]byte) error {
 
 	// check len
 	if msgLen > p.maxMsgLen {
-		return errors.New("message too long")
+		return nil, errors.New("message too long")
 	} else if msgLen < p.minMsgLen {
-		return errors.New("message too short")
+		return nil, errors.New("message too short")
 	}
 
-	msg := make([]byte, uint32(p.lenMsgLen)+msgLen)
+	msg := GetBuffer(p.lenMsgLen + int(msgLen))
 
 	// write len
 	switch p.lenMsgLen {
@@ -148,7 +159,21 @@ func (p *MsgParser) Write(conn *TCPConn, args ...[]byte) error {
 		l += len(args[i])
 	}
 
-	conn.Write(msg)
+	return msg, nil
+}
 
-	return nil
+// goroutine not safe
+// see TCPConn.ReadMsg
+func (p *MsgParser) Read(conn *TCPConn) ([]byte, error) {
+	conn.reader.Release()
+	return p.ReadMsg(conn.reader)
+}
+
+// goroutine safe
+func (p *MsgParser) Write(conn *TCPConn, args ...[]byte) error {
+	msg, err := p.FrameMsg(args...)
+	if err != nil {
+		return err
+	}
+	return conn.write(msg)
 }
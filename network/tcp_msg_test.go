@@ -0,0 +1,189 @@
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// number of write syscalls (write, writev, ...) issued by the process,
+// -1 if /proc/self/io is not available
+func syscw() int64 {
+	f, err := os.Open("/proc/self/io")
+	if err != nil {
+		return -1
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if strings.HasPrefix(s.Text(), "syscw:") {
+			n, err := strconv.ParseInt(strings.TrimSpace(s.Text()[6:]), 10, 64)
+			if err != nil {
+				return -1
+			}
+			return n
+		}
+	}
+	return -1
+}
+
+func benchmarkTCPConnWrite(b *testing.B, maxWriteBatch int) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer ln.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		io.Copy(ioutil.Discard, conn)
+		conn.Close()
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	tcpConn := newTCPConn(conn, 4096, OverflowBlock, time.Minute, maxWriteBatch, 4096, NewMsgParser())
+	id := []byte{0, 1}
+	data := bytes.Repeat([]byte{'x'}, 62)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	n := syscw()
+	for i := 0; i < b.N; i++ {
+		if err := tcpConn.WriteMsg(id, data); err != nil {
+			b.Fatal(err)
+		}
+	}
+	tcpConn.Close()
+	<-done
+	b.StopTimer()
+
+	if n >= 0 {
+		b.ReportMetric(float64(syscw()-n)/float64(b.N), "syscw/op")
+	}
+}
+
+func BenchmarkTCPConnWrite(b *testing.B) {
+	b.Run("batch=1", func(b *testing.B) { benchmarkTCPConnWrite(b, 1) })
+	b.Run("batch=64", func(b *testing.B) { benchmarkTCPConnWrite(b, 64) })
+}
+
+// replays the same framed stream forever
+type replayConn struct {
+	net.Conn
+	stream []byte
+	off    int
+	reads  int
+}
+
+func (c *replayConn) Read(b []byte) (int, error) {
+	c.reads++
+	n := 0
+	for n < len(b) {
+		m := copy(b[n:], c.stream[c.off:])
+		c.off = (c.off + m) % len(c.stream)
+		n += m
+	}
+	return n, nil
+}
+
+func (c *replayConn) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (c *replayConn) Close() error {
+	return nil
+}
+
+func benchmarkMsgParserRead(b *testing.B, release bool, zeroCopy bool) {
+	stream := append([]byte{0, 64}, bytes.Repeat([]byte{'x'}, 64)...)
+	conn := &replayConn{stream: stream}
+	msgParser := NewMsgParser()
+	msgParser.SetZeroCopy(zeroCopy)
+	tcpConn := newTCPConn(conn, 1, OverflowDisconnect, 0, 1, 4096, msgParser)
+	defer tcpConn.Close()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		data, err := tcpConn.ReadMsg()
+		if err != nil {
+			b.Fatal(err)
+		}
+		if release {
+			PutBuffer(data)
+		}
+	}
+	b.StopTimer()
+
+	// the unbuffered parser issued two reads per message
+	b.ReportMetric(float64(conn.reads)/float64(b.N), "reads/op")
+}
+
+// the read of MsgParser before the pool and the buffered reader, a
+// make per message and two reads of the conn
+func benchmarkMakeRead(b *testing.B) {
+	stream := append([]byte{0, 64}, bytes.Repeat([]byte{'x'}, 64)...)
+	conn := &replayConn{stream: stream}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var bufMsgLen [2]byte
+		if _, err := io.ReadFull(conn, bufMsgLen[:]); err != nil {
+			b.Fatal(err)
+		}
+		data := make([]byte, int(bufMsgLen[0])<<8|int(bufMsgLen[1]))
+		if _, err := io.ReadFull(conn, data); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+
+	b.ReportMetric(float64(conn.reads)/float64(b.N), "reads/op")
+}
+
+func BenchmarkMsgParserRead(b *testing.B) {
+	b.Run("make", benchmarkMakeRead)
+	b.Run("alloc", func(b *testing.B) { benchmarkMsgParserRead(b, false, false) })
+	b.Run("pool", func(b *testing.B) { benchmarkMsgParserRead(b, true, false) })
+	b.Run("zerocopy", func(b *testing.B) { benchmarkMsgParserRead(b, false, true) })
+}
+
+func TestMsgParserZeroCopy(t *testing.T) {
+	small := bytes.Repeat([]byte{'s'}, 10)
+	large := bytes.Repeat([]byte{'l'}, 100)
+	stream := append(append([]byte{0, 10}, small...), append([]byte{0, 100}, large...)...)
+
+	msgParser := NewMsgParser()
+	msgParser.SetZeroCopy(true)
+	tcpConn := newTCPConn(&replayConn{stream: stream}, 1, OverflowDisconnect, 0, 1, 64, msgParser)
+	defer tcpConn.Close()
+
+	for i := 0; i < 4; i++ {
+		for _, want := range [][]byte{small, large} {
+			data, err := tcpConn.ReadMsg()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, want) {
+				t.Fatalf("got %q, want %q", data, want)
+			}
+		}
+	}
+}
@@ -11,6 +11,10 @@ type TCPServer struct {
 	Addr            string
 	MaxConnNum      int
 	PendingWriteNum int
+	WriteOverflow   OverflowPolicy
+	WriteTimeout    time.Duration
+	MaxWriteBatch   int
+	ReadBufferSize  int
 	NewAgent        func(*TCPConn) Agent
 	ln              net.Listener
 	conns           ConnSet
@@ -23,7 +27,12 @@ type TCPServer struct {
 	MinMsgLen    uint32
 	MaxMsgLen    uint32
 	LittleEndian bool
-	msgParser    *MsgParser
+	// set to the zero-copy mode of MsgFramer by Start
+	ZeroCopyRead bool
+
+	// overrides the msg parser settings above but ZeroCopyRead, see
+	// ZeroCopyFramer
+	MsgFramer MsgFramer
 }
 
 func (server *TCPServer) Start() {
@@ -45,6 +54,18 @@ func (server *TCPServer) init() {
 		server.PendingWriteNum = 100
 		log.Infof("invalid PendingWriteNum, reset to %v", server.PendingWriteNum)
 	}
+	if server.WriteOverflow == OverflowBlock && server.WriteTimeout <= 0 {
+		server.WriteTimeout = time.Second
+		log.Infof("invalid WriteTimeout, reset to %v", server.WriteTimeout)
+	}
+	if server.MaxWriteBatch <= 0 {
+		server.MaxWriteBatch = 64
+		log.Infof("invalid MaxWriteBatch, reset to %v", server.MaxWriteBatch)
+	}
+	if server.ReadBufferSize <= 0 {
+		server.ReadBufferSize = 4096
+		log.Infof("invalid ReadBufferSize, reset to %v", server.ReadBufferSize)
+	}
 	if server.NewAgent == nil {
 		log.Fatalf("NewAgent must not be nil")
 	}
@@ -53,10 +74,14 @@ func (server *TCPServer) init() {
 	server.conns = make(ConnSet)
 
 	// msg parser
-	msgParser := NewMsgParser()
-	msgParser.SetMsgLen(server.LenMsgLen, server.MinMsgLen, server.MaxMsgLen)
-	msgParser.SetByteOrder(server.LittleEndian)
-	server.msgParser = msgParser
+	if server.MsgFramer == nil {
+		msgParser := NewMsgParser()
+		msgParser.SetMsgLen(server.LenMsgLen, server.MinMsgLen, server.MaxMsgLen)
+		msgParser.SetByteOrder(server.LittleEndian)
+		msgParser.SetZeroCopy(server.ZeroCopyRead)
+		server.MsgFramer = msgParser
+	}
+	server.ZeroCopyRead = initZeroCopy(server.MsgFramer, server.ZeroCopyRead)
 }
 
 func (server *TCPServer) run() {
@@ -93,10 +118,11 @@ func (server *TCPServer) run() {
 		}
 		server.conns[conn] = struct{}{}
 		server.mutexConns.Unlock()
+		tcpMetrics.accepted.Inc()
 
 		server.wgConns.Add(1)
 
-		tcpConn := newTCPConn(conn, server.PendingWriteNum, server.msgParser)
+		tcpConn := newTCPConn(conn, server.PendingWriteNum, server.WriteOverflow, server.WriteTimeout, server.MaxWriteBatch, server.ReadBufferSize, server.MsgFramer)
 		agent := server.NewAgent(tcpConn)
 		go func() {
 			agent.Run()
@@ -106,6 +132,7 @@ func (server *TCPServer) run() {
 			server.mutexConns.Lock()
 			delete(server.conns, conn)
 			server.mutexConns.Unlock()
+			tcpMetrics.closed.Inc()
 			agent.OnClose()
 
 			server.wgConns.Done()
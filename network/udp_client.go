@@ -3,62 +3,130 @@ package network
 import (
 	"github.com/name5566/leaf/log"
 	"net"
+	"sync"
 	"time"
 )
 
 type UDPClient struct {
-	ConnectInterval time.Duration
+	sync.Mutex
 	Addr            string
-	NewAgent        func(conn *UDPConn) Agent
+	ConnectInterval time.Duration
 	PendingWriteNum int
+	WriteOverflow   OverflowPolicy
+	WriteTimeout    time.Duration
+	MaxMsgLen       uint32
+	AutoReconnect   bool
+	NewAgent        func(conn *UDPConn) Agent
+	udpConn         *UDPConn
+	wg              sync.WaitGroup
+	closeFlag       bool
 }
 
 func (client *UDPClient) Start() {
 	client.init()
+
+	client.wg.Add(1)
 	go client.connect()
 }
 
 func (client *UDPClient) init() {
+	client.Lock()
+	defer client.Unlock()
+
+	if client.ConnectInterval <= 0 {
+		client.ConnectInterval = 3 * time.Second
+		log.Infof("invalid ConnectInterval, reset to %v", client.ConnectInterval)
+	}
 	if client.PendingWriteNum <= 0 {
 		client.PendingWriteNum = 100
 		log.Infof("invalid PendingWriteNum, reset to %v", client.PendingWriteNum)
 	}
-	
+	if client.WriteOverflow == OverflowBlock && client.WriteTimeout <= 0 {
+		client.WriteTimeout = time.Second
+		log.Infof("invalid WriteTimeout, reset to %v", client.WriteTimeout)
+	}
+	if client.MaxMsgLen <= 0 {
+		client.MaxMsgLen = 4096
+		log.Infof("invalid MaxMsgLen, reset to %v", client.MaxMsgLen)
+	}
 	if client.NewAgent == nil {
 		log.Fatalf("NewAgent must not be nil")
 	}
+
+	client.closeFlag = false
 }
 
 func (client *UDPClient) dial() *net.UDPConn {
-	udpAddr, err := net.ResolveUDPAddr("udp4", client.Addr)
-	if err != nil{
-		return  nil
-	}
 	for {
-		conn, err := net.DialUDP("udp", nil, udpAddr)
-		if err == nil{
-			return conn
+		udpAddr, err := net.ResolveUDPAddr("udp4", client.Addr)
+		if err == nil {
+			var conn *net.UDPConn
+			conn, err = net.DialUDP("udp", nil, udpAddr)
+			if err == nil {
+				return conn
+			}
+		}
+
+		client.Lock()
+		closeFlag := client.closeFlag
+		client.Unlock()
+		if closeFlag {
+			return nil
 		}
 
 		log.Infof("connect to %v error: %v", client.Addr, err)
 		time.Sleep(client.ConnectInterval)
-		continue
 	}
 }
 
+// the agent must return from Run once ReadMsg fails and UDPConn.Closed
+// reports true, which happens when the client is closed, other read errors
+// concern a single datagram
 func (client *UDPClient) connect() {
+	defer client.wg.Done()
+
+reconnect:
 	conn := client.dial()
 	if conn == nil {
 		return
 	}
-	udpConn := newUDPConn(conn, client.PendingWriteNum)
+
+	udpConn := newUDPConn(conn, client.PendingWriteNum, client.WriteOverflow, client.WriteTimeout, client.MaxMsgLen, udpMetrics)
+
+	client.Lock()
+	if client.closeFlag {
+		client.Unlock()
+		udpConn.Close()
+		return
+	}
+	client.udpConn = udpConn
+	client.Unlock()
+
 	agent := client.NewAgent(udpConn)
 	agent.Run()
 
 	// cleanup
-	conn.Close()
+	udpConn.Close()
+	client.Lock()
+	client.udpConn = nil
+	closeFlag := client.closeFlag
+	client.Unlock()
+	agent.OnClose()
+
+	if client.AutoReconnect && !closeFlag {
+		time.Sleep(client.ConnectInterval)
+		goto reconnect
+	}
 }
 
+// the pending messages are sent before the socket is closed
 func (client *UDPClient) Close() {
+	client.Lock()
+	client.closeFlag = true
+	if client.udpConn != nil {
+		client.udpConn.Close()
+	}
+	client.Unlock()
 
+	client.wg.Wait()
 }
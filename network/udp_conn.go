@@ -1,13 +1,15 @@
 package network
 
 import (
+	"errors"
 	"github.com/name5566/leaf/log"
 	"net"
 	"sync"
+	"time"
 )
 
-type UDPWriteData struct{
-	data []byte
+type UDPWriteData struct {
+	data     []byte
 	userAddr *net.UDPAddr
 }
 
@@ -16,26 +18,44 @@ type UDPConn struct {
 	conn      *net.UDPConn
 	writeChan chan *UDPWriteData
 	closeFlag bool
+	maxMsgLen uint32
+	counters  *connMetrics
+	// reused by ReadMsg
+	readBuf []byte
+
+	overflowPolicy  OverflowPolicy
+	overflowTimeout time.Duration
+	waiters         *writeWaiters
 }
 
-func newUDPConn(conn *net.UDPConn, pendingWriteNum int) *UDPConn {
+// maxMsgLen is the max datagram size, the bytes are counted in counters
+func newUDPConn(conn *net.UDPConn, pendingWriteNum int, overflowPolicy OverflowPolicy, overflowTimeout time.Duration, maxMsgLen uint32, counters *connMetrics) *UDPConn {
 	udpConn := new(UDPConn)
 	udpConn.conn = conn
+	udpConn.maxMsgLen = maxMsgLen
+	udpConn.counters = counters
 	udpConn.writeChan = make(chan *UDPWriteData, pendingWriteNum)
+	udpConn.overflowPolicy = overflowPolicy
+	udpConn.overflowTimeout = overflowTimeout
+	udpConn.waiters = newWriteWaiters()
 
 	go func() {
 		for b := range udpConn.writeChan {
 			if b == nil {
 				break
 			}
-			_, _,err := udpConn.conn.WriteMsgUDP(b.data, nil, b.userAddr)
+			n, _, err := udpConn.conn.WriteMsgUDP(b.data, nil, b.userAddr)
 			if err != nil {
 				continue
 			}
+			counters.out.Add(float64(n))
 		}
 		conn.Close()
 		udpConn.Lock()
-		udpConn.closeFlag = true
+		if !udpConn.closeFlag {
+			udpConn.waiters.release()
+			udpConn.closeFlag = true
+		}
 		udpConn.Unlock()
 	}()
 
@@ -46,11 +66,28 @@ func (udpConn *UDPConn) doDestroy() {
 	udpConn.conn.Close()
 
 	if !udpConn.closeFlag {
+		udpConn.waiters.release()
 		close(udpConn.writeChan)
 		udpConn.closeFlag = true
 	}
 }
 
+func (udpConn *UDPConn) Destroy() {
+	udpConn.Lock()
+	defer udpConn.Unlock()
+
+	udpConn.doDestroy()
+}
+
+// goroutine safe
+// true once closed or destroyed, ReadMsg then fails for good
+func (udpConn *UDPConn) Closed() bool {
+	udpConn.Lock()
+	defer udpConn.Unlock()
+	return udpConn.closeFlag
+}
+
+// the pending messages are sent before the socket is closed
 func (udpConn *UDPConn) Close() {
 	udpConn.Lock()
 	defer udpConn.Unlock()
@@ -58,25 +95,61 @@ func (udpConn *UDPConn) Close() {
 		return
 	}
 
-	udpConn.doWrite(nil)
+	// the close marker is never dropped
+	if len(udpConn.writeChan) == cap(udpConn.writeChan) {
+		log.Debug("close conn: channel full")
+		udpConn.doDestroy()
+		return
+	}
+
+	udpConn.waiters.release()
+	udpConn.writeChan <- nil
 	udpConn.closeFlag = true
 }
 
-func (udpConn *UDPConn) doWrite(data *UDPWriteData) {
-	if len(udpConn.writeChan) == cap(udpConn.writeChan) {
+// data is queued as in pushWrite
+func (udpConn *UDPConn) doWrite(data *UDPWriteData) error {
+	select {
+	case udpConn.writeChan <- data:
+		return nil
+	default:
+	}
+
+	err := overflow(udpConn.overflowPolicy, udpConn.overflowTimeout, udpConn, udpConn.waiters, func() {
+		select {
+		case <-udpConn.writeChan:
+		default:
+		}
+		udpConn.writeChan <- data
+	}, func(timeout <-chan time.Time, done <-chan struct{}) bool {
+		select {
+		case udpConn.writeChan <- data:
+			return true
+		case <-timeout:
+		case <-done:
+		}
+		return false
+	})
+	switch err {
+	case ErrChanFull:
 		log.Debug("close conn: channel full")
 		udpConn.doDestroy()
-		return
+	case errConnClosed:
+		return nil
 	}
 
-	udpConn.writeChan <- data
+	return err
 }
 
+// addr must be nil for a connected socket (UDPClient)
 func (udpConn *UDPConn) WriteMsg(addr *net.UDPAddr, args ...[]byte) error {
 	var msgLen uint32
 	for i := 0; i < len(args); i++ {
 		msgLen += uint32(len(args[i]))
 	}
+	if msgLen > udpConn.maxMsgLen {
+		return errors.New("message too long")
+	}
 
 	msg := make([]byte, uint32(msgLen))
 	l := 0
@@ -85,20 +158,45 @@ func (udpConn *UDPConn) WriteMsg(addr *net.UDPAddr, args ...[]byte) error {
 		l += len(args[i])
 	}
 
-	udpConn.doWrite(&UDPWriteData{
-		data:msg,
-		userAddr:addr,
+	udpConn.Lock()
+	defer udpConn.Unlock()
+	if udpConn.closeFlag {
+		return nil
+	}
+
+	return udpConn.doWrite(&UDPWriteData{
+		data:     msg,
+		userAddr: addr,
 	})
-	
-	return  nil
 }
 
-func (udpConn *UDPConn) ReadMsg() ([]byte, *net.UDPAddr,error) {
-	var buf [1024]byte
-	n, addr, err := udpConn.conn.ReadFromUDP(buf[0:])
-	if err != nil {
-		return nil, nil, err
+// goroutine not safe
+// datagrams longer than the max datagram size are dropped
+func (udpConn *UDPConn) ReadMsg() ([]byte, *net.UDPAddr, error) {
+	// one more byte to detect truncation
+	if udpConn.readBuf == nil {
+		udpConn.readBuf = make([]byte, udpConn.maxMsgLen+1)
 	}
-	return buf[0:n], addr, err
+
+	for {
+		n, addr, err := udpConn.conn.ReadFromUDP(udpConn.readBuf)
+		if err != nil {
+			return nil, nil, err
+		}
+		udpConn.counters.in.Add(float64(n))
+		if uint32(n) > udpConn.maxMsgLen {
+			log.Debugf("message too long from %v", addr)
+			continue
+		}
+		return append([]byte(nil), udpConn.readBuf[:n]...), addr, nil
+	}
+}
+
+func (udpConn *UDPConn) LocalAddr() net.Addr {
+	return udpConn.conn.LocalAddr()
 }
 
+// nil if the socket is not connected
+func (udpConn *UDPConn) RemoteAddr() net.Addr {
+	return udpConn.conn.RemoteAddr()
+}
@@ -3,24 +3,32 @@ package network
 import (
 	"github.com/name5566/leaf/log"
 	"net"
+	"sync"
+	"time"
 )
 
-
 type UDPServer struct {
 	Addr            string
 	NewAgent        func(conn *UDPConn) Agent
 	PendingWriteNum int
+	WriteOverflow   OverflowPolicy
+	WriteTimeout    time.Duration
+	MaxMsgLen       uint32
 	conn            *net.UDPConn
+	udpConn         *UDPConn
+	wg              sync.WaitGroup
 }
 
 func (server *UDPServer) Start() {
 	server.init()
+
+	server.wg.Add(1)
 	go server.run()
 }
 
 func (server *UDPServer) init() {
 	udpAddr, err := net.ResolveUDPAddr("udp4", server.Addr)
-	if err != nil{
+	if err != nil {
 		log.Fatalf("%v", err)
 	}
 	server.conn, err = net.ListenUDP("udp", udpAddr)
@@ -32,20 +40,34 @@ func (server *UDPServer) init() {
 		server.PendingWriteNum = 100
 		log.Infof("invalid PendingWriteNum, reset to %v", server.PendingWriteNum)
 	}
-	
+	if server.MaxMsgLen <= 0 {
+		server.MaxMsgLen = 4096
+		log.Infof("invalid MaxMsgLen, reset to %v", server.MaxMsgLen)
+	}
+	if server.WriteOverflow == OverflowBlock && server.WriteTimeout <= 0 {
+		server.WriteTimeout = time.Second
+		log.Infof("invalid WriteTimeout, reset to %v", server.WriteTimeout)
+	}
 	if server.NewAgent == nil {
 		log.Fatalf("NewAgent must not be nil")
 	}
+
+	server.udpConn = newUDPConn(server.conn, server.PendingWriteNum, server.WriteOverflow, server.WriteTimeout, server.MaxMsgLen, udpMetrics)
 }
 
+// the agent must return from Run once ReadMsg fails and UDPConn.Closed
+// reports true, which happens when the server is closed, other read errors
+// concern a single datagram
 func (server *UDPServer) run() {
-	udpConn := newUDPConn(server.conn, server.PendingWriteNum)
-	agent := server.NewAgent(udpConn)
-	for {
-		agent.Run()
-	}
+	defer server.wg.Done()
+
+	agent := server.NewAgent(server.udpConn)
+	agent.Run()
+	agent.OnClose()
 }
 
-func (server *UDPServer) Close(){
-	server.conn.Close()
+// the pending writes are flushed before the socket is closed
+func (server *UDPServer) Close() {
+	server.udpConn.Close()
+	server.wg.Wait()
 }
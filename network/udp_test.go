@@ -0,0 +1,108 @@
+package network
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+type udpEchoAgent struct {
+	conn *UDPConn
+}
+
+func (a *udpEchoAgent) Run() {
+	for {
+		data, addr, err := a.conn.ReadMsg()
+		if err != nil {
+			return
+		}
+		a.conn.WriteMsg(addr, data)
+	}
+}
+
+func (a *udpEchoAgent) OnClose() {}
+
+type udpClientAgent struct {
+	conn *UDPConn
+	msgs [][]byte
+	err  chan error
+}
+
+func (a *udpClientAgent) Run() {
+	for _, msg := range a.msgs {
+		if err := a.conn.WriteMsg(nil, msg); err != nil {
+			a.err <- err
+			return
+		}
+		data, _, err := a.conn.ReadMsg()
+		if err != nil {
+			a.err <- err
+			return
+		}
+		if !bytes.Equal(data, msg) {
+			a.err <- fmt.Errorf("got %v bytes, want %v", len(data), len(msg))
+			return
+		}
+	}
+	a.err <- nil
+
+	// until closed
+	for {
+		if _, _, err := a.conn.ReadMsg(); err != nil {
+			return
+		}
+	}
+}
+
+func (a *udpClientAgent) OnClose() {}
+
+func TestUDPClient(t *testing.T) {
+	server := new(UDPServer)
+	server.Addr = "127.0.0.1:0"
+	server.MaxMsgLen = 8192
+	server.NewAgent = func(conn *UDPConn) Agent {
+		return &udpEchoAgent{conn: conn}
+	}
+	server.Start()
+	defer server.Close()
+
+	a := &udpClientAgent{err: make(chan error, 1)}
+	for i := 0; i < 5; i++ {
+		a.msgs = append(a.msgs, bytes.Repeat([]byte{byte('a' + i)}, 1+i*2000))
+	}
+
+	client := new(UDPClient)
+	client.Addr = server.udpConn.conn.LocalAddr().String()
+	client.MaxMsgLen = 8192
+	client.AutoReconnect = true
+	client.NewAgent = func(conn *UDPConn) Agent {
+		a.conn = conn
+		return a
+	}
+	client.Start()
+
+	select {
+	case err := <-a.err:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+
+	if err := a.conn.WriteMsg(nil, make([]byte, 8193)); err == nil {
+		t.Fatal("message too long written")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		client.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("close timeout")
+	}
+}
@@ -13,14 +13,22 @@ type WSClient struct {
 	ConnNum          int
 	ConnectInterval  time.Duration
 	PendingWriteNum  int
+	WriteOverflow    OverflowPolicy
+	WriteTimeout     time.Duration
 	MaxMsgLen        uint32
 	HandshakeTimeout time.Duration
+	Subprotocols     []string
+	FrameType        WSFrameType
 	AutoReconnect    bool
 	NewAgent         func(*WSConn) Agent
 	dialer           websocket.Dialer
 	conns            WebsocketConnSet
 	wg               sync.WaitGroup
 	closeFlag        bool
+
+	// see WSServer
+	EnableCompression    bool
+	CompressionThreshold int
 }
 
 func (client *WSClient) Start() {
@@ -56,6 +64,18 @@ func (client *WSClient) init() {
 		client.HandshakeTimeout = 10 * time.Second
 		log.Infof("invalid HandshakeTimeout, reset to %v", client.HandshakeTimeout)
 	}
+	if client.WriteOverflow == OverflowBlock && client.WriteTimeout <= 0 {
+		client.WriteTimeout = time.Second
+		log.Infof("invalid WriteTimeout, reset to %v", client.WriteTimeout)
+	}
+	if client.EnableCompression && client.CompressionThreshold <= 0 {
+		client.CompressionThreshold = 512
+		log.Infof("invalid CompressionThreshold, reset to %v", client.CompressionThreshold)
+	}
+	if client.FrameType != WSFrameBinary && client.FrameType != WSFrameText {
+		client.FrameType = WSFrameBinary
+		log.Infof("invalid FrameType, reset to binary")
+	}
 	if client.NewAgent == nil {
 		log.Fatalf("NewAgent must not be nil")
 	}
@@ -66,7 +86,9 @@ func (client *WSClient) init() {
 	client.conns = make(WebsocketConnSet)
 	client.closeFlag = false
 	client.dialer = websocket.Dialer{
-		HandshakeTimeout: client.HandshakeTimeout,
+		HandshakeTimeout:  client.HandshakeTimeout,
+		Subprotocols:      client.Subprotocols,
+		EnableCompression: client.EnableCompression,
 	}
 }
 
@@ -102,7 +124,7 @@ reconnect:
 	client.conns[conn] = struct{}{}
 	client.Unlock()
 
-	wsConn := newWSConn(conn, client.PendingWriteNum, client.MaxMsgLen)
+	wsConn := newWSConn(conn, client.PendingWriteNum, client.WriteOverflow, client.WriteTimeout, client.MaxMsgLen, client.FrameType, client.CompressionThreshold)
 	agent := client.NewAgent(wsConn)
 	agent.Run()
 
@@ -5,24 +5,52 @@ import (
 	"github.com/gorilla/websocket"
 	"github.com/name5566/leaf/log"
 	"net"
+	"net/http"
 	"sync"
+	"time"
 )
 
 type WebsocketConnSet map[*websocket.Conn]struct{}
 
+type WSFrameType int
+
+const (
+	WSFrameBinary WSFrameType = iota
+	WSFrameText
+	// text frames if the processor implements TextFramer, binary frames
+	// otherwise, only resolved by the gate for every connection, WSServer
+	// and WSClient have no processor and reset it to WSFrameBinary
+	WSFrameAuto
+)
+
 type WSConn struct {
 	sync.Mutex
 	conn      *websocket.Conn
 	writeChan chan []byte
 	maxMsgLen uint32
 	closeFlag bool
+	frameType WSFrameType
+
+	// set by WSHandler
+	request    *http.Request
+	remoteAddr net.Addr
+
+	overflowPolicy  OverflowPolicy
+	overflowTimeout time.Duration
+	waiters         *writeWaiters
 }
 
-func newWSConn(conn *websocket.Conn, pendingWriteNum int, maxMsgLen uint32) *WSConn {
+// the messages shorter than compressionThreshold are not compressed, which
+// only matters if permessage-deflate has been negotiated
+func newWSConn(conn *websocket.Conn, pendingWriteNum int, overflowPolicy OverflowPolicy, overflowTimeout time.Duration, maxMsgLen uint32, frameType WSFrameType, compressionThreshold int) *WSConn {
 	wsConn := new(WSConn)
 	wsConn.conn = conn
 	wsConn.writeChan = make(chan []byte, pendingWriteNum)
 	wsConn.maxMsgLen = maxMsgLen
+	wsConn.frameType = frameType
+	wsConn.overflowPolicy = overflowPolicy
+	wsConn.overflowTimeout = overflowTimeout
+	wsConn.waiters = newWriteWaiters()
 
 	go func() {
 		for b := range wsConn.writeChan {
@@ -30,15 +58,24 @@ func newWSConn(conn *websocket.Conn, pendingWriteNum int, maxMsgLen uint32) *WSC
 				break
 			}
 
-			err := conn.WriteMessage(websocket.BinaryMessage, b)
+			messageType := websocket.BinaryMessage
+			if wsConn.frameType == WSFrameText {
+				messageType = websocket.TextMessage
+			}
+			conn.EnableWriteCompression(len(b) >= compressionThreshold)
+			err := conn.WriteMessage(messageType, b)
 			if err != nil {
 				break
 			}
+			wsMetrics.out.Add(float64(len(b)))
 		}
 
 		conn.Close()
 		wsConn.Lock()
-		wsConn.closeFlag = true
+		if !wsConn.closeFlag {
+			wsConn.waiters.release()
+			wsConn.closeFlag = true
+		}
 		wsConn.Unlock()
 	}()
 
@@ -46,10 +83,13 @@ func newWSConn(conn *websocket.Conn, pendingWriteNum int, maxMsgLen uint32) *WSC
 }
 
 func (wsConn *WSConn) doDestroy() {
-	wsConn.conn.UnderlyingConn().(*net.TCPConn).SetLinger(0)
+	if conn, ok := wsConn.conn.UnderlyingConn().(*net.TCPConn); ok {
+		conn.SetLinger(0)
+	}
 	wsConn.conn.Close()
 
 	if !wsConn.closeFlag {
+		wsConn.waiters.release()
 		close(wsConn.writeChan)
 		wsConn.closeFlag = true
 	}
@@ -69,31 +109,53 @@ func (wsConn *WSConn) Close() {
 		return
 	}
 
-	wsConn.doWrite(nil)
+	// the close marker is never dropped
+	if len(wsConn.writeChan) == cap(wsConn.writeChan) {
+		log.Debug("close conn: channel full")
+		wsConn.doDestroy()
+		return
+	}
+
+	wsConn.waiters.release()
+	wsConn.writeChan <- nil
 	wsConn.closeFlag = true
 }
 
-func (wsConn *WSConn) doWrite(b []byte) {
-	if len(wsConn.writeChan) == cap(wsConn.writeChan) {
+func (wsConn *WSConn) doWrite(b []byte) error {
+	err := pushWrite(wsConn.writeChan, b, wsConn.overflowPolicy, wsConn.overflowTimeout, wsConn, wsConn.waiters, nil)
+	if err == ErrChanFull {
 		log.Debug("close conn: channel full")
 		wsConn.doDestroy()
-		return
+	}
+	if err == errConnClosed {
+		return nil
 	}
 
-	wsConn.writeChan <- b
+	return err
 }
 
 func (wsConn *WSConn) LocalAddr() net.Addr {
 	return wsConn.conn.LocalAddr()
 }
 
+// the client address given by a trusted proxy if any
 func (wsConn *WSConn) RemoteAddr() net.Addr {
+	if wsConn.remoteAddr != nil {
+		return wsConn.remoteAddr
+	}
 	return wsConn.conn.RemoteAddr()
 }
 
+// the upgrade request, nil for a client connection
+// it must not be modified
+func (wsConn *WSConn) Request() *http.Request {
+	return wsConn.request
+}
+
 // goroutine not safe
 func (wsConn *WSConn) ReadMsg() ([]byte, error) {
 	_, b, err := wsConn.conn.ReadMessage()
+	wsMetrics.in.Add(float64(len(b)))
 	return b, err
 }
 
@@ -120,8 +182,7 @@ func (wsConn *WSConn) WriteMsg(args ...[]byte) error {
 
 	// don't copy
 	if len(args) == 1 {
-		wsConn.doWrite(args[0])
-		return nil
+		return wsConn.doWrite(args[0])
 	}
 
 	// merge the args
@@ -132,7 +193,15 @@ func (wsConn *WSConn) WriteMsg(args ...[]byte) error {
 		l += len(args[i])
 	}
 
-	wsConn.doWrite(msg)
+	return wsConn.doWrite(msg)
+}
+
+// the subprotocol negotiated with Sec-WebSocket-Protocol, empty if none
+func (wsConn *WSConn) Subprotocol() string {
+	return wsConn.conn.Subprotocol()
+}
 
-	return nil
+// It's dangerous to call the method after the first WriteMsg
+func (wsConn *WSConn) SetFrameType(frameType WSFrameType) {
+	wsConn.frameType = frameType
 }
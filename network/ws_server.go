@@ -14,20 +14,49 @@ type WSServer struct {
 	Addr            string
 	MaxConnNum      int
 	PendingWriteNum int
+	WriteOverflow   OverflowPolicy
+	WriteTimeout    time.Duration
 	MaxMsgLen       uint32
 	HTTPTimeout     time.Duration
 	CertFile        string
 	KeyFile         string
+	Subprotocols    []string
+	FrameType       WSFrameType
 	NewAgent        func(*WSConn) Agent
 	ln              net.Listener
 	handler         *WSHandler
+
+	// all origins are allowed if nil
+	CheckOrigin func(r *http.Request) bool
+
+	// called before the upgrade, the request is rejected with 403 if it
+	// returns an error
+	OnUpgrade func(r *http.Request) error
+
+	// IPs or CIDRs of the proxies trusted to set X-Forwarded-For
+	TrustedProxies []string
+
+	// the connections start with a PROXY protocol header
+	ProxyProtocol bool
+
+	// negotiates permessage-deflate, the messages shorter than
+	// CompressionThreshold are not compressed
+	EnableCompression    bool
+	CompressionThreshold int
 }
 
+// serves the WebSocket connections, it may be mounted on any mux
 type WSHandler struct {
 	maxConnNum      int
 	pendingWriteNum int
+	writeOverflow   OverflowPolicy
+	writeTimeout    time.Duration
 	maxMsgLen       uint32
+	frameType       WSFrameType
+	compThreshold   int
 	newAgent        func(*WSConn) Agent
+	onUpgrade       func(r *http.Request) error
+	trustedProxies  []*net.IPNet
 	upgrader        websocket.Upgrader
 	conns           WebsocketConnSet
 	mutexConns      sync.Mutex
@@ -39,6 +68,31 @@ func (handler *WSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method not allowed", 405)
 		return
 	}
+
+	// the request seen by OnUpgrade and the agent has the client address
+	remoteAddr := forwardedAddr(r.RemoteAddr, r.Header["X-Forwarded-For"], handler.trustedProxies)
+	if remoteAddr != nil {
+		r.RemoteAddr = remoteAddr.String()
+	}
+
+	handler.mutexConns.Lock()
+	if handler.conns == nil {
+		handler.mutexConns.Unlock()
+		http.Error(w, "Service unavailable", 503)
+		return
+	}
+	handler.wg.Add(1)
+	handler.mutexConns.Unlock()
+	defer handler.wg.Done()
+
+	if handler.onUpgrade != nil {
+		if err := handler.onUpgrade(r); err != nil {
+			log.Debugf("upgrade rejected: %v", err)
+			http.Error(w, "Forbidden", 403)
+			return
+		}
+	}
+
 	conn, err := handler.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Debugf("upgrade error: %v", err)
@@ -46,9 +100,6 @@ func (handler *WSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	conn.SetReadLimit(int64(handler.maxMsgLen))
 
-	handler.wg.Add(1)
-	defer handler.wg.Done()
-
 	handler.mutexConns.Lock()
 	if handler.conns == nil {
 		handler.mutexConns.Unlock()
@@ -63,8 +114,11 @@ func (handler *WSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	handler.conns[conn] = struct{}{}
 	handler.mutexConns.Unlock()
+	wsMetrics.accepted.Inc()
 
-	wsConn := newWSConn(conn, handler.pendingWriteNum, handler.maxMsgLen)
+	wsConn := newWSConn(conn, handler.pendingWriteNum, handler.writeOverflow, handler.writeTimeout, handler.maxMsgLen, handler.frameType, handler.compThreshold)
+	wsConn.request = r
+	wsConn.remoteAddr = remoteAddr
 	agent := handler.newAgent(wsConn)
 	agent.Run()
 
@@ -73,15 +127,26 @@ func (handler *WSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	handler.mutexConns.Lock()
 	delete(handler.conns, conn)
 	handler.mutexConns.Unlock()
+	wsMetrics.closed.Inc()
 	agent.OnClose()
 }
 
-func (server *WSServer) Start() {
-	ln, err := net.Listen("tcp", server.Addr)
-	if err != nil {
-		log.Fatalf("%v", err)
+// closes all the connections and waits for their agents, the requests
+// served afterwards are rejected
+func (handler *WSHandler) Close() {
+	handler.mutexConns.Lock()
+	for conn := range handler.conns {
+		conn.Close()
 	}
+	handler.conns = nil
+	handler.mutexConns.Unlock()
+
+	handler.wg.Wait()
+}
 
+// the settings of server other than Addr, CertFile, KeyFile and
+// ProxyProtocol are used, invalid ones are reset
+func NewWSHandler(server *WSServer) *WSHandler {
 	if server.MaxConnNum <= 0 {
 		server.MaxConnNum = 100
 		log.Infof("invalid MaxConnNum, reset to %v", server.MaxConnNum)
@@ -98,15 +163,68 @@ func (server *WSServer) Start() {
 		server.HTTPTimeout = 10 * time.Second
 		log.Infof("invalid HTTPTimeout, reset to %v", server.HTTPTimeout)
 	}
+	if server.WriteOverflow == OverflowBlock && server.WriteTimeout <= 0 {
+		server.WriteTimeout = time.Second
+		log.Infof("invalid WriteTimeout, reset to %v", server.WriteTimeout)
+	}
+	if server.EnableCompression && server.CompressionThreshold <= 0 {
+		server.CompressionThreshold = 512
+		log.Infof("invalid CompressionThreshold, reset to %v", server.CompressionThreshold)
+	}
+	if server.FrameType != WSFrameBinary && server.FrameType != WSFrameText {
+		server.FrameType = WSFrameBinary
+		log.Infof("invalid FrameType, reset to binary")
+	}
 	if server.NewAgent == nil {
 		log.Fatalf("NewAgent must not be nil")
 	}
 
+	trustedProxies, err := parseTrustedProxies(server.TrustedProxies)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	checkOrigin := server.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = func(_ *http.Request) bool { return true }
+	}
+
+	return &WSHandler{
+		maxConnNum:      server.MaxConnNum,
+		pendingWriteNum: server.PendingWriteNum,
+		writeOverflow:   server.WriteOverflow,
+		writeTimeout:    server.WriteTimeout,
+		maxMsgLen:       server.MaxMsgLen,
+		frameType:       server.FrameType,
+		compThreshold:   server.CompressionThreshold,
+		newAgent:        server.NewAgent,
+		onUpgrade:       server.OnUpgrade,
+		trustedProxies:  trustedProxies,
+		conns:           make(WebsocketConnSet),
+		upgrader: websocket.Upgrader{
+			HandshakeTimeout:  server.HTTPTimeout,
+			Subprotocols:      server.Subprotocols,
+			CheckOrigin:       checkOrigin,
+			EnableCompression: server.EnableCompression,
+		},
+	}
+}
+
+func (server *WSServer) Start() {
+	ln, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	server.handler = NewWSHandler(server)
+
+	if server.ProxyProtocol {
+		ln = &proxyListener{Listener: ln, timeout: server.HTTPTimeout}
+	}
+
 	if server.CertFile != "" || server.KeyFile != "" {
 		config := &tls.Config{}
 		config.NextProtos = []string{"http/1.1"}
 
-		var err error
 		config.Certificates = make([]tls.Certificate, 1)
 		config.Certificates[0], err = tls.LoadX509KeyPair(server.CertFile, server.KeyFile)
 		if err != nil {
@@ -117,17 +235,6 @@ func (server *WSServer) Start() {
 	}
 
 	server.ln = ln
-	server.handler = &WSHandler{
-		maxConnNum:      server.MaxConnNum,
-		pendingWriteNum: server.PendingWriteNum,
-		maxMsgLen:       server.MaxMsgLen,
-		newAgent:        server.NewAgent,
-		conns:           make(WebsocketConnSet),
-		upgrader: websocket.Upgrader{
-			HandshakeTimeout: server.HTTPTimeout,
-			CheckOrigin:      func(_ *http.Request) bool { return true },
-		},
-	}
 
 	httpServer := &http.Server{
 		Addr:           server.Addr,
@@ -142,13 +249,5 @@ func (server *WSServer) Start() {
 
 func (server *WSServer) Close() {
 	server.ln.Close()
-
-	server.handler.mutexConns.Lock()
-	for conn := range server.handler.conns {
-		conn.Close()
-	}
-	server.handler.conns = nil
-	server.handler.mutexConns.Unlock()
-
-	server.handler.wg.Wait()
+	server.handler.Close()
 }
@@ -0,0 +1,115 @@
+package network
+
+import (
+	"bytes"
+	"errors"
+	"github.com/gorilla/websocket"
+	"strings"
+	"testing"
+	"time"
+)
+
+type wsEchoAgent struct {
+	conn *WSConn
+}
+
+func (a *wsEchoAgent) Run() {
+	for {
+		data, err := a.conn.ReadMsg()
+		if err != nil {
+			return
+		}
+		a.conn.WriteMsg(data)
+	}
+}
+
+func (a *wsEchoAgent) OnClose() {}
+
+func TestWSTextFramesCompression(t *testing.T) {
+	server := new(WSServer)
+	server.Addr = "127.0.0.1:0"
+	server.MaxMsgLen = 1 << 16
+	server.FrameType = WSFrameText
+	server.EnableCompression = true
+	server.CompressionThreshold = 100
+	server.NewAgent = func(conn *WSConn) Agent {
+		return &wsEchoAgent{conn: conn}
+	}
+	server.Start()
+	defer server.Close()
+
+	dialer := websocket.Dialer{EnableCompression: true}
+	conn, resp, err := dialer.Dial("ws://"+server.ln.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if !strings.Contains(resp.Header.Get("Sec-WebSocket-Extensions"), "permessage-deflate") {
+		t.Fatal("permessage-deflate not negotiated")
+	}
+
+	for _, msg := range [][]byte{[]byte("short"), bytes.Repeat([]byte("long"), 1000)} {
+		conn.WriteMessage(websocket.TextMessage, msg)
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if messageType != websocket.TextMessage {
+			t.Fatalf("got message type %v", messageType)
+		}
+		if !bytes.Equal(data, msg) {
+			t.Fatalf("got %v bytes, want %v", len(data), len(msg))
+		}
+	}
+}
+
+type wsClientAgent struct {
+	conn *WSConn
+	msg  []byte
+	err  chan error
+}
+
+func (a *wsClientAgent) Run() {
+	a.conn.WriteMsg(a.msg)
+	data, err := a.conn.ReadMsg()
+	if err == nil && !bytes.Equal(data, a.msg) {
+		err = errors.New("echo mismatch")
+	}
+	a.err <- err
+}
+
+func (a *wsClientAgent) OnClose() {}
+
+func TestWSClientCompression(t *testing.T) {
+	server := new(WSServer)
+	server.Addr = "127.0.0.1:0"
+	server.MaxMsgLen = 1 << 16
+	server.EnableCompression = true
+	server.NewAgent = func(conn *WSConn) Agent {
+		return &wsEchoAgent{conn: conn}
+	}
+	server.Start()
+	defer server.Close()
+
+	a := &wsClientAgent{msg: bytes.Repeat([]byte{1, 2, 3}, 1000), err: make(chan error, 1)}
+	client := new(WSClient)
+	client.Addr = "ws://" + server.ln.Addr().String()
+	client.MaxMsgLen = 1 << 16
+	client.EnableCompression = true
+	client.NewAgent = func(conn *WSConn) Agent {
+		a.conn = conn
+		return a
+	}
+	client.Start()
+	defer client.Close()
+
+	select {
+	case err := <-a.err:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+}
@@ -0,0 +1,190 @@
+package timer
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// the time source of a dispatcher
+// must goroutine safe
+type Clock interface {
+	Now() time.Time
+	// f is called from another goroutine after d
+	AfterFunc(d time.Duration, f func()) ClockTimer
+}
+
+type ClockTimer interface {
+	// false if f has been called or is about to
+	Stop() bool
+}
+
+type realClock struct{}
+
+// the system clock
+var RealClock Clock = realClock{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) ClockTimer {
+	return time.AfterFunc(d, f)
+}
+
+// a clock for the tests, the time only changes and the timers only fire with
+// Advance
+// goroutine safe
+type FakeClock struct {
+	mutex  sync.Mutex
+	now    time.Time
+	timers fakeTimers
+	seq    uint64
+}
+
+type fakeTimer struct {
+	clock *FakeClock
+	when  time.Time
+	// the timers of the same time fire in order of creation
+	seq   uint64
+	f     func()
+	index int
+}
+
+// index is -1 once removed
+type fakeTimers []*fakeTimer
+
+func (h fakeTimers) Len() int {
+	return len(h)
+}
+
+func (h fakeTimers) Less(i, j int) bool {
+	if h[i].when.Equal(h[j].when) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].when.Before(h[j].when)
+}
+
+func (h fakeTimers) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *fakeTimers) Push(x interface{}) {
+	t := x.(*fakeTimer)
+	t.index = len(*h)
+	*h = append(*h, t)
+}
+
+func (h *fakeTimers) Pop() interface{} {
+	old := *h
+	t := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	t.index = -1
+	return t
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	c := new(FakeClock)
+	c.now = now
+	return c
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+// f is called by Advance
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) ClockTimer {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.seq++
+	t := &fakeTimer{clock: c, when: c.now.Add(d), seq: c.seq, f: f}
+	heap.Push(&c.timers, t)
+	return t
+}
+
+func (t *fakeTimer) Stop() bool {
+	c := t.clock
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if t.index < 0 {
+		return false
+	}
+	heap.Remove(&c.timers, t.index)
+	return true
+}
+
+// the next timer due by end, removed, with the time set to its due time,
+// nil if none
+func (c *FakeClock) next(end time.Time) *fakeTimer {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if len(c.timers) == 0 || c.timers[0].when.After(end) {
+		return nil
+	}
+	t := heap.Pop(&c.timers).(*fakeTimer)
+	if t.when.After(c.now) {
+		c.now = t.when
+	}
+	return t
+}
+
+func (c *FakeClock) set(end time.Time) {
+	c.mutex.Lock()
+	c.now = end
+	c.mutex.Unlock()
+}
+
+// moves the time forward by d, the timers due are called in order, from
+// the calling goroutine, with the time set to their due time, including the
+// timers they create
+// the timers of a Dispatcher only send themselves to ChanTimer, they block
+// when it is full: Advance must not be called from the goroutine serving
+// the dispatcher if more timers than the capacity of ChanTimer may be due,
+// and the timers rescheduled by the callbacks, as the next tick of
+// TickFunc or the next run of a cron, do not fire within the same Advance,
+// see AdvanceDispatcher
+func (c *FakeClock) Advance(d time.Duration) {
+	end := c.Now().Add(d)
+	for t := c.next(end); t != nil; t = c.next(end) {
+		t.f()
+	}
+	c.set(end)
+}
+
+// moves the time forward by d as Advance, from the goroutine serving disp,
+// the callbacks of the timers of disp are called after every timer due, so
+// that ChanTimer never blocks, and the timers they reschedule fire within
+// the same call
+// disp must not be a wheel dispatcher, whose timers are sent by a goroutine
+// of its own
+func (c *FakeClock) AdvanceDispatcher(disp *Dispatcher, d time.Duration) {
+	end := c.Now().Add(d)
+	for t := c.next(end); t != nil; t = c.next(end) {
+		done := make(chan struct{})
+		go func() {
+			t.f()
+			close(done)
+		}()
+		for sent := false; !sent; {
+			select {
+			case dt := <-disp.ChanTimer:
+				dt.Cb()
+			case <-done:
+				sent = true
+			}
+		}
+		for len(disp.ChanTimer) > 0 {
+			(<-disp.ChanTimer).Cb()
+		}
+	}
+	c.set(end)
+}
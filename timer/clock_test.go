@@ -0,0 +1,132 @@
+package timer_test
+
+import (
+	"github.com/name5566/leaf/timer"
+	"testing"
+	"time"
+)
+
+var epoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// the callbacks of the timers sent, without waiting
+func drain(d *timer.Dispatcher) int {
+	n := 0
+	for {
+		select {
+		case t := <-d.ChanTimer:
+			t.Cb()
+			n++
+		default:
+			return n
+		}
+	}
+}
+
+func TestFakeClock(t *testing.T) {
+	c := timer.NewFakeClock(epoch)
+
+	var fired []int
+	for _, i := range []int{3, 1, 2} {
+		i := i
+		c.AfterFunc(time.Duration(i)*time.Hour, func() {
+			fired = append(fired, i)
+			if c.Now() != epoch.Add(time.Duration(i)*time.Hour) {
+				t.Fatalf("timer %v fired at %v", i, c.Now())
+			}
+			// due within the same Advance
+			if i == 1 {
+				c.AfterFunc(90*time.Minute, func() { fired = append(fired, 4) })
+			}
+		})
+	}
+	if !c.AfterFunc(time.Minute, func() { t.Fatal("stopped timer fired") }).Stop() {
+		t.Fatal("Stop")
+	}
+
+	c.Advance(time.Hour - 1)
+	if len(fired) != 0 {
+		t.Fatalf("fired %v", fired)
+	}
+	c.Advance(3*time.Hour + 1)
+	if len(fired) != 4 || fired[0] != 1 || fired[1] != 2 || fired[2] != 4 || fired[3] != 3 {
+		t.Fatalf("fired %v", fired)
+	}
+	if c.Now() != epoch.Add(4*time.Hour) {
+		t.Fatalf("now %v", c.Now())
+	}
+}
+
+func TestFakeClockDispatcher(t *testing.T) {
+	ds := map[string]func(c timer.Clock) *timer.Dispatcher{
+		"runtime": func(c timer.Clock) *timer.Dispatcher {
+			return timer.NewDispatcherWithClock(10, c)
+		},
+		"wheel": func(c timer.Clock) *timer.Dispatcher {
+			return timer.NewWheelDispatcherWithClock(10, time.Second, c)
+		},
+	}
+	for name, newDispatcher := range ds {
+		t.Run(name, func(t *testing.T) {
+			c := timer.NewFakeClock(epoch)
+			d := newDispatcher(c)
+			defer d.Close()
+
+			// an hour-long cooldown, and a daily reward
+			cooldown := false
+			d.AfterFunc(time.Hour, func() { cooldown = true })
+			rewards := 0
+			cronExpr, err := timer.NewCronExpr("0 0 0 * * *")
+			if err != nil {
+				t.Fatal(err)
+			}
+			d.CronFunc(cronExpr, func() { rewards++ })
+
+			c.Advance(59 * time.Minute)
+			if drain(d) != 0 {
+				t.Fatal("cooldown fired early")
+			}
+			if r := d.AfterFunc(time.Minute, nil).Remaining(); r != time.Minute {
+				t.Fatalf("remaining %v", r)
+			}
+			c.Advance(time.Minute)
+			drain(d)
+			if !cooldown {
+				t.Fatal("cooldown not fired")
+			}
+
+			for i := 1; i <= 3; i++ {
+				c.Advance(24 * time.Hour)
+				drain(d)
+				if rewards != i {
+					t.Fatalf("%v rewards after %v days", rewards, i)
+				}
+			}
+		})
+	}
+}
+
+func TestAdvanceDispatcher(t *testing.T) {
+	c := timer.NewFakeClock(epoch)
+	d := timer.NewDispatcherWithClock(1, c)
+
+	// more timers due than the capacity of ChanTimer
+	fired := 0
+	for i := 0; i < 5; i++ {
+		d.AfterFunc(time.Second, func() { fired++ })
+	}
+	ticks := 0
+	d.TickFunc(time.Second, timer.FixedRate, func() {
+		ticks++
+		if c.Now() != epoch.Add(time.Duration(ticks)*time.Second) {
+			t.Fatalf("tick %v at %v", ticks, c.Now())
+		}
+	})
+
+	c.AdvanceDispatcher(d, 10*time.Second)
+	if fired != 5 || ticks != 10 {
+		t.Fatalf("%v fired, %v ticks", fired, ticks)
+	}
+	if c.Now() != epoch.Add(10*time.Second) {
+		t.Fatalf("now %v", c.Now())
+	}
+}
@@ -9,14 +9,30 @@ import (
 	"time"
 )
 
-// Field name   | Mandatory? | Allowed values | Allowed special characters
-// ----------   | ---------- | -------------- | --------------------------
-// Seconds      | No         | 0-59           | * / , -
-// Minutes      | Yes        | 0-59           | * / , -
-// Hours        | Yes        | 0-23           | * / , -
-// Day of month | Yes        | 1-31           | * / , -
-// Month        | Yes        | 1-12           | * / , -
-// Day of week  | Yes        | 0-6            | * / , -
+// Field name   | Mandatory? | Allowed values  | Allowed special characters
+// ----------   | ---------- | --------------  | --------------------------
+// Seconds      | No         | 0-59            | * / , -
+// Minutes      | Yes        | 0-59            | * / , -
+// Hours        | Yes        | 0-23            | * / , -
+// Day of month | Yes        | 1-31            | * / , - L W
+// Month        | Yes        | 1-12 or JAN-DEC | * / , -
+// Day of week  | Yes        | 0-6 or SUN-SAT  | * / , - L #
+//
+// L: the last day of the month, 5L: the last friday of the month
+// 15W: the weekday nearest to the 15th, in the same month, LW: the last
+// weekday of the month
+// 5#3: the third friday of the month
+//
+// Descriptors: @yearly (or @annually), @monthly, @weekly, @daily (or
+// @midnight), @hourly and @every <duration>, as @every 1h30m
+//
+// The expression is in the location of the time passed to Next, unless it
+// starts with CRON_TZ=<location> (or TZ=<location>), as
+// CRON_TZ=Asia/Shanghai 0 0 5 * * *
+//
+// Daylight saving time: the times skipped by a transition fire once at the
+// end of the gap, the times repeated fire once, on their first occurrence,
+// unless the expression matches every hour
 type CronExpr struct {
 	sec   uint64
 	min   uint64
@@ -24,11 +40,96 @@ type CronExpr struct {
 	dom   uint64
 	month uint64
 	dow   uint64
+
+	// L, LW and nW of day of month
+	domLast        bool
+	domLastWeekday bool
+	domWeekday     uint64
+	// nL and n#k of day of week, by weekday
+	dowLast uint64
+	dowNth  [7]uint8
+
+	// as parsed
+	expr string
+	// nil if in the location of the time passed to Next
+	loc *time.Location
+	// @every
+	every time.Duration
 }
 
+var (
+	cronDescriptors = map[string]string{
+		"@yearly":   "0 0 0 1 1 *",
+		"@annually": "0 0 0 1 1 *",
+		"@monthly":  "0 0 0 1 * *",
+		"@weekly":   "0 0 0 * * 0",
+		"@daily":    "0 0 0 * * *",
+		"@midnight": "0 0 0 * * *",
+		"@hourly":   "0 0 * * * *",
+	}
+	cronMonths = map[string]int{
+		"JAN": 1, "FEB": 2, "MAR": 3, "APR": 4, "MAY": 5, "JUN": 6,
+		"JUL": 7, "AUG": 8, "SEP": 9, "OCT": 10, "NOV": 11, "DEC": 12,
+	}
+	cronWeekdays = map[string]int{
+		"SUN": 0, "MON": 1, "TUE": 2, "WED": 3, "THU": 4, "FRI": 5, "SAT": 6,
+	}
+)
+
+const (
+	cronAllHours = 1<<24 - 1
+	cronAllDom   = 0xfffffffe
+	cronAllDow   = 0x7f
+)
+
 // goroutine safe
 func NewCronExpr(expr string) (cronExpr *CronExpr, err error) {
+	return NewCronExprIn(expr, nil)
+}
+
+// the expression is in loc, unless it has CRON_TZ=, the location of the time
+// passed to Next if loc is nil
+// goroutine safe
+func NewCronExprIn(expr string, loc *time.Location) (cronExpr *CronExpr, err error) {
 	fields := strings.Fields(expr)
+
+	// location
+	if len(fields) > 0 && (strings.HasPrefix(fields[0], "CRON_TZ=") || strings.HasPrefix(fields[0], "TZ=")) {
+		name := fields[0][strings.Index(fields[0], "=")+1:]
+		loc, err = time.LoadLocation(name)
+		if err != nil {
+			err = fmt.Errorf("invalid expr %v: %v", expr, err)
+			return
+		}
+		fields = fields[1:]
+	}
+
+	// descriptors
+	if len(fields) > 0 && strings.HasPrefix(fields[0], "@") {
+		if fields[0] == "@every" {
+			var every time.Duration
+			if len(fields) == 2 {
+				every, err = time.ParseDuration(fields[1])
+			}
+			if len(fields) != 2 || err != nil || every < time.Second {
+				err = fmt.Errorf("invalid expr %v: expected @every <duration of 1s at least>", expr)
+				return
+			}
+			cronExpr = new(CronExpr)
+			cronExpr.expr = expr
+			cronExpr.loc = loc
+			cronExpr.every = every.Truncate(time.Second)
+			return
+		}
+
+		descriptor, ok := cronDescriptors[fields[0]]
+		if !ok || len(fields) != 1 {
+			err = fmt.Errorf("invalid expr %v: invalid descriptor %v", expr, fields[0])
+			return
+		}
+		fields = strings.Fields(descriptor)
+	}
+
 	if len(fields) != 5 && len(fields) != 6 {
 		err = fmt.Errorf("invalid expr %v: expected 5 or 6 fields, got %v", expr, len(fields))
 		return
@@ -39,33 +140,35 @@ func NewCronExpr(expr string) (cronExpr *CronExpr, err error) {
 	}
 
 	cronExpr = new(CronExpr)
+	cronExpr.expr = expr
+	cronExpr.loc = loc
 	// Seconds
-	cronExpr.sec, err = parseCronField(fields[0], 0, 59)
+	cronExpr.sec, err = parseCronField(fields[0], 0, 59, nil)
 	if err != nil {
 		goto onError
 	}
 	// Minutes
-	cronExpr.min, err = parseCronField(fields[1], 0, 59)
+	cronExpr.min, err = parseCronField(fields[1], 0, 59, nil)
 	if err != nil {
 		goto onError
 	}
 	// Hours
-	cronExpr.hour, err = parseCronField(fields[2], 0, 23)
+	cronExpr.hour, err = parseCronField(fields[2], 0, 23, nil)
 	if err != nil {
 		goto onError
 	}
 	// Day of month
-	cronExpr.dom, err = parseCronField(fields[3], 1, 31)
+	err = cronExpr.parseDom(fields[3])
 	if err != nil {
 		goto onError
 	}
 	// Month
-	cronExpr.month, err = parseCronField(fields[4], 1, 12)
+	cronExpr.month, err = parseCronField(fields[4], 1, 12, cronMonths)
 	if err != nil {
 		goto onError
 	}
 	// Day of week
-	cronExpr.dow, err = parseCronField(fields[5], 0, 6)
+	err = cronExpr.parseDow(fields[5])
 	if err != nil {
 		goto onError
 	}
@@ -76,13 +179,82 @@ onError:
 	return
 }
 
+// the expression parsed
+func (e *CronExpr) String() string {
+	return e.expr
+}
+
+// L, LW and nW, the rest is parsed by parseCronField
+func (e *CronExpr) parseDom(field string) (err error) {
+	var rest []string
+	for _, item := range strings.Split(field, ",") {
+		switch {
+		case item == "L":
+			e.domLast = true
+		case item == "LW":
+			e.domLastWeekday = true
+		case strings.HasSuffix(item, "W"):
+			var day int
+			day, err = strconv.Atoi(item[:len(item)-1])
+			if err != nil || day < 1 || day > 31 {
+				err = fmt.Errorf("invalid weekday: %v", item)
+				return
+			}
+			e.domWeekday |= 1 << uint(day)
+		default:
+			rest = append(rest, item)
+		}
+	}
+
+	if len(rest) > 0 {
+		e.dom, err = parseCronField(strings.Join(rest, ","), 1, 31, nil)
+	}
+	return
+}
+
+// nL and n#k, the rest is parsed by parseCronField
+func (e *CronExpr) parseDow(field string) (err error) {
+	var rest []string
+	for _, item := range strings.Split(field, ",") {
+		if i := strings.Index(item, "#"); i >= 0 {
+			var weekday, nth int
+			weekday, err = parseCronName(item[:i], cronWeekdays)
+			if err != nil || weekday < 0 || weekday > 6 {
+				err = fmt.Errorf("invalid nth weekday: %v", item)
+				return
+			}
+			nth, err = strconv.Atoi(item[i+1:])
+			if err != nil || nth < 1 || nth > 5 {
+				err = fmt.Errorf("invalid nth weekday: %v", item)
+				return
+			}
+			e.dowNth[weekday] |= 1 << uint(nth)
+		} else if len(item) > 1 && strings.HasSuffix(item, "L") {
+			var weekday int
+			weekday, err = parseCronName(item[:len(item)-1], cronWeekdays)
+			if err != nil || weekday < 0 || weekday > 6 {
+				err = fmt.Errorf("invalid last weekday: %v", item)
+				return
+			}
+			e.dowLast |= 1 << uint(weekday)
+		} else {
+			rest = append(rest, item)
+		}
+	}
+
+	if len(rest) > 0 {
+		e.dow, err = parseCronField(strings.Join(rest, ","), 0, 6, cronWeekdays)
+	}
+	return
+}
+
 // 1. *
 // 2. num
 // 3. num-num
 // 4. */num
 // 5. num/num (means num-max/num)
 // 6. num-num/num
-func parseCronField(field string, min int, max int) (cronField uint64, err error) {
+func parseCronField(field string, min int, max int, names map[string]int) (cronField uint64, err error) {
 	fields := strings.Split(field, ",")
 	for _, field := range fields {
 		rangeAndIncr := strings.Split(field, "/")
@@ -108,7 +280,7 @@ func parseCronField(field string, min int, max int) (cronField uint64, err error
 			end = max
 		} else {
 			// start
-			start, err = strconv.Atoi(startAndEnd[0])
+			start, err = parseCronName(startAndEnd[0], names)
 			if err != nil {
 				err = fmt.Errorf("invalid range: %v", rangeAndIncr[0])
 				return
@@ -121,7 +293,7 @@ func parseCronField(field string, min int, max int) (cronField uint64, err error
 					end = start
 				}
 			} else {
-				end, err = strconv.Atoi(startAndEnd[1])
+				end, err = parseCronName(startAndEnd[1], names)
 				if err != nil {
 					err = fmt.Errorf("invalid range: %v", rangeAndIncr[0])
 					return
@@ -171,23 +343,164 @@ func parseCronField(field string, min int, max int) (cronField uint64, err error
 	return
 }
 
+// a number, or a name of names
+func parseCronName(value string, names map[string]int) (int, error) {
+	if n, ok := names[strings.ToUpper(value)]; ok {
+		return n, nil
+	}
+	return strconv.Atoi(value)
+}
+
+func (e *CronExpr) matchDom(t time.Time) bool {
+	day := t.Day()
+	if 1<<uint(day)&e.dom != 0 {
+		return true
+	}
+
+	last := daysIn(t.Year(), t.Month())
+	if e.domLast && day == last {
+		return true
+	}
+	if e.domLastWeekday && day == nearestWeekday(t.Year(), t.Month(), last) {
+		return true
+	}
+	if e.domWeekday != 0 {
+		// the weekday nearest to n is at most 2 days away
+		for n := day - 2; n <= day+2; n++ {
+			if n >= 1 && n <= last && 1<<uint(n)&e.domWeekday != 0 &&
+				nearestWeekday(t.Year(), t.Month(), n) == day {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (e *CronExpr) matchDow(t time.Time) bool {
+	weekday := t.Weekday()
+	if 1<<uint(weekday)&e.dow != 0 {
+		return true
+	}
+	if 1<<uint(weekday)&e.dowLast != 0 && t.Day()+7 > daysIn(t.Year(), t.Month()) {
+		return true
+	}
+	return 1<<uint((t.Day()-1)/7+1)&e.dowNth[weekday] != 0
+}
+
 func (e *CronExpr) matchDay(t time.Time) bool {
+	domBlank := e.dom == cronAllDom && !e.domLast && !e.domLastWeekday && e.domWeekday == 0
+	dowBlank := e.dow == cronAllDow && e.dowLast == 0 && e.dowNth == [7]uint8{}
+
 	// day-of-month blank
-	if e.dom == 0xfffffffe {
-		return 1<<uint(t.Weekday())&e.dow != 0
+	if domBlank {
+		return e.matchDow(t)
 	}
 
 	// day-of-week blank
-	if e.dow == 0x7f {
-		return 1<<uint(t.Day())&e.dom != 0
+	if dowBlank {
+		return e.matchDom(t)
 	}
 
-	return 1<<uint(t.Weekday())&e.dow != 0 ||
-		1<<uint(t.Day())&e.dom != 0
+	return e.matchDow(t) || e.matchDom(t)
+}
+
+func daysIn(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+// the weekday nearest to day n, in the same month
+func nearestWeekday(year int, month time.Month, n int) int {
+	switch time.Date(year, month, n, 0, 0, 0, 0, time.UTC).Weekday() {
+	case time.Saturday:
+		if n == 1 {
+			return n + 2
+		}
+		return n - 1
+	case time.Sunday:
+		if n == daysIn(year, month) {
+			return n - 2
+		}
+		return n + 1
+	}
+	return n
 }
 
 // goroutine safe
 func (e *CronExpr) Next(t time.Time) time.Time {
+	if e.every > 0 {
+		return t.Add(e.every - time.Duration(t.Nanosecond()))
+	}
+
+	loc := e.loc
+	if loc == nil {
+		loc = t.Location()
+	}
+	t = t.In(loc)
+
+	// the instants are walked, the repeated hours match twice
+	if e.hour == cronAllHours {
+		return e.next(t)
+	}
+
+	// the wall clock is walked, in UTC which has no transitions
+	wall := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
+	for {
+		wall = e.next(wall)
+		if wall.IsZero() {
+			return wall
+		}
+		// the first occurrence of a repeated time is before t if t is in the
+		// second one
+		if next := wallToTime(wall, loc); next.After(t) {
+			return next
+		}
+	}
+}
+
+// the first occurrence of wall clock w in loc if repeated, the end of the gap
+// if skipped
+func wallToTime(w time.Time, loc *time.Location) time.Time {
+	// the offsets before and after a transition nearby, transitions are
+	// months apart
+	_, before := w.Add(-36 * time.Hour).In(loc).Zone()
+	_, after := w.Add(36 * time.Hour).In(loc).Zone()
+	t1 := w.Add(-time.Duration(before) * time.Second).In(loc)
+	t2 := w.Add(-time.Duration(after) * time.Second).In(loc)
+	_, off1 := t1.Zone()
+	_, off2 := t2.Zone()
+
+	switch {
+	case off1 == before && off2 == after:
+		if t2.Before(t1) {
+			return t2
+		}
+		return t1
+	case off1 == before:
+		return t1
+	case off2 == after:
+		return t2
+	}
+
+	// skipped, the transition is in (t2, t1]
+	lo, hi := t2, t1
+	for hi.Sub(lo) > time.Second {
+		mid := lo.Add(hi.Sub(lo) / 2).Truncate(time.Second)
+		if _, off := mid.Zone(); off == after {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+	return hi
+}
+
+// the start of the day, time.Date moves a midnight skipped to the day before
+func date(year int, month time.Month, day int, loc *time.Location) time.Time {
+	return wallToTime(time.Date(year, month, day, 0, 0, 0, 0, time.UTC), loc)
+}
+
+// the next time after t matching e, in the location of t
+func (e *CronExpr) next(t time.Time) time.Time {
 	// the upcoming second
 	t = t.Truncate(time.Second).Add(time.Second)
 
@@ -204,10 +517,10 @@ retry:
 	for 1<<uint(t.Month())&e.month == 0 {
 		if !initFlag {
 			initFlag = true
-			t = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+			t = date(t.Year(), t.Month(), 1, t.Location())
 		}
 
-		t = t.AddDate(0, 1, 0)
+		t = date(t.Year(), t.Month()+1, 1, t.Location())
 		if t.Month() == time.January {
 			goto retry
 		}
@@ -217,10 +530,10 @@ retry:
 	for !e.matchDay(t) {
 		if !initFlag {
 			initFlag = true
-			t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+			t = date(t.Year(), t.Month(), t.Day(), t.Location())
 		}
 
-		t = t.AddDate(0, 0, 1)
+		t = date(t.Year(), t.Month(), t.Day()+1, t.Location())
 		if t.Day() == 1 {
 			goto retry
 		}
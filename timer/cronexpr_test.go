@@ -0,0 +1,148 @@
+package timer_test
+
+import (
+	"github.com/name5566/leaf/timer"
+	"testing"
+	"time"
+)
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skip(err)
+	}
+	return loc
+}
+
+func TestCronExprNext(t *testing.T) {
+	tests := []struct {
+		expr  string
+		from  string
+		nexts []string
+	}{
+		{"0 0 * JAN,mar MON-wed", "2021-01-30 00:00:00",
+			[]string{"2021-03-01 00:00:00", "2021-03-02 00:00:00"}},
+		{"0 0 L * *", "2020-02-01 00:00:00",
+			[]string{"2020-02-29 00:00:00", "2020-03-31 00:00:00"}},
+		{"0 0 LW * *", "2021-07-01 00:00:00",
+			[]string{"2021-07-30 00:00:00", "2021-08-31 00:00:00"}},
+		// saturday the 1st, sunday the 15th, and a friday
+		{"0 0 1W,15W * *", "2021-05-01 00:00:00",
+			[]string{"2021-05-03 00:00:00", "2021-05-14 00:00:00", "2021-06-01 00:00:00", "2021-06-15 00:00:00"}},
+		{"0 0 * * 5L", "2021-01-01 00:00:00",
+			[]string{"2021-01-29 00:00:00", "2021-02-26 00:00:00"}},
+		{"0 0 * * FRI#3,1#1", "2021-01-01 00:00:00",
+			[]string{"2021-01-04 00:00:00", "2021-01-15 00:00:00", "2021-02-01 00:00:00"}},
+		{"@daily", "2021-01-01 10:00:00",
+			[]string{"2021-01-02 00:00:00", "2021-01-03 00:00:00"}},
+		{"@hourly", "2021-01-01 10:00:00",
+			[]string{"2021-01-01 11:00:00"}},
+		{"@weekly", "2021-01-01 10:00:00",
+			[]string{"2021-01-03 00:00:00"}},
+		{"@monthly", "2021-01-01 10:00:00",
+			[]string{"2021-02-01 00:00:00"}},
+		{"@yearly", "2021-01-01 10:00:00",
+			[]string{"2022-01-01 00:00:00"}},
+		{"@every 1h30m", "2021-01-01 10:00:00",
+			[]string{"2021-01-01 11:30:00", "2021-01-01 13:00:00"}},
+	}
+
+	for _, test := range tests {
+		e, err := timer.NewCronExpr(test.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		next, _ := time.Parse("2006-01-02 15:04:05", test.from)
+		for _, want := range test.nexts {
+			next = e.Next(next)
+			if got := next.Format("2006-01-02 15:04:05"); got != want {
+				t.Fatalf("%v: got %v, want %v", test.expr, got, want)
+			}
+		}
+	}
+}
+
+func TestCronExprInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"0 0 32W * *",
+		"0 0 * * 7L",
+		"0 0 * * 1#6",
+		"0 0 * FOO *",
+		"@every 1ms",
+		"@every",
+		"@often",
+		"@daily 0",
+		"CRON_TZ=Nowhere/Nothing 0 0 * * *",
+	} {
+		if _, err := timer.NewCronExpr(expr); err == nil {
+			t.Errorf("%v: no error", expr)
+		}
+	}
+}
+
+func TestCronExprLocation(t *testing.T) {
+	shanghai := mustLoadLocation(t, "Asia/Shanghai")
+	e, err := timer.NewCronExpr("CRON_TZ=Asia/Shanghai 0 0 5 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := e.Next(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	if want := time.Date(2021, 1, 1, 5, 0, 0, 0, shanghai).Add(24 * time.Hour); !next.Equal(want) {
+		t.Fatalf("got %v, want %v", next, want)
+	}
+
+	e, err = timer.NewCronExprIn("0 0 5 * * *", shanghai)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next2 := e.Next(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)); !next2.Equal(next) {
+		t.Fatalf("got %v, want %v", next2, next)
+	}
+}
+
+func TestCronExprDST(t *testing.T) {
+	newYork := mustLoadLocation(t, "America/New_York")
+	saoPaulo := mustLoadLocation(t, "America/Sao_Paulo")
+	tests := []struct {
+		expr  string
+		loc   *time.Location
+		from  time.Time
+		nexts []string
+	}{
+		// 2:00 to 2:59 skipped, fired once at the end of the gap
+		{"30 2 * * *", newYork, time.Date(2021, 3, 13, 12, 0, 0, 0, time.UTC),
+			[]string{"2021-03-14 03:00:00 EDT", "2021-03-15 02:30:00 EDT"}},
+		{"*/30 2,3 * * *", newYork, time.Date(2021, 3, 14, 6, 0, 0, 0, time.UTC),
+			[]string{"2021-03-14 03:00:00 EDT", "2021-03-14 03:30:00 EDT", "2021-03-15 02:00:00 EDT"}},
+		// 1:00 to 1:59 repeated, fired once
+		{"30 1 * * *", newYork, time.Date(2021, 11, 6, 12, 0, 0, 0, time.UTC),
+			[]string{"2021-11-07 01:30:00 EDT", "2021-11-08 01:30:00 EST"}},
+		// from the second occurrence
+		{"45 1 * * *", newYork, time.Date(2021, 11, 7, 6, 30, 0, 0, time.UTC),
+			[]string{"2021-11-08 01:45:00 EST"}},
+		// every hour, fired on both occurrences
+		{"30 * * * *", newYork, time.Date(2021, 11, 7, 4, 0, 0, 0, time.UTC),
+			[]string{"2021-11-07 00:30:00 EDT", "2021-11-07 01:30:00 EDT", "2021-11-07 01:30:00 EST", "2021-11-07 02:30:00 EST"}},
+		{"30 * * * *", newYork, time.Date(2021, 3, 14, 5, 0, 0, 0, time.UTC),
+			[]string{"2021-03-14 00:30:00 EST", "2021-03-14 01:30:00 EST", "2021-03-14 03:30:00 EDT"}},
+		// midnight skipped
+		{"0 * 4 11 *", saoPaulo, time.Date(2018, 11, 3, 12, 0, 0, 0, time.UTC),
+			[]string{"2018-11-04 01:00:00 -02", "2018-11-04 02:00:00 -02"}},
+		{"@daily", saoPaulo, time.Date(2018, 11, 3, 12, 0, 0, 0, time.UTC),
+			[]string{"2018-11-04 01:00:00 -02", "2018-11-05 00:00:00 -02"}},
+	}
+
+	for _, test := range tests {
+		e, err := timer.NewCronExprIn(test.expr, test.loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		next := test.from
+		for _, want := range test.nexts {
+			next = e.Next(next)
+			if got := next.Format("2006-01-02 15:04:05 MST"); got != want {
+				t.Fatalf("%v: got %v, want %v", test.expr, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package timer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// the last runs of the jobs
+// must goroutine safe
+type JobStore interface {
+	// zero if the job has never run
+	LastRun(name string) (time.Time, error)
+	SetLastRun(name string, t time.Time) error
+}
+
+// the last runs are kept in a JSON file, the stores of a file must be the
+// same one
+type FileJobStore struct {
+	mutex sync.Mutex
+	path  string
+	// nil until loaded
+	runs map[string]time.Time
+}
+
+func NewFileJobStore(path string) *FileJobStore {
+	s := new(FileJobStore)
+	s.path = path
+	return s
+}
+
+// called with mutex held
+func (s *FileJobStore) load() error {
+	if s.runs != nil {
+		return nil
+	}
+
+	runs := make(map[string]time.Time)
+	data, err := ioutil.ReadFile(s.path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &runs); err != nil {
+			return fmt.Errorf("%v: %v", s.path, err)
+		}
+	}
+	s.runs = runs
+	return nil
+}
+
+// goroutine safe
+func (s *FileJobStore) LastRun(name string) (time.Time, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.load(); err != nil {
+		return time.Time{}, err
+	}
+	return s.runs[name], nil
+}
+
+// the file is replaced, never left half written
+// goroutine safe
+func (s *FileJobStore) SetLastRun(name string, t time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.load(); err != nil {
+		return err
+	}
+	s.runs[name] = t
+
+	data, err := json.MarshalIndent(s.runs, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// what a job does about the runs missed while the process was down
+type CatchUp int
+
+const (
+	// the runs missed are skipped, the job counts as run when added
+	CatchUpNone CatchUp = iota
+	// a single run for all the runs missed
+	CatchUpOnce
+	// a run per run missed, up to MaxCatchUpRuns
+	CatchUpAll
+)
+
+var MaxCatchUpRuns = 100
+
+// cron jobs whose last runs are kept in a store, the runs missed are caught
+// up when a job is added
+// one scheduler per goroutine (goroutine not safe)
+type Scheduler struct {
+	disp  *Dispatcher
+	store JobStore
+	jobs  map[string]*Job
+}
+
+type Job struct {
+	s       *Scheduler
+	name    string
+	expr    *CronExpr
+	catchUp CatchUp
+	cb      func()
+	last    time.Time
+	next    time.Time
+	t       *Timer
+	// the runs caught up
+	catchUps []*Timer
+}
+
+func NewScheduler(disp *Dispatcher, store JobStore) *Scheduler {
+	s := new(Scheduler)
+	s.disp = disp
+	s.store = store
+	s.jobs = make(map[string]*Job)
+	return s
+}
+
+// a job never run before counts as run when added, the runs missed since its
+// last run are caught up as soon as the dispatcher is served
+func (s *Scheduler) Add(name string, expr *CronExpr, catchUp CatchUp, cb func()) (*Job, error) {
+	if _, ok := s.jobs[name]; ok {
+		return nil, fmt.Errorf("job %v is already added", name)
+	}
+	last, err := s.store.LastRun(name)
+	if err != nil {
+		return nil, fmt.Errorf("job %v: %v", name, err)
+	}
+
+	j := new(Job)
+	j.s = s
+	j.name = name
+	j.expr = expr
+	j.catchUp = catchUp
+	j.cb = cb
+	j.last = last
+	s.jobs[name] = j
+
+	now := s.disp.Now()
+	if last.IsZero() {
+		j.last = now
+		j.save()
+	} else {
+		var slots []time.Time
+		for t := expr.Next(last); !t.IsZero() && !t.After(now) && len(slots) < MaxCatchUpRuns; t = expr.Next(t) {
+			slots = append(slots, t)
+		}
+		missed := len(slots)
+		if missed > 0 {
+			s.disp.infof("job %v missed %v runs since %v", name, missed, last)
+		}
+		switch {
+		case missed == 0:
+		case catchUp == CatchUpNone:
+			// skipped for good, not counted again by the next start
+			j.last = now
+			j.save()
+		case catchUp == CatchUpOnce:
+			j.runLater(func() {
+				j.run(now)
+			})
+		default:
+			// each run at the time it makes up for
+			for _, at := range slots {
+				at := at
+				j.runLater(func() {
+					j.run(at)
+				})
+			}
+		}
+	}
+
+	j.schedule()
+	return j, nil
+}
+
+// runs the job now, the next run is unchanged
+func (s *Scheduler) Run(name string) error {
+	j, ok := s.jobs[name]
+	if !ok {
+		return fmt.Errorf("job %v not found", name)
+	}
+	j.run(s.disp.Now())
+	return nil
+}
+
+// sorted by name
+func (s *Scheduler) Jobs() []*Job {
+	jobs := make([]*Job, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		jobs = append(jobs, j)
+	}
+	sort.Slice(jobs, func(i, k int) bool {
+		return jobs[i].name < jobs[k].name
+	})
+	return jobs
+}
+
+func (j *Job) schedule() {
+	now := j.s.disp.Now()
+	j.next = j.expr.Next(now)
+	if j.next.IsZero() {
+		return
+	}
+
+	at := j.next
+	j.t = j.s.disp.AfterFunc(at.Sub(now), func() {
+		defer j.run(at)
+		j.schedule()
+	})
+}
+
+// a pending catch-up, dropped from j.catchUps when it fires
+func (j *Job) runLater(run func()) {
+	var t *Timer
+	t = j.s.disp.AfterFunc(0, func() {
+		for i := range j.catchUps {
+			if j.catchUps[i] == t {
+				j.catchUps = append(j.catchUps[:i], j.catchUps[i+1:]...)
+				break
+			}
+		}
+		run()
+	})
+	j.catchUps = append(j.catchUps, t)
+}
+
+// a scheduled run is at its scheduled time, even if late
+// the run is saved first, a run whose callback panics is not run again
+func (j *Job) run(at time.Time) {
+	j.last = at
+	j.save()
+	j.cb()
+}
+
+func (j *Job) save() {
+	if err := j.s.store.SetLastRun(j.name, j.last); err != nil {
+		j.s.disp.errorf("job %v: %v", j.name, err)
+	}
+}
+
+// the job is removed from its scheduler, the runs not caught up yet are
+// skipped, its last run is kept in the store
+func (j *Job) Stop() {
+	if j.t != nil {
+		j.t.Stop()
+	}
+	for _, t := range j.catchUps {
+		t.Stop()
+	}
+	j.catchUps = nil
+	j.next = time.Time{}
+	if j.s.jobs[j.name] == j {
+		delete(j.s.jobs, j.name)
+	}
+}
+
+func (j *Job) Name() string {
+	return j.name
+}
+
+func (j *Job) Expr() *CronExpr {
+	return j.expr
+}
+
+func (j *Job) LastRun() time.Time {
+	return j.last
+}
+
+// zero if the job never runs again
+func (j *Job) NextRun() time.Time {
+	return j.next
+}
+
+func (j *Job) String() string {
+	next := "never"
+	if !j.next.IsZero() {
+		next = j.next.Format("2006-01-02 15:04:05 MST")
+	}
+	return fmt.Sprintf("%v [%v] last %v, next %v",
+		j.name, j.expr, j.last.Format("2006-01-02 15:04:05 MST"), next)
+}
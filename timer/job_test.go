@@ -0,0 +1,123 @@
+package timer_test
+
+import (
+	"github.com/name5566/leaf/timer"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestScheduler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "jobs.json")
+
+	daily, err := timer.NewCronExpr("0 4 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := timer.NewFakeClock(time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC))
+
+	// first start
+	d := timer.NewDispatcherWithClock(10, c)
+	s := timer.NewScheduler(d, timer.NewFileJobStore(path))
+	runs := 0
+	j, err := s.Add("reset", daily, timer.CatchUpAll, func() { runs++ })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Add("reset", daily, timer.CatchUpAll, func() {}); err == nil {
+		t.Fatal("job added twice")
+	}
+	if j.NextRun() != time.Date(2021, 1, 2, 4, 0, 0, 0, time.UTC) {
+		t.Fatalf("next run %v", j.NextRun())
+	}
+	c.Advance(24 * time.Hour)
+	drain(d)
+	if runs != 1 || j.LastRun() != time.Date(2021, 1, 2, 4, 0, 0, 0, time.UTC) {
+		t.Fatalf("%v runs, last run %v", runs, j.LastRun())
+	}
+
+	if err := s.Run("reset"); err != nil || runs != 2 {
+		t.Fatalf("%v runs: %v", runs, err)
+	}
+	if err := s.Run("none"); err == nil {
+		t.Fatal("unknown job run")
+	}
+	lastRun := j.LastRun()
+
+	// down for three days, three runs missed
+	c.Advance(72 * time.Hour)
+	for _, test := range []struct {
+		catchUp timer.CatchUp
+		runs    int
+	}{
+		{timer.CatchUpNone, 0},
+		{timer.CatchUpOnce, 1},
+		{timer.CatchUpAll, 3},
+	} {
+		store := timer.NewFileJobStore(path)
+		if last, err := store.LastRun("reset"); err != nil || !last.Equal(lastRun) {
+			t.Fatalf("last run %v, want %v: %v", last, lastRun, err)
+		}
+
+		d := timer.NewDispatcherWithClock(10, c)
+		s := timer.NewScheduler(d, store)
+		runs := 0
+		var ats []time.Time
+		j, err := s.Add("reset", daily, test.catchUp, func() {
+			runs++
+			at, _ := store.LastRun("reset")
+			ats = append(ats, at)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Advance(0)
+		drain(d)
+		if runs != test.runs {
+			t.Fatalf("catch up %v: %v runs, want %v", test.catchUp, runs, test.runs)
+		}
+		if test.catchUp == timer.CatchUpAll {
+			for i, at := range ats {
+				if want := time.Date(2021, 1, 3+i, 4, 0, 0, 0, time.UTC); !at.Equal(want) {
+					t.Fatalf("run %v caught up at %v, want %v", i, at, want)
+				}
+			}
+		}
+		if test.catchUp == timer.CatchUpNone {
+			if last, err := store.LastRun("reset"); err != nil || !last.Equal(c.Now()) {
+				t.Fatalf("runs skipped, last run %v, want %v: %v", last, c.Now(), err)
+			}
+		}
+		j.Stop()
+		if len(s.Jobs()) != 0 {
+			t.Fatal("job not stopped")
+		}
+
+		// the runs are saved, restore the last run for the next policy
+		if err := store.SetLastRun("reset", lastRun); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// stopped before catching up
+	d = timer.NewDispatcherWithClock(10, c)
+	s = timer.NewScheduler(d, timer.NewFileJobStore(path))
+	runs = 0
+	j, err = s.Add("reset", daily, timer.CatchUpAll, func() { runs++ })
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.Stop()
+	c.Advance(0)
+	drain(d)
+	if runs != 0 {
+		t.Fatalf("%v runs caught up after Stop", runs)
+	}
+}
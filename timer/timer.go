@@ -4,57 +4,296 @@ import (
 	"github.com/name5566/leaf/conf"
 	"github.com/name5566/leaf/log"
 	"runtime"
+	"sync/atomic"
 	"time"
 )
 
 // one dispatcher per goroutine (goroutine not safe)
 type Dispatcher struct {
 	ChanTimer chan *Timer
+	pending   int32
+	clock     Clock
+	// nil if the timers are timers of the clock
+	wheel *wheel
+	// nil for the package functions of log
+	logger log.Logger
 }
 
+// a runtime timer per Timer
 func NewDispatcher(l int) *Dispatcher {
+	return NewDispatcherWithClock(l, RealClock)
+}
+
+// a timer of clock per Timer
+func NewDispatcherWithClock(l int, clock Clock) *Dispatcher {
 	disp := new(Dispatcher)
 	disp.ChanTimer = make(chan *Timer, l)
+	disp.clock = clock
+	return disp
+}
+
+// the timers share a timing wheel advanced every tick by a single goroutine,
+// they fire up to a tick late, which suits many timers at a coarse
+// resolution, you must call Close when the dispatcher is no longer used
+func NewWheelDispatcher(l int, tick time.Duration) *Dispatcher {
+	return NewWheelDispatcherWithClock(l, tick, RealClock)
+}
+
+func NewWheelDispatcherWithClock(l int, tick time.Duration, clock Clock) *Dispatcher {
+	if tick <= 0 {
+		panic("invalid tick")
+	}
+
+	disp := NewDispatcherWithClock(l, clock)
+	disp.wheel = newWheel(clock, tick)
+	disp.wheel.run(func(t *Timer, stop chan struct{}) {
+		select {
+		case disp.ChanTimer <- t:
+		case <-stop:
+		}
+	})
 	return disp
 }
 
+// the panics of the callbacks, and the jobs of the schedulers of disp, are
+// logged by logger, by the package functions of log if nil
+func (disp *Dispatcher) SetLogger(logger log.Logger) {
+	disp.logger = logger
+}
+
+func (disp *Dispatcher) infof(format string, v ...interface{}) {
+	if disp.logger == nil {
+		log.Infof(format, v...)
+		return
+	}
+	disp.logger.Infof(format, v...)
+}
+
+func (disp *Dispatcher) errorf(format string, v ...interface{}) {
+	if disp.logger == nil {
+		log.Errorf(format, v...)
+		return
+	}
+	disp.logger.Errorf(format, v...)
+}
+
+// the time of the clock of the dispatcher
+// goroutine safe
+func (disp *Dispatcher) Now() time.Time {
+	return disp.clock.Now()
+}
+
+// stops the wheel of the dispatcher, the timers pending never fire
+func (disp *Dispatcher) Close() {
+	if disp.wheel != nil {
+		disp.wheel.close()
+	}
+}
+
 // Timer
 type Timer struct {
-	t  *time.Timer
-	cb func()
+	// the seq of the last schedule sent to ChanTimer
+	fired uint32
+	// incremented by every schedule, a delivery of an older schedule is
+	// ignored by Cb
+	seq  uint32
+	t    ClockTimer
+	cb   func()
+	disp *Dispatcher
+
+	// scheduled and not delivered yet
+	active    bool
+	stopped   bool
+	paused    bool
+	when      time.Time
+	remaining time.Duration
+
+	// ticks
+	period time.Duration
+	mode   TickMode
+
+	// guarded by the mutex of the wheel
+	wheelSeq   uint32
+	expires    uint64
+	slot       *timerList
+	prev, next *Timer
 }
 
+type TickMode int
+
+const (
+	// the ticks are aligned on the first one, the ticks missed while the
+	// callback is late are skipped
+	FixedRate TickMode = iota
+	// the next tick is an interval after the end of the callback
+	FixedDelay
+)
+
 func (t *Timer) Stop() {
-	t.t.Stop()
+	t.cancel()
+	t.stopped = true
+	t.paused = false
 	t.cb = nil
 }
 
+func (t *Timer) cancel() {
+	if t.active && t.disp.unschedule(t) {
+		atomic.AddInt32(&t.disp.pending, -1)
+	}
+	t.active = false
+}
+
+// reschedules t to fire after d, even if it has fired, the ticks go on from
+// then, false if t has been stopped
+func (t *Timer) Reset(d time.Duration) bool {
+	if t.stopped {
+		return false
+	}
+
+	t.cancel()
+	t.paused = false
+	t.disp.schedule(t, d)
+	return true
+}
+
+// the time until t fires, 0 if t is not scheduled, the time left when
+// paused
+func (t *Timer) Remaining() time.Duration {
+	if t.paused {
+		return t.remaining
+	}
+	if !t.active {
+		return 0
+	}
+	if d := t.when.Sub(t.disp.clock.Now()); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// t does not fire until Resume, false if t is not scheduled
+func (t *Timer) Pause() bool {
+	if !t.active {
+		return false
+	}
+
+	t.remaining = t.Remaining()
+	t.cancel()
+	t.paused = true
+	return true
+}
+
+// t fires after the time left when paused, false if t is not paused
+func (t *Timer) Resume() bool {
+	if !t.paused {
+		return false
+	}
+
+	t.paused = false
+	t.disp.schedule(t, t.remaining)
+	return true
+}
+
 func (t *Timer) Cb() {
 	defer func() {
-		t.cb = nil
+		atomic.AddInt32(&t.disp.pending, -1)
 		if r := recover(); r != nil {
 			if conf.LenStackBuf > 0 {
 				buf := make([]byte, conf.LenStackBuf)
 				l := runtime.Stack(buf, false)
-				log.Errorf("%v: %s", r, buf[:l])
+				t.disp.errorf("%v: %s", r, buf[:l])
 			} else {
-				log.Errorf("%v", r)
+				t.disp.errorf("%v", r)
 			}
 		}
 	}()
 
+	// rescheduled, paused or stopped since sent
+	if !t.active || atomic.LoadUint32(&t.fired) != t.seq {
+		return
+	}
+	t.active = false
+
+	if t.period > 0 {
+		defer t.tick()
+	}
 	if t.cb != nil {
 		t.cb()
 	}
 }
 
+// schedules the next tick, unless the callback has stopped, paused or reset t
+func (t *Timer) tick() {
+	if t.stopped || t.paused || t.active {
+		return
+	}
+
+	now := t.disp.clock.Now()
+	d := t.period
+	if t.mode == FixedRate {
+		next := t.when.Add(t.period)
+		if !next.After(now) {
+			next = next.Add((now.Sub(next)/t.period + 1) * t.period)
+		}
+		d = next.Sub(now)
+	}
+	t.disp.schedule(t, d)
+}
+
 func (disp *Dispatcher) AfterFunc(d time.Duration, cb func()) *Timer {
 	t := new(Timer)
 	t.cb = cb
-	t.t = time.AfterFunc(d, func() {
+	t.disp = disp
+	disp.schedule(t, d)
+	return t
+}
+
+// cb is called every d until the timer is stopped
+func (disp *Dispatcher) TickFunc(d time.Duration, mode TickMode, cb func()) *Timer {
+	if d <= 0 {
+		panic("invalid tick interval")
+	}
+
+	t := new(Timer)
+	t.cb = cb
+	t.disp = disp
+	t.period = d
+	t.mode = mode
+	disp.schedule(t, d)
+	return t
+}
+
+// t is sent to ChanTimer after d
+func (disp *Dispatcher) schedule(t *Timer, d time.Duration) {
+	atomic.AddInt32(&disp.pending, 1)
+	t.seq++
+	t.active = true
+	t.when = disp.clock.Now().Add(d)
+
+	if disp.wheel != nil {
+		disp.wheel.add(t, d, t.seq)
+		return
+	}
+
+	seq := t.seq
+	t.t = disp.clock.AfterFunc(d, func() {
+		atomic.StoreUint32(&t.fired, seq)
 		disp.ChanTimer <- t
 	})
-	return t
+}
+
+// false if t has been sent or is about to
+func (disp *Dispatcher) unschedule(t *Timer) bool {
+	if disp.wheel != nil {
+		return disp.wheel.remove(t)
+	}
+	return t.t.Stop()
+}
+
+// the timers not stopped and whose callback is not called yet
+// goroutine safe
+func (disp *Dispatcher) Pending() int {
+	return int(atomic.LoadInt32(&disp.pending))
 }
 
 // Cron
@@ -71,7 +310,7 @@ func (c *Cron) Stop() {
 func (disp *Dispatcher) CronFunc(cronExpr *CronExpr, _cb func()) *Cron {
 	c := new(Cron)
 
-	now := time.Now()
+	now := disp.clock.Now()
 	nextTime := cronExpr.Next(now)
 	if nextTime.IsZero() {
 		return c
@@ -82,7 +321,7 @@ func (disp *Dispatcher) CronFunc(cronExpr *CronExpr, _cb func()) *Cron {
 	cb = func() {
 		defer _cb()
 
-		now := time.Now()
+		now := disp.clock.Now()
 		nextTime := cronExpr.Next(now)
 		if nextTime.IsZero() {
 			return
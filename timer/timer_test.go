@@ -0,0 +1,115 @@
+package timer_test
+
+import (
+	"github.com/name5566/leaf/timer"
+	"testing"
+	"time"
+)
+
+func dispatchers(t *testing.T, f func(t *testing.T, d *timer.Dispatcher)) {
+	t.Run("runtime", func(t *testing.T) {
+		f(t, timer.NewDispatcher(10))
+	})
+	t.Run("wheel", func(t *testing.T) {
+		d := timer.NewWheelDispatcher(10, time.Millisecond)
+		defer d.Close()
+		f(t, d)
+	})
+}
+
+// false if no timer is sent within timeout
+func dispatch(d *timer.Dispatcher, timeout time.Duration) bool {
+	select {
+	case t := <-d.ChanTimer:
+		t.Cb()
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func TestTickFunc(t *testing.T) {
+	dispatchers(t, func(t *testing.T, d *timer.Dispatcher) {
+		for _, mode := range []timer.TickMode{timer.FixedRate, timer.FixedDelay} {
+			n := 0
+			var tm *timer.Timer
+			tm = d.TickFunc(5*time.Millisecond, mode, func() {
+				n++
+				if n == 3 {
+					tm.Stop()
+				}
+			})
+			for n < 3 {
+				if !dispatch(d, time.Second) {
+					t.Fatalf("mode %v: %v ticks", mode, n)
+				}
+			}
+			if dispatch(d, 20*time.Millisecond) || d.Pending() != 0 {
+				t.Fatalf("mode %v: ticks after Stop, %v pending", mode, d.Pending())
+			}
+		}
+	})
+}
+
+func TestReset(t *testing.T) {
+	dispatchers(t, func(t *testing.T, d *timer.Dispatcher) {
+		n := 0
+		tm := d.AfterFunc(time.Hour, func() { n++ })
+		if r := tm.Remaining(); r <= 59*time.Minute {
+			t.Fatalf("remaining %v", r)
+		}
+		tm.Reset(time.Millisecond)
+		if !dispatch(d, time.Second) || n != 1 {
+			t.Fatalf("fired %v times", n)
+		}
+		if tm.Remaining() != 0 {
+			t.Fatalf("remaining %v after firing", tm.Remaining())
+		}
+
+		// fired again
+		tm.Reset(time.Millisecond)
+		if !dispatch(d, time.Second) || n != 2 {
+			t.Fatalf("fired %v times", n)
+		}
+
+		// sent to ChanTimer before the reset
+		tm.Reset(time.Millisecond)
+		time.Sleep(20 * time.Millisecond)
+		tm.Reset(time.Hour)
+		if !dispatch(d, time.Second) || n != 2 || d.Pending() != 1 {
+			t.Fatalf("fired %v times, %v pending", n, d.Pending())
+		}
+
+		tm.Stop()
+		if tm.Reset(time.Millisecond) || d.Pending() != 0 {
+			t.Fatalf("stopped timer reset, %v pending", d.Pending())
+		}
+	})
+}
+
+func TestPause(t *testing.T) {
+	dispatchers(t, func(t *testing.T, d *timer.Dispatcher) {
+		fired := false
+		tm := d.AfterFunc(50*time.Millisecond, func() { fired = true })
+		if !tm.Pause() || tm.Pause() {
+			t.Fatal("Pause")
+		}
+		r := tm.Remaining()
+		if r <= 0 || r > 50*time.Millisecond {
+			t.Fatalf("remaining %v", r)
+		}
+		if dispatch(d, 100*time.Millisecond) || d.Pending() != 0 {
+			t.Fatalf("paused timer fired, %v pending", d.Pending())
+		}
+		if tm.Remaining() != r {
+			t.Fatalf("remaining %v, want %v", tm.Remaining(), r)
+		}
+
+		if !tm.Resume() || tm.Resume() {
+			t.Fatal("Resume")
+		}
+		if !dispatch(d, time.Second) || !fired {
+			t.Fatal("resumed timer not fired")
+		}
+	})
+}
@@ -0,0 +1,223 @@
+package timer
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// a hierarchical timing wheel advanced every tick by a timer of its clock,
+// the timers are rounded up to the tick
+//
+// level 0 has a slot per tick, a slot of level n covers the 64 slots of
+// level n-1 and its timers are moved to level n-1 when level n-1 wraps
+const (
+	wheelBits   = 6
+	wheelSize   = 1 << wheelBits
+	wheelMask   = wheelSize - 1
+	wheelLevels = 5
+	// the timers beyond are kept on the last level until they get closer
+	wheelMaxTicks = 1<<(wheelBits*wheelLevels) - 1
+)
+
+type wheel struct {
+	mutex   sync.Mutex
+	clock   Clock
+	tick    time.Duration
+	start   time.Time
+	current uint64
+	slots   [wheelLevels][wheelSize]timerList
+	// the number of timers by level
+	counts  [wheelLevels]int
+	expired []*Timer
+	// the timer of the next tick, nil once closed
+	ticker ClockTimer
+	closed bool
+	stop   chan struct{}
+}
+
+// a doubly linked list of timers
+type timerList struct {
+	head  *Timer
+	count *int
+}
+
+func (l *timerList) push(t *Timer) {
+	*l.count++
+	t.slot = l
+	t.prev = nil
+	t.next = l.head
+	if l.head != nil {
+		l.head.prev = t
+	}
+	l.head = t
+}
+
+func (l *timerList) remove(t *Timer) {
+	*l.count--
+	if t.prev != nil {
+		t.prev.next = t.next
+	} else {
+		l.head = t.next
+	}
+	if t.next != nil {
+		t.next.prev = t.prev
+	}
+	t.slot = nil
+	t.prev = nil
+	t.next = nil
+}
+
+// removes all the timers
+func (l *timerList) take() *Timer {
+	head := l.head
+	l.head = nil
+	for t := head; t != nil; t = t.next {
+		*l.count--
+		t.slot = nil
+		t.prev = nil
+	}
+	return head
+}
+
+func newWheel(clock Clock, tick time.Duration) *wheel {
+	w := new(wheel)
+	w.clock = clock
+	w.tick = tick
+	w.start = clock.Now()
+	w.stop = make(chan struct{})
+	for level := range w.slots {
+		for i := range w.slots[level] {
+			w.slots[level][i].count = &w.counts[level]
+		}
+	}
+	return w
+}
+
+// fire is called with the expired timers from the timer of the next tick,
+// the next tick is scheduled once they are all fired
+func (w *wheel) run(fire func(t *Timer, stop chan struct{})) {
+	var step func()
+	step = func() {
+		// the ticks missed are caught up
+		for _, t := range w.advance(uint64(w.clock.Now().Sub(w.start) / w.tick)) {
+			fire(t, w.stop)
+		}
+
+		w.mutex.Lock()
+		defer w.mutex.Unlock()
+		if !w.closed {
+			w.ticker = w.clock.AfterFunc(w.tick, step)
+		}
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.ticker = w.clock.AfterFunc(w.tick, step)
+}
+
+func (w *wheel) close() {
+	w.mutex.Lock()
+	w.closed = true
+	if w.ticker != nil {
+		w.ticker.Stop()
+		w.ticker = nil
+	}
+	w.mutex.Unlock()
+	close(w.stop)
+}
+
+// seq is the schedule of t sent when it expires
+func (w *wheel) add(t *Timer, d time.Duration, seq uint32) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	t.wheelSeq = seq
+	// rounded up, and never in the slot being processed
+	expires := uint64((w.clock.Now().Sub(w.start) + d + w.tick - 1) / w.tick)
+	if expires <= w.current {
+		expires = w.current + 1
+	}
+	t.expires = expires
+	w.place(t)
+}
+
+// called with mutex held
+func (w *wheel) place(t *Timer) {
+	expires := t.expires
+	if expires-w.current > wheelMaxTicks {
+		expires = w.current + wheelMaxTicks
+	}
+
+	delta := expires - w.current
+	level := 0
+	for delta >= wheelSize && level < wheelLevels-1 {
+		delta >>= wheelBits
+		level++
+	}
+	w.slots[level][(expires>>(uint(level)*wheelBits))&wheelMask].push(t)
+}
+
+// false if t has expired or is not scheduled
+func (w *wheel) remove(t *Timer) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if t.slot == nil {
+		return false
+	}
+	t.slot.remove(t)
+	return true
+}
+
+// advances the wheel to tick target, returns the expired timers
+// the result is valid until the next call
+func (w *wheel) advance(target uint64) []*Timer {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	for i := range w.expired {
+		w.expired[i] = nil
+	}
+	w.expired = w.expired[:0]
+	for w.current < target {
+		// no timer expires or cascades before the next wrap of the first
+		// level not empty
+		skip := target - 1
+		for level := 0; level < wheelLevels; level++ {
+			if w.counts[level] > 0 {
+				if level > 0 {
+					skip = w.current | (1<<(uint(level)*wheelBits) - 1)
+				} else {
+					skip = w.current
+				}
+				break
+			}
+		}
+		if skip > target-1 {
+			skip = target - 1
+		}
+		w.current = skip + 1
+
+		// cascade
+		for level := 1; level < wheelLevels; level++ {
+			if (w.current>>(uint(level-1)*wheelBits))&wheelMask != 0 {
+				break
+			}
+			for t := w.slots[level][(w.current>>(uint(level)*wheelBits))&wheelMask].take(); t != nil; {
+				next := t.next
+				w.place(t)
+				t = next
+			}
+		}
+
+		for t := w.slots[0][w.current&wheelMask].take(); t != nil; {
+			next := t.next
+			t.next = nil
+			atomic.StoreUint32(&t.fired, t.wheelSeq)
+			w.expired = append(w.expired, t)
+			t = next
+		}
+	}
+	return w.expired
+}
@@ -0,0 +1,116 @@
+package timer
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func placeAt(w *wheel, t *Timer, expires uint64) {
+	w.mutex.Lock()
+	t.expires = expires
+	w.place(t)
+	w.mutex.Unlock()
+}
+
+func TestWheel(t *testing.T) {
+	w := newWheel(RealClock, time.Millisecond)
+	r := rand.New(rand.NewSource(1))
+
+	// every level, and beyond the last one
+	var timers []*Timer
+	for i := 0; i < 10000; i++ {
+		tm := new(Timer)
+		expires := uint64(1) << uint(r.Intn(34))
+		expires += uint64(r.Int63n(int64(expires)))
+		placeAt(w, tm, expires)
+		timers = append(timers, tm)
+	}
+	stopped := timers[:1000]
+	for _, tm := range stopped {
+		if !w.remove(tm) {
+			t.Fatal("timer not removed")
+		}
+	}
+
+	fired := 0
+	for w.current < 1<<35 {
+		from := w.current
+		target := w.current + uint64(r.Int63n(1<<20)) + 1
+		for _, tm := range w.advance(target) {
+			if tm.expires <= from || tm.expires > target {
+				t.Fatalf("timer of tick %v fired in (%v, %v]", tm.expires, from, target)
+			}
+			if w.remove(tm) {
+				t.Fatal("fired timer removed")
+			}
+			fired++
+		}
+	}
+	if fired != len(timers)-len(stopped) {
+		t.Fatalf("%v timers fired, want %v", fired, len(timers)-len(stopped))
+	}
+}
+
+func TestWheelDispatcher(t *testing.T) {
+	d := NewWheelDispatcher(10, time.Millisecond)
+	defer d.Close()
+
+	var fired []int
+	for _, i := range []int{3, 1, 2} {
+		i := i
+		d.AfterFunc(time.Duration(i)*10*time.Millisecond, func() {
+			fired = append(fired, i)
+		})
+	}
+	d.AfterFunc(time.Millisecond, func() {
+		t.Fatal("stopped timer fired")
+	}).Stop()
+	if d.Pending() != 3 {
+		t.Fatalf("%v pending", d.Pending())
+	}
+
+	for len(fired) < 3 {
+		select {
+		case tm := <-d.ChanTimer:
+			tm.Cb()
+		case <-time.After(time.Second):
+			t.Fatal("timers not fired")
+		}
+	}
+	if fired[0] != 1 || fired[1] != 2 || fired[2] != 3 || d.Pending() != 0 {
+		t.Fatalf("fired %v, %v pending", fired, d.Pending())
+	}
+}
+
+// timers mostly stopped before they fire, as buff and cooldown timers
+func benchmarkDispatcher(b *testing.B, d *Dispatcher) {
+	const n = 10000
+	timers := make([]*Timer, n)
+	cb := func() {}
+	for i := range timers {
+		timers[i] = d.AfterFunc(time.Duration(i+1)*time.Millisecond+time.Minute, cb)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		j := i % n
+		timers[j].Stop()
+		timers[j] = d.AfterFunc(time.Duration(j+1)*time.Millisecond+time.Minute, cb)
+	}
+	b.StopTimer()
+
+	for _, t := range timers {
+		t.Stop()
+	}
+}
+
+func BenchmarkRuntimeDispatcher(b *testing.B) {
+	benchmarkDispatcher(b, NewDispatcher(10))
+}
+
+func BenchmarkWheelDispatcher(b *testing.B) {
+	d := NewWheelDispatcher(10, 10*time.Millisecond)
+	defer d.Close()
+	benchmarkDispatcher(b, d)
+}
@@ -0,0 +1,287 @@
+package trace
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/metrics"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Exporter interface {
+	// called from a single goroutine
+	ExportSpans(spans []*SpanData) error
+	Shutdown() error
+}
+
+const (
+	queueLen     = 4096
+	maxBatch     = 512
+	exportPeriod = time.Second
+)
+
+var (
+	enabled     int32
+	mutexExport sync.Mutex
+	queue       = make(chan *SpanData, queueLen)
+	stop        chan struct{}
+	stopped     chan struct{}
+
+	droppedSpans = metrics.NewCounter("leaf_trace_spans_dropped_total", "Spans dropped because the export queue was full.").With()
+
+	// the service.name resource of the exported spans
+	ServiceName = filepath.Base(os.Args[0])
+)
+
+// goroutine safe
+func Enabled() bool {
+	return atomic.LoadInt32(&enabled) == 1
+}
+
+// enables tracing, the ended spans are exported in batches from another
+// goroutine, the previous exporter is shut down
+// goroutine safe
+func SetExporter(exporter Exporter) {
+	Shutdown()
+
+	mutexExport.Lock()
+	defer mutexExport.Unlock()
+	stop = make(chan struct{})
+	stopped = make(chan struct{})
+	go run(exporter, stop, stopped)
+	atomic.StoreInt32(&enabled, 1)
+}
+
+// disables tracing, exports the pending spans and shuts the exporter down
+// goroutine safe
+func Shutdown() {
+	mutexExport.Lock()
+	defer mutexExport.Unlock()
+	if stop == nil {
+		return
+	}
+
+	atomic.StoreInt32(&enabled, 0)
+	close(stop)
+	<-stopped
+	stop = nil
+	stopped = nil
+}
+
+func record(data *SpanData) {
+	if !Enabled() {
+		return
+	}
+
+	select {
+	case queue <- data:
+	default:
+		droppedSpans.Inc()
+	}
+}
+
+func run(exporter Exporter, stop chan struct{}, stopped chan struct{}) {
+	batch := make([]*SpanData, 0, maxBatch)
+	export := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := exporter.ExportSpans(batch); err != nil {
+			log.Errorf("export spans: %v", err)
+		}
+		batch = make([]*SpanData, 0, maxBatch)
+	}
+
+	ticker := time.NewTicker(exportPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case data := <-queue:
+			batch = append(batch, data)
+			if len(batch) == maxBatch {
+				export()
+			}
+		case <-ticker.C:
+			export()
+		case <-stop:
+		drain:
+			for {
+				select {
+				case data := <-queue:
+					batch = append(batch, data)
+					if len(batch) == maxBatch {
+						export()
+					}
+				default:
+					break drain
+				}
+			}
+			export()
+			if err := exporter.Shutdown(); err != nil {
+				log.Errorf("shutdown exporter: %v", err)
+			}
+			close(stopped)
+			return
+		}
+	}
+}
+
+// writes every batch as an OTLP/JSON ExportTraceServiceRequest on its own
+// line, the format of the OpenTelemetry collector file exporter
+type JSONExporter struct {
+	w      io.Writer
+	closer io.Closer
+}
+
+func NewJSONExporter(w io.Writer) *JSONExporter {
+	e := new(JSONExporter)
+	e.w = w
+	return e
+}
+
+// the spans are appended to filename
+func NewFileExporter(filename string) (*JSONExporter, error) {
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	e := NewJSONExporter(file)
+	e.closer = file
+	return e, nil
+}
+
+func (e *JSONExporter) ExportSpans(spans []*SpanData) error {
+	b, err := json.Marshal(newRequest(spans))
+	if err != nil {
+		return err
+	}
+	_, err = e.w.Write(append(b, '\n'))
+	return err
+}
+
+func (e *JSONExporter) Shutdown() error {
+	if e.closer != nil {
+		return e.closer.Close()
+	}
+	return nil
+}
+
+// reference: https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              SpanKind        `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            *otlpStatus     `json:"status,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+// int64 is encoded as a string
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+type otlpStatus struct {
+	Message string `json:"message,omitempty"`
+	// 2 is error
+	Code int `json:"code"`
+}
+
+func newRequest(spans []*SpanData) *otlpRequest {
+	ss := make([]otlpSpan, len(spans))
+	for i, data := range spans {
+		s := &ss[i]
+		s.TraceID = data.TraceID.String()
+		s.SpanID = data.SpanID.String()
+		if data.ParentSpanID.IsValid() {
+			s.ParentSpanID = data.ParentSpanID.String()
+		}
+		s.Name = data.Name
+		s.Kind = data.Kind
+		s.StartTimeUnixNano = strconv.FormatInt(data.Start.UnixNano(), 10)
+		s.EndTimeUnixNano = strconv.FormatInt(data.End.UnixNano(), 10)
+		s.Attributes = newAttributes(data.Attributes)
+		if data.Error != "" {
+			s.Status = &otlpStatus{Message: data.Error, Code: 2}
+		}
+	}
+
+	return &otlpRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource:   otlpResource{Attributes: newAttributes([]Attribute{String("service.name", ServiceName)})},
+			ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "github.com/name5566/leaf"}, Spans: ss}},
+		}},
+	}
+}
+
+func newAttributes(attrs []Attribute) []otlpAttribute {
+	if len(attrs) == 0 {
+		return nil
+	}
+
+	as := make([]otlpAttribute, len(attrs))
+	for i, attr := range attrs {
+		as[i].Key = attr.Key
+		switch v := attr.Value.(type) {
+		case string:
+			as[i].Value.StringValue = &v
+		case bool:
+			as[i].Value.BoolValue = &v
+		case int64:
+			s := strconv.FormatInt(v, 10)
+			as[i].Value.IntValue = &s
+		case float64:
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				s := strconv.FormatFloat(v, 'g', -1, 64)
+				as[i].Value.StringValue = &s
+			} else {
+				as[i].Value.DoubleValue = &v
+			}
+		default:
+			s := fmt.Sprint(v)
+			as[i].Value.StringValue = &s
+		}
+	}
+	return as
+}
@@ -0,0 +1,228 @@
+package trace
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// a span covers one step of a request: the gate creates a span for every
+// inbound message, chanrpc creates the child spans of the calls, the span
+// is carried explicitly, by the calls and their callbacks, see
+// module.Skeleton.Span:
+//
+//	span := trace.StartFrom(skeleton.Span(), "load user")
+//	...
+//	span.End()
+//
+// the spans are recorded only when an exporter is set, the functions are
+// cheap otherwise
+type TraceID [16]byte
+
+type SpanID [8]byte
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+// identifies a span across goroutines and processes
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// the fields of a log message in the span, nil if sc is not valid, the
+// logger of a module adds them, see module.Skeleton.Logger:
+//
+//	log.Infow("user loaded", trace.Fields(sc)...)
+func Fields(sc SpanContext) []interface{} {
+	if !sc.IsValid() {
+		return nil
+	}
+	return []interface{}{"trace_id", sc.TraceID.String(), "span_id", sc.SpanID.String()}
+}
+
+// the W3C traceparent header, to carry the span to another process
+func (sc SpanContext) Traceparent() string {
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-01"
+}
+
+func ParseTraceparent(s string) (SpanContext, error) {
+	var sc SpanContext
+	if len(s) != 55 || s[:3] != "00-" || s[35] != '-' || s[52] != '-' {
+		return sc, fmt.Errorf("invalid traceparent %q", s)
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(s[3:35])); err != nil {
+		return sc, fmt.Errorf("invalid traceparent %q", s)
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(s[36:52])); err != nil {
+		return sc, fmt.Errorf("invalid traceparent %q", s)
+	}
+	if !sc.IsValid() {
+		return sc, errors.New("invalid traceparent: zero id")
+	}
+	return sc, nil
+}
+
+type SpanKind int
+
+// see the OpenTelemetry span kinds
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+)
+
+type Attribute struct {
+	Key string
+	// string, bool, int64 or float64
+	Value interface{}
+}
+
+func String(key string, value string) Attribute {
+	return Attribute{key, value}
+}
+
+func Int(key string, value int) Attribute {
+	return Attribute{key, int64(value)}
+}
+
+func Bool(key string, value bool) Attribute {
+	return Attribute{key, value}
+}
+
+// what the exporters receive
+type SpanData struct {
+	Name         string
+	Kind         SpanKind
+	TraceID      TraceID
+	SpanID       SpanID
+	ParentSpanID SpanID
+	Start        time.Time
+	End          time.Time
+	Attributes   []Attribute
+	// empty if the span succeeded
+	Error string
+}
+
+// one span per goroutine (goroutine not safe)
+// a nil span is valid and records nothing
+type Span struct {
+	data  SpanData
+	ended bool
+}
+
+// the span starts a new trace, nil if tracing is disabled
+func Start(name string, attrs ...Attribute) *Span {
+	return StartFrom(SpanContext{}, name, attrs...)
+}
+
+// the span is a child of parent, or starts a new trace if parent is not
+// valid, nil if tracing is disabled
+func StartFrom(parent SpanContext, name string, attrs ...Attribute) *Span {
+	if !Enabled() {
+		return nil
+	}
+
+	s := new(Span)
+	s.data.Name = name
+	s.data.Kind = SpanKindInternal
+	if parent.IsValid() {
+		s.data.TraceID = parent.TraceID
+		s.data.ParentSpanID = parent.SpanID
+	} else {
+		s.data.TraceID = newTraceID()
+	}
+	s.data.SpanID = newSpanID()
+	s.data.Start = time.Now()
+	s.data.Attributes = attrs
+	return s
+}
+
+// zero if s is nil
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return SpanContext{TraceID: s.data.TraceID, SpanID: s.data.SpanID}
+}
+
+func (s *Span) SetKind(kind SpanKind) {
+	if s != nil {
+		s.data.Kind = kind
+	}
+}
+
+func (s *Span) SetAttributes(attrs ...Attribute) {
+	if s != nil {
+		s.data.Attributes = append(s.data.Attributes, attrs...)
+	}
+}
+
+// err may be nil
+func (s *Span) SetError(err error) {
+	if s != nil && err != nil {
+		s.data.Error = err.Error()
+	}
+}
+
+// the span must not be used after End
+func (s *Span) End() {
+	if s == nil || s.ended {
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	record(&s.data)
+}
+
+// ids
+var (
+	mutexRand sync.Mutex
+	idRand    *rand.Rand
+)
+
+func init() {
+	var seed [8]byte
+	crand.Read(seed[:])
+	idRand = rand.New(rand.NewSource(int64(binary.LittleEndian.Uint64(seed[:]))))
+}
+
+func newTraceID() (id TraceID) {
+	mutexRand.Lock()
+	for !id.IsValid() {
+		idRand.Read(id[:])
+	}
+	mutexRand.Unlock()
+	return
+}
+
+func newSpanID() (id SpanID) {
+	mutexRand.Lock()
+	for !id.IsValid() {
+		idRand.Read(id[:])
+	}
+	mutexRand.Unlock()
+	return
+}
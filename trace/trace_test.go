@@ -0,0 +1,133 @@
+package trace_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/name5566/leaf/chanrpc"
+	"github.com/name5566/leaf/trace"
+	"sync"
+	"testing"
+)
+
+type memExporter struct {
+	mutex sync.Mutex
+	spans []*trace.SpanData
+}
+
+func (e *memExporter) ExportSpans(spans []*trace.SpanData) error {
+	e.mutex.Lock()
+	e.spans = append(e.spans, spans...)
+	e.mutex.Unlock()
+	return nil
+}
+
+func (e *memExporter) Shutdown() error {
+	return nil
+}
+
+func TestPropagation(t *testing.T) {
+	exporter := new(memExporter)
+	trace.SetExporter(exporter)
+
+	s := chanrpc.NewServer(10)
+	var inCalls []trace.SpanContext
+	s.Register("f", func(args []interface{}) {
+		inCalls = append(inCalls, s.Span())
+	})
+	c := s.Open(10)
+
+	root := trace.Start("root")
+	s.GoFrom(root.Context(), "f")
+	s.Go("f")
+
+	var inCb trace.SpanContext
+	c.SetSpan(root.Context())
+	c.AsynCall("f", func(err error) {
+		inCb = c.Span()
+	})
+	root.End()
+
+	for i := 0; i < 3; i++ {
+		s.Exec(<-s.ChanCall)
+	}
+	c.Cb(<-c.ChanAsynRet)
+	for i, sc := range inCalls {
+		traced := i != 1
+		if traced != (sc.TraceID == root.Context().TraceID) || sc.SpanID == root.Context().SpanID {
+			t.Fatalf("call %v span %v, root %v", i, sc, root.Context())
+		}
+	}
+	if inCb != root.Context() {
+		t.Fatalf("callback span %v, want %v", inCb, root.Context())
+	}
+	if s.Span().IsValid() || c.Span().IsValid() {
+		t.Fatal("span still set")
+	}
+
+	trace.Shutdown()
+	if len(exporter.spans) != 3 {
+		t.Fatalf("%v spans exported", len(exporter.spans))
+	}
+	for _, data := range exporter.spans[1:] {
+		if data.Name != "chanrpc f" || data.ParentSpanID != root.Context().SpanID {
+			t.Fatalf("span %+v", data)
+		}
+	}
+
+	// disabled
+	if span := trace.Start("disabled"); span != nil {
+		t.Fatal("span recorded")
+	}
+}
+
+func TestTraceparent(t *testing.T) {
+	trace.SetExporter(new(memExporter))
+	defer trace.Shutdown()
+
+	sc := trace.Start("root").Context()
+	p, err := trace.ParseTraceparent(sc.Traceparent())
+	if err != nil || p != sc {
+		t.Fatalf("got %v %v, want %v", p, err, sc)
+	}
+	if _, err := trace.ParseTraceparent("00-0000-01"); err == nil {
+		t.Fatal("invalid traceparent parsed")
+	}
+}
+
+func TestJSONExporter(t *testing.T) {
+	var b bytes.Buffer
+	trace.SetExporter(trace.NewJSONExporter(&b))
+	span := trace.Start("root", trace.String("s", "v"), trace.Int("i", 1))
+	span.SetError(errors.New("failed"))
+	span.End()
+	trace.Shutdown()
+
+	var req struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []struct {
+					TraceID    string `json:"traceId"`
+					Name       string `json:"name"`
+					Attributes []struct {
+						Key   string                 `json:"key"`
+						Value map[string]interface{} `json:"value"`
+					} `json:"attributes"`
+					Status struct {
+						Code int `json:"code"`
+					} `json:"status"`
+				} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	if err := json.Unmarshal(b.Bytes(), &req); err != nil {
+		t.Fatal(err)
+	}
+	s := req.ResourceSpans[0].ScopeSpans[0].Spans[0]
+	if s.TraceID != span.Context().TraceID.String() || s.Name != "root" || s.Status.Code != 2 {
+		t.Fatalf("got %+v", s)
+	}
+	if s.Attributes[0].Value["stringValue"] != "v" || s.Attributes[1].Value["intValue"] != "1" {
+		t.Fatalf("attributes %+v", s.Attributes)
+	}
+}